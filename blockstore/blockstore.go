@@ -0,0 +1,396 @@
+// Package blockstore archives the blocks of a channel in append-only files, like the block files of a peer.
+// Blocks are stored with their private data, so that the world state can be rebuilt from the archive without
+// contacting a peer (see committer.Committer.Replay).
+package blockstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/arner/hacky-fabric/comm"
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrNotFound is returned for blocks and transactions that are not in the store.
+var ErrNotFound = errors.New("not found")
+
+const filePrefix = "blockfile_"
+
+// Store is an archive of consecutive blocks. Every block file is a sequence of records: the length of the
+// marshaled peer.BlockAndPrivateData as uvarint, followed by the message. A new file is started when the current
+// one exceeds the maximum size. The index by block number and transaction ID is only kept in memory, with an entry
+// for every block and transaction, and Open rebuilds it by reading all block files.
+type Store struct {
+	dir         string
+	maxFileSize int64
+
+	mu       sync.RWMutex
+	file     *os.File // the last block file, open for appending
+	fileNum  int
+	fileSize int64
+	broken   error // if a failed append left an incomplete record that couldn't be removed
+	first    uint64
+	blocks   []location // blocks[i] is block first+i
+	txs      map[string]TxLocation
+	last     *common.BlockHeader
+}
+
+// location is the position of a record in the block files.
+type location struct {
+	file   int
+	offset int64 // of the message, after the length
+	size   int
+}
+
+// TxLocation is the position of a transaction in the chain.
+type TxLocation struct {
+	BlockNum uint64
+	TxNum    uint64
+}
+
+type Option func(*Store)
+
+// WithMaxFileSize sets the size after which a new block file is started. The default is 64MB, like the peer.
+func WithMaxFileSize(size int64) Option {
+	return func(s *Store) {
+		s.maxFileSize = size
+	}
+}
+
+// Open opens the store in dir, or creates it. Every block in the existing files is read to rebuild the index and
+// verify the hash chain, so opening takes time proportional to the length of the chain. An incomplete record at
+// the end of the last file, from a crash while appending, is truncated.
+func Open(dir string, opts ...Option) (*Store, error) {
+	s := &Store{
+		dir:         dir,
+		maxFileSize: 64 * 1024 * 1024,
+		txs:         map[string]TxLocation{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create block store: %w", err)
+	}
+	nums, err := fileNums(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, n := range nums {
+		if n != i {
+			return nil, fmt.Errorf("block file %d is missing", i)
+		}
+		size, err := s.reindexFile(n, i == len(nums)-1)
+		if err != nil {
+			return nil, err
+		}
+		s.fileNum, s.fileSize = n, size
+	}
+	s.file, err = os.OpenFile(s.path(s.fileNum), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open block file: %w", err)
+	}
+	return s, nil
+}
+
+// fileNums returns the numbers of the block files in dir, in order.
+func fileNums(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read block store: %w", err)
+	}
+	var nums []int
+	for _, e := range entries {
+		name, ok := strings.CutPrefix(e.Name(), filePrefix)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		nums = append(nums, n)
+	}
+	slices.Sort(nums)
+	return nums, nil
+}
+
+func (s *Store) path(fileNum int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s%06d", filePrefix, fileNum))
+}
+
+// reindexFile reads and indexes all blocks in a file and returns the size of the valid records. A trailing
+// incomplete record is only allowed, and truncated, in the last file.
+func (s *Store) reindexFile(fileNum int, last bool) (int64, error) {
+	f, err := os.Open(s.path(fileNum))
+	if err != nil {
+		return 0, fmt.Errorf("open block file: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("block file %d: %w", fileNum, err)
+	}
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		msg, n, err := readRecord(r, info.Size()-offset)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) && last {
+			if err := os.Truncate(s.path(fileNum), offset); err != nil {
+				return 0, fmt.Errorf("truncate block file %d: %w", fileNum, err)
+			}
+			return offset, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("block file %d at %d: %w", fileNum, offset, err)
+		}
+		b := &peer.BlockAndPrivateData{}
+		if err := proto.Unmarshal(msg, b); err != nil {
+			return 0, fmt.Errorf("block file %d at %d: %w", fileNum, offset, err)
+		}
+		if err := s.verify(b.Block); err != nil {
+			return 0, fmt.Errorf("block file %d at %d: %w", fileNum, offset, err)
+		}
+		s.index(b.Block, location{file: fileNum, offset: offset + int64(n-len(msg)), size: len(msg)})
+		offset += int64(n)
+	}
+}
+
+// readRecord returns the next message and the number of bytes of the record. It returns io.ErrUnexpectedEOF if
+// the record is longer than the remaining bytes of the file.
+func readRecord(r *bufio.Reader, remaining int64) ([]byte, int, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	n := len(binary.AppendUvarint(nil, size))
+	if size > uint64(remaining-int64(n)) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, 0, err
+	}
+	return msg, n + int(size), nil
+}
+
+// verify checks that the block is the next one in the chain: it has the next number, refers to the hash of the
+// header of the previous block and its data hash matches its data.
+func (s *Store) verify(b *common.Block) error {
+	if b == nil || b.Header == nil || b.Data == nil {
+		return errors.New("incomplete block")
+	}
+	if !bytes.Equal(b.Header.DataHash, fabrictx.BlockDataHash(b.Data)) {
+		return fmt.Errorf("block %d: data hash mismatch", b.Header.Number)
+	}
+	if s.last == nil {
+		return nil
+	}
+	if b.Header.Number != s.last.Number+1 {
+		return fmt.Errorf("expected block %d, got %d", s.last.Number+1, b.Header.Number)
+	}
+	if !bytes.Equal(b.Header.PreviousHash, fabrictx.BlockHeaderHash(s.last)) {
+		return fmt.Errorf("block %d: previous hash mismatch", b.Header.Number)
+	}
+	return nil
+}
+
+// index adds a verified block. The first occurrence of a transaction ID is kept, like the peer does for
+// duplicates.
+func (s *Store) index(b *common.Block, loc location) {
+	if s.last == nil {
+		s.first = b.Header.Number
+	}
+	s.blocks = append(s.blocks, loc)
+	s.last = b.Header
+	for txNum, envBytes := range b.Data.Data {
+		env := &common.Envelope{}
+		if err := proto.Unmarshal(envBytes, env); err != nil {
+			continue
+		}
+		chdr, err := fabrictx.ChannelHeader(env)
+		if err != nil || chdr.TxId == "" {
+			continue
+		}
+		if _, ok := s.txs[chdr.TxId]; !ok {
+			s.txs[chdr.TxId] = TxLocation{BlockNum: b.Header.Number, TxNum: uint64(txNum)}
+		}
+	}
+}
+
+// Append adds the next block to the store. The first block of an empty store can have any number, because a
+// committer doesn't receive the genesis block; every next block must continue the hash chain.
+func (s *Store) Append(b *peer.BlockAndPrivateData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return errors.New("block store closed")
+	}
+	if s.broken != nil {
+		return s.broken
+	}
+	if err := s.verify(b.Block); err != nil {
+		return err
+	}
+	msg, err := proto.Marshal(b)
+	if err != nil {
+		return fmt.Errorf("marshal block %d: %w", b.Block.Header.Number, err)
+	}
+	if s.fileSize > 0 && s.fileSize+int64(len(msg)) > s.maxFileSize {
+		if err := s.nextFile(); err != nil {
+			return err
+		}
+	}
+	record := binary.AppendUvarint(nil, uint64(len(msg)))
+	offset := s.fileSize + int64(len(record))
+	record = append(record, msg...)
+	if err := s.write(record); err != nil {
+		return fmt.Errorf("write block %d: %w", b.Block.Header.Number, err)
+	}
+	s.fileSize += int64(len(record))
+	s.index(b.Block, location{file: s.fileNum, offset: offset, size: len(msg)})
+	return nil
+}
+
+// write appends a record to the last block file. If that fails, the incomplete record is truncated, so that the
+// next record starts at the end of the last complete one. If the truncate fails too, the store is broken until it
+// is opened again, which truncates the record.
+func (s *Store) write(record []byte) error {
+	_, err := s.file.Write(record)
+	if err == nil {
+		if err = s.file.Sync(); err == nil {
+			return nil
+		}
+		err = fmt.Errorf("sync: %w", err)
+	}
+	if terr := s.file.Truncate(s.fileSize); terr != nil {
+		s.broken = fmt.Errorf("block file %d has an incomplete record: %w", s.fileNum, errors.Join(err, terr))
+		return s.broken
+	}
+	return err
+}
+
+func (s *Store) nextFile() error {
+	f, err := os.OpenFile(s.path(s.fileNum+1), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("create block file: %w", err)
+	}
+	if err := s.file.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.fileNum, s.fileSize = f, s.fileNum+1, 0
+	return nil
+}
+
+// Height returns the number of the last block + 1, or 0 if the store is empty.
+func (s *Store) Height() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.last == nil {
+		return 0
+	}
+	return s.last.Number + 1
+}
+
+// Block returns a block with its private data.
+func (s *Store) Block(num uint64) (*peer.BlockAndPrivateData, error) {
+	s.mu.RLock()
+	if num < s.first || num-s.first >= uint64(len(s.blocks)) {
+		s.mu.RUnlock()
+		return nil, fmt.Errorf("block %d: %w", num, ErrNotFound)
+	}
+	loc := s.blocks[num-s.first]
+	s.mu.RUnlock()
+
+	return s.read(loc)
+}
+
+// TxLocation returns the block and position of a transaction.
+func (s *Store) TxLocation(txID string) (TxLocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	loc, ok := s.txs[txID]
+	if !ok {
+		return TxLocation{}, fmt.Errorf("transaction %s: %w", txID, ErrNotFound)
+	}
+	return loc, nil
+}
+
+// BlockByTxID returns the block that contains the transaction, and the position of the transaction in it.
+func (s *Store) BlockByTxID(txID string) (*peer.BlockAndPrivateData, uint64, error) {
+	loc, err := s.TxLocation(txID)
+	if err != nil {
+		return nil, 0, err
+	}
+	b, err := s.Block(loc.BlockNum)
+	if err != nil {
+		return nil, 0, err
+	}
+	return b, loc.TxNum, nil
+}
+
+func (s *Store) read(loc location) (*peer.BlockAndPrivateData, error) {
+	f, err := os.Open(s.path(loc.file))
+	if err != nil {
+		return nil, fmt.Errorf("open block file: %w", err)
+	}
+	defer f.Close()
+	msg := make([]byte, loc.size)
+	if _, err := f.ReadAt(msg, loc.offset); err != nil {
+		return nil, fmt.Errorf("read block file %d at %d: %w", loc.file, loc.offset, err)
+	}
+	b := &peer.BlockAndPrivateData{}
+	if err := proto.Unmarshal(msg, b); err != nil {
+		return nil, fmt.Errorf("read block file %d at %d: %w", loc.file, loc.offset, err)
+	}
+	return b, nil
+}
+
+// Replay invokes the handler for the stored blocks from the starting block up to the current height, in order.
+// It returns the first error of the handler.
+func (s *Store) Replay(startBlock uint64, handle comm.BlockHandler) error {
+	s.mu.RLock()
+	first := s.first
+	s.mu.RUnlock()
+	for num := max(startBlock, first); num < s.Height(); num++ {
+		b, err := s.Block(num)
+		if err != nil {
+			return err
+		}
+		if err := handle(&peer.DeliverResponse_BlockAndPrivateData{BlockAndPrivateData: b}); err != nil {
+			return fmt.Errorf("block %d: %w", num, err)
+		}
+	}
+	return nil
+}
+
+// Close closes the last block file. Blocks can't be appended afterwards.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,132 @@
+package blockstore_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arner/hacky-fabric/blockstore"
+	"github.com/arner/hacky-fabric/cryptogen"
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// newChain returns blocks 1 to n with a transaction each, and the transaction IDs.
+func newChain(t *testing.T, n int) ([]*common.Block, []string) {
+	org1, err := cryptogen.NewOrg(cryptogen.OrgSpec{Domain: "org1.example.com", MSPID: "Org1MSP", Peers: 1, Users: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := org1.Users[0].Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	endorser, err := org1.Peers[0].Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var blocks []*common.Block
+	var txIDs []string
+	prev := []byte("genesis")
+	for i := 1; i <= n; i++ {
+		rws := &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "key", Value: []byte{byte(i)}}}}
+		env, txID, err := fabrictx.NewEndorserTransaction("mychannel", "basic", user, []fabrictx.Signer{endorser}, rws)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := fabrictx.NewBlock(uint64(i), prev, env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] = []byte{byte(peer.TxValidationCode_VALID)}
+		prev = fabrictx.BlockHeaderHash(b.Header)
+		blocks = append(blocks, b)
+		txIDs = append(txIDs, txID)
+	}
+	return blocks, txIDs
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	blocks, txIDs := newChain(t, 5)
+
+	// small files, so that every block is in a file of its own
+	s, err := blockstore.Open(dir, blockstore.WithMaxFileSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range blocks[:4] {
+		if err := s.Append(&peer.BlockAndPrivateData{Block: b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tampered := proto.Clone(blocks[4]).(*common.Block)
+	tampered.Header.PreviousHash = []byte("other")
+	for name, b := range map[string]*common.Block{
+		"already stored": blocks[2],
+		"broken chain":   tampered,
+	} {
+		if err := s.Append(&peer.BlockAndPrivateData{Block: b}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "blockfile_*"))
+	if len(files) != 4 {
+		t.Errorf("expected 4 block files, got %d", len(files))
+	}
+
+	// a crash while appending leaves an incomplete record
+	f, err := os.OpenFile(files[len(files)-1], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{200, 1, 10})
+	f.Close()
+
+	s, err = blockstore.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Append(&peer.BlockAndPrivateData{Block: blocks[4]}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Height() != 6 {
+		t.Errorf("expected height 6, got %d", s.Height())
+	}
+
+	b, txNum, err := s.BlockByTxID(txIDs[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Block.Header.Number != 3 || txNum != 0 || !proto.Equal(b.Block, blocks[2]) {
+		t.Errorf("unexpected block %d:%d for transaction %s", b.Block.Header.Number, txNum, txIDs[2])
+	}
+	for _, num := range []uint64{0, 6} {
+		if _, err := s.Block(num); !errors.Is(err, blockstore.ErrNotFound) {
+			t.Errorf("block %d: expected ErrNotFound, got %v", num, err)
+		}
+	}
+
+	var replayed []uint64
+	err = s.Replay(0, func(b *peer.DeliverResponse_BlockAndPrivateData) error {
+		replayed = append(replayed, b.BlockAndPrivateData.Block.Header.Number)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != 5 || replayed[0] != 1 || replayed[4] != 5 {
+		t.Errorf("unexpected replayed blocks %v", replayed)
+	}
+}
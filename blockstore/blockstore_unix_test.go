@@ -0,0 +1,74 @@
+//go:build unix
+
+package blockstore_test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/arner/hacky-fabric/blockstore"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFailedAppend(t *testing.T) {
+	dir := t.TempDir()
+	blocks, _ := newChain(t, 3)
+	s, err := blockstore.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Append(&peer.BlockAndPrivateData{Block: blocks[0]}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file can only grow by part of the next record
+	info, err := os.Stat(filepath.Join(dir, "blockfile_000000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &syscall.Rlimit{Cur: uint64(info.Size()) + 10, Max: limit.Max}); err != nil {
+		t.Skipf("can't limit the file size: %s", err)
+	}
+	err = s.Append(&peer.BlockAndPrivateData{Block: blocks[1]})
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	if err == nil {
+		t.Fatal("expected the append to fail")
+	}
+
+	for _, b := range blocks[1:] {
+		if err := s.Append(&peer.BlockAndPrivateData{Block: b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, b := range blocks {
+		stored, err := s.Block(b.Header.Number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(stored.Block, b) {
+			t.Errorf("block %d: unexpected block", b.Header.Number)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = blockstore.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Height() != 4 {
+		t.Errorf("expected height 4, got %d", s.Height())
+	}
+}
@@ -0,0 +1,198 @@
+package commtest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/orderer"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+type peerServer struct {
+	peer.UnimplementedEndorserServer
+	peer.UnimplementedDeliverServer
+	s *Server
+}
+
+type ordererServer struct {
+	orderer.UnimplementedAtomicBroadcastServer
+	s *Server
+}
+
+// Broadcast accepts envelopes for the channel and adds them to the next block.
+func (o *ordererServer) Broadcast(stream orderer.AtomicBroadcast_BroadcastServer) error {
+	for {
+		env, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		status := common.Status_SUCCESS
+		if chdr, err := fabrictx.ChannelHeader(env); err != nil {
+			status = common.Status_BAD_REQUEST
+		} else if chdr.ChannelId != o.s.channel {
+			status = common.Status_NOT_FOUND
+		} else {
+			o.s.enqueue(env)
+		}
+		if err := stream.Send(&orderer.BroadcastResponse{Status: status}); err != nil {
+			return err
+		}
+	}
+}
+
+// Deliver sends blocks like an orderer: without the TRANSACTIONS_FILTER.
+func (o *ordererServer) Deliver(stream orderer.AtomicBroadcast_DeliverServer) error {
+	return o.s.serveDeliver(stream.Context(), stream.Recv,
+		func(b *peer.BlockAndPrivateData) error {
+			block := proto.Clone(b.Block).(*common.Block)
+			block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] = nil
+			return stream.Send(&orderer.DeliverResponse{Type: &orderer.DeliverResponse_Block{Block: block}})
+		},
+		func(st common.Status) error {
+			return stream.Send(&orderer.DeliverResponse{Type: &orderer.DeliverResponse_Status{Status: st}})
+		})
+}
+
+func (p *peerServer) Deliver(stream peer.Deliver_DeliverServer) error {
+	return p.s.serveDeliver(stream.Context(), stream.Recv,
+		func(b *peer.BlockAndPrivateData) error {
+			return stream.Send(&peer.DeliverResponse{Type: &peer.DeliverResponse_Block{Block: b.Block}})
+		},
+		func(st common.Status) error {
+			return stream.Send(&peer.DeliverResponse{Type: &peer.DeliverResponse_Status{Status: st}})
+		})
+}
+
+func (p *peerServer) DeliverWithPrivateData(stream peer.Deliver_DeliverWithPrivateDataServer) error {
+	return p.s.serveDeliver(stream.Context(), stream.Recv,
+		func(b *peer.BlockAndPrivateData) error {
+			return stream.Send(&peer.DeliverResponse{Type: &peer.DeliverResponse_BlockAndPrivateData{BlockAndPrivateData: b}})
+		},
+		func(st common.Status) error {
+			return stream.Send(&peer.DeliverResponse{Type: &peer.DeliverResponse_Status{Status: st}})
+		})
+}
+
+// serveDeliver handles the seek requests of a deliver stream one by one: it sends the requested blocks and
+// ends every request with a status.
+func (s *Server) serveDeliver(ctx context.Context, recv func() (*common.Envelope, error), sendBlock func(*peer.BlockAndPrivateData) error, sendStatus func(common.Status) error) error {
+	for {
+		env, err := recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		status, err := s.deliver(ctx, env, sendBlock)
+		if err != nil {
+			return err
+		}
+		if err := sendStatus(status); err != nil {
+			return err
+		}
+	}
+}
+
+// deliver sends the blocks of a seek request. With BLOCK_UNTIL_READY, it waits for blocks that don't exist yet.
+func (s *Server) deliver(ctx context.Context, env *common.Envelope, send func(*peer.BlockAndPrivateData) error) (common.Status, error) {
+	pl := &common.Payload{}
+	if err := proto.Unmarshal(env.Payload, pl); err != nil || pl.Header == nil {
+		return common.Status_BAD_REQUEST, nil
+	}
+	chdr, err := fabrictx.ChannelHeader(env)
+	if err != nil {
+		return common.Status_BAD_REQUEST, nil
+	}
+	if chdr.ChannelId != s.channel {
+		return common.Status_NOT_FOUND, nil
+	}
+	seek := &orderer.SeekInfo{}
+	if err := proto.Unmarshal(pl.Data, seek); err != nil {
+		return common.Status_BAD_REQUEST, nil
+	}
+
+	height := s.Height()
+	start, ok := seekPosition(seek.Start, height)
+	if !ok {
+		return common.Status_BAD_REQUEST, nil
+	}
+	stop, ok := seekPosition(seek.Stop, height)
+	if !ok || stop < start {
+		return common.Status_BAD_REQUEST, nil
+	}
+
+	for num := start; num <= stop; num++ {
+		b, err := s.waitForBlock(ctx, num, seek.Behavior == orderer.SeekInfo_FAIL_IF_NOT_READY)
+		if err != nil {
+			return 0, err
+		}
+		if b == nil {
+			select {
+			case <-s.closed:
+				return common.Status_SERVICE_UNAVAILABLE, nil
+			default:
+				return common.Status_NOT_FOUND, nil
+			}
+		}
+		if err := send(b); err != nil {
+			return 0, err
+		}
+		if num == math.MaxUint64 {
+			break
+		}
+	}
+	return common.Status_SUCCESS, nil
+}
+
+// seekPosition returns the block number of a seek position, given the current height.
+func seekPosition(pos *orderer.SeekPosition, height uint64) (uint64, bool) {
+	if pos == nil {
+		return 0, false
+	}
+	switch t := pos.Type.(type) {
+	case *orderer.SeekPosition_Oldest:
+		return 0, true
+	case *orderer.SeekPosition_Newest:
+		return height - 1, true
+	case *orderer.SeekPosition_Specified:
+		return t.Specified.Number, true
+	default:
+		return 0, false
+	}
+}
+
+// waitForBlock returns the block with the number, or nil if it doesn't exist and failFast is set or the server
+// is closed. It returns an error if the context is done.
+func (s *Server) waitForBlock(ctx context.Context, num uint64, failFast bool) (*peer.BlockAndPrivateData, error) {
+	for {
+		s.mu.Lock()
+		if num < uint64(len(s.blocks)) {
+			b := s.blocks[num]
+			s.mu.Unlock()
+			return b, nil
+		}
+		next := s.newBlock
+		s.mu.Unlock()
+
+		if failFast {
+			return nil, nil
+		}
+		select {
+		case <-next:
+		case <-s.closed:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
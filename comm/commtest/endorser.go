@@ -0,0 +1,116 @@
+package commtest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// ProcessProposal answers the qscc queries GetChainInfo, GetTransactionByID and GetBlockByNumber. Proposals
+// for other chaincodes fail, and responses are not endorsed.
+func (p *peerServer) ProcessProposal(_ context.Context, prop *peer.SignedProposal) (*peer.ProposalResponse, error) {
+	chaincode, args, err := proposalInput(prop)
+	if err != nil {
+		return errorResponse(400, err.Error()), nil
+	}
+	if chaincode != "qscc" {
+		return errorResponse(500, fmt.Sprintf("chaincode %s not found", chaincode)), nil
+	}
+	if len(args) < 2 {
+		return errorResponse(400, "expected a function and a channel"), nil
+	}
+	if string(args[1]) != p.s.channel {
+		return errorResponse(404, fmt.Sprintf("channel %s not found", args[1])), nil
+	}
+
+	var payload proto.Message
+	switch fn := string(args[0]); fn {
+	case "GetChainInfo":
+		payload = p.s.chainInfo()
+	case "GetTransactionByID":
+		if len(args) < 3 {
+			return errorResponse(400, "expected a transaction ID"), nil
+		}
+		tx, ok := p.s.transaction(string(args[2]))
+		if !ok {
+			return errorResponse(500, fmt.Sprintf("transaction %s not found", args[2])), nil
+		}
+		payload = tx
+	case "GetBlockByNumber":
+		if len(args) < 3 {
+			return errorResponse(400, "expected a block number"), nil
+		}
+		num, err := strconv.ParseUint(string(args[2]), 10, 64)
+		if err != nil {
+			return errorResponse(400, fmt.Sprintf("invalid block number: %s", err)), nil
+		}
+		b, ok := p.s.Block(num)
+		if !ok {
+			return errorResponse(500, fmt.Sprintf("block %d not found", num)), nil
+		}
+		payload = b
+	default:
+		return errorResponse(400, fmt.Sprintf("function %s not supported", fn)), nil
+	}
+
+	b, err := proto.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &peer.ProposalResponse{Version: 1, Response: &peer.Response{Status: 200, Payload: b}}, nil
+}
+
+func (s *Server) chainInfo() *common.BlockchainInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last := s.blocks[len(s.blocks)-1].Block.Header
+	return &common.BlockchainInfo{
+		Height:            uint64(len(s.blocks)),
+		CurrentBlockHash:  fabrictx.BlockHeaderHash(last),
+		PreviousBlockHash: last.PreviousHash,
+	}
+}
+
+func (s *Server) transaction(txID string) (*peer.ProcessedTransaction, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loc, ok := s.txs[txID]
+	if !ok {
+		return nil, false
+	}
+	env := &common.Envelope{}
+	if err := proto.Unmarshal(s.blocks[loc.block].Block.Data.Data[loc.index], env); err != nil {
+		return nil, false
+	}
+	return &peer.ProcessedTransaction{TransactionEnvelope: env, ValidationCode: int32(loc.code)}, true
+}
+
+// proposalInput returns the chaincode name and arguments of a proposal.
+func proposalInput(prop *peer.SignedProposal) (string, [][]byte, error) {
+	p := &peer.Proposal{}
+	if err := proto.Unmarshal(prop.ProposalBytes, p); err != nil {
+		return "", nil, fmt.Errorf("proposal: %w", err)
+	}
+	pl := &peer.ChaincodeProposalPayload{}
+	if err := proto.Unmarshal(p.Payload, pl); err != nil {
+		return "", nil, fmt.Errorf("proposal payload: %w", err)
+	}
+	spec := &peer.ChaincodeInvocationSpec{}
+	if err := proto.Unmarshal(pl.Input, spec); err != nil {
+		return "", nil, fmt.Errorf("invocation spec: %w", err)
+	}
+	if spec.ChaincodeSpec == nil || spec.ChaincodeSpec.ChaincodeId == nil || spec.ChaincodeSpec.Input == nil {
+		return "", nil, fmt.Errorf("chaincode spec missing")
+	}
+	return spec.ChaincodeSpec.ChaincodeId.Name, spec.ChaincodeSpec.Input.Args, nil
+}
+
+func errorResponse(status int32, msg string) *peer.ProposalResponse {
+	return &peer.ProposalResponse{Version: 1, Response: &peer.Response{Status: status, Message: msg}}
+}
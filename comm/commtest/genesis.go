@@ -0,0 +1,24 @@
+package commtest
+
+import (
+	"github.com/arner/hacky-fabric/config"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+)
+
+// genesisBlock returns the config block of the channel, with the orderer organization of the server and the
+// application organizations of the options.
+func (s *Server) genesisBlock() (*common.Block, error) {
+	var orgs []*msp.FabricMSPConfig
+	for _, o := range s.orgs {
+		orgs = append(orgs, o.MSPConfig())
+	}
+	return config.GenesisBlock(s.channel, config.Genesis{
+		OrdererOrgs:      []*msp.FabricMSPConfig{s.Orderer.MSPConfig()},
+		ApplicationOrgs:  orgs,
+		OrdererAddresses: []string{s.Addr},
+		BatchSize:        config.BatchSize{MaxMessageCount: uint32(s.batchSize)},
+		BatchTimeout:     s.batchTimeout.String(),
+	})
+}
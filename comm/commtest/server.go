@@ -0,0 +1,277 @@
+// Package commtest provides an in-process peer and orderer to test the comm clients and the committer without
+// a Fabric network, in the spirit of net/http/httptest.
+package commtest
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/arner/hacky-fabric/cryptogen"
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/orderer"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/proto"
+)
+
+// ValidateFunc assigns the validation code to a transaction, like the validation of a peer. Duplicate
+// transaction IDs are detected by the server before it is called.
+type ValidateFunc func(env *common.Envelope) peer.TxValidationCode
+
+// Server is a peer and orderer for a single channel. It listens on localhost with TLS and serves the Endorser,
+// Deliver and AtomicBroadcast services on the same address, so that comm.NewPeer, comm.NewOrderer and the
+// committer can connect to it. Broadcast envelopes are cut into blocks, which get a validation code per
+// transaction and are signed by the orderer. Block 0 is a config block with the MSPs of the organizations.
+type Server struct {
+	Addr      string // 127.0.0.1:<port>
+	TLSCACert []byte // PEM encoded CA of the TLS certificate of the server
+	Orderer   *cryptogen.Org
+
+	channel      string
+	orgs         []*cryptogen.Org
+	batchSize    int
+	batchTimeout time.Duration
+	validate     ValidateFunc
+	signer       fabrictx.Signer
+	grpc         *grpc.Server
+
+	mu       sync.Mutex
+	blocks   []*peer.BlockAndPrivateData
+	txs      map[string]txLocation
+	pending  []*common.Envelope
+	timer    *time.Timer
+	pvtData  map[string]*rwset.TxPvtReadWriteSet
+	newBlock chan struct{} // closed and replaced when a block is added
+	closed   chan struct{}
+}
+
+// txLocation is the position and validation code of a committed transaction.
+type txLocation struct {
+	block uint64
+	index int
+	code  peer.TxValidationCode
+}
+
+type Option func(*Server)
+
+// WithOrgs adds the MSPs of the organizations to the application group of the genesis block.
+func WithOrgs(orgs ...*cryptogen.Org) Option {
+	return func(s *Server) {
+		s.orgs = append(s.orgs, orgs...)
+	}
+}
+
+// WithBatchSize sets the maximum number of transactions in a block and the time after which a block is cut
+// with fewer transactions. The default is 10 transactions or 100ms.
+func WithBatchSize(maxMessageCount int, timeout time.Duration) Option {
+	return func(s *Server) {
+		s.batchSize = maxMessageCount
+		s.batchTimeout = timeout
+	}
+}
+
+// WithValidator sets the function that assigns validation codes. By default, all transactions are valid.
+func WithValidator(validate ValidateFunc) Option {
+	return func(s *Server) {
+		s.validate = validate
+	}
+}
+
+// NewServer starts a server for the channel. Close stops it.
+func NewServer(channel string, opts ...Option) (*Server, error) {
+	ordererOrg, err := cryptogen.NewOrg(cryptogen.OrgSpec{Domain: "example.com", MSPID: "OrdererMSP", Orderers: 1})
+	if err != nil {
+		return nil, err
+	}
+	node := ordererOrg.Orderers[0]
+	signer, err := node.Signer()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := node.TLSCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		TLSCACert:    ordererOrg.TLSCA.Cert,
+		Orderer:      ordererOrg,
+		channel:      channel,
+		batchSize:    10,
+		batchTimeout: 100 * time.Millisecond,
+		validate:     func(*common.Envelope) peer.TxValidationCode { return peer.TxValidationCode_VALID },
+		signer:       signer,
+		txs:          map[string]txLocation{},
+		pvtData:      map[string]*rwset.TxPvtReadWriteSet{},
+		newBlock:     make(chan struct{}),
+		closed:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	s.Addr = lis.Addr().String()
+
+	genesis, err := s.genesisBlock()
+	if err != nil {
+		lis.Close()
+		return nil, fmt.Errorf("genesis block: %w", err)
+	}
+	s.addBlock(genesis, nil)
+
+	s.grpc = grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{cert}})))
+	ps := &peerServer{s: s}
+	peer.RegisterEndorserServer(s.grpc, ps)
+	peer.RegisterDeliverServer(s.grpc, ps)
+	orderer.RegisterAtomicBroadcastServer(s.grpc, &ordererServer{s: s})
+	go s.grpc.Serve(lis)
+	return s, nil
+}
+
+// Close stops the server. Open deliver streams end with SERVICE_UNAVAILABLE.
+func (s *Server) Close() {
+	s.mu.Lock()
+	select {
+	case <-s.closed:
+		s.mu.Unlock()
+		return
+	default:
+	}
+	close(s.closed)
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.mu.Unlock()
+	s.grpc.Stop()
+}
+
+// Height returns the number of blocks, including the genesis block.
+func (s *Server) Height() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return uint64(len(s.blocks))
+}
+
+// Block returns a block as it is delivered by the peer, with the TRANSACTIONS_FILTER set.
+func (s *Server) Block(num uint64) (*common.Block, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if num >= uint64(len(s.blocks)) {
+		return nil, false
+	}
+	return s.blocks[num].Block, true
+}
+
+// AddPrivateData registers the private data of a transaction (from fabrictx.NewEndorserTxWithPrivateData), which is
+// delivered with its block by DeliverWithPrivateData if the transaction is valid. It must be added before the
+// transaction is broadcast.
+func (s *Server) AddPrivateData(txID string, pvt *rwset.TxPvtReadWriteSet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pvtData[txID] = pvt
+}
+
+// Cut cuts a block with the pending transactions, if there are any, without waiting for the batch timeout.
+func (s *Server) Cut() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cutLocked()
+}
+
+// enqueue adds a broadcast envelope to the next block.
+func (s *Server) enqueue(env *common.Envelope) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, env)
+	if len(s.pending) >= s.batchSize {
+		s.cutLocked()
+		return
+	}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.batchTimeout, s.Cut)
+	}
+}
+
+// cutLocked creates a block from the pending envelopes and assigns the validation codes.
+func (s *Server) cutLocked() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if len(s.pending) == 0 {
+		return
+	}
+	envs := s.pending
+	s.pending = nil
+
+	prev := s.blocks[len(s.blocks)-1].Block
+	block, err := fabrictx.NewBlock(prev.Header.Number+1, fabrictx.BlockHeaderHash(prev.Header), envs...)
+	if err != nil {
+		// the envelopes were unmarshaled from the stream, so they can be marshaled again
+		panic(err)
+	}
+
+	codes := make([]peer.TxValidationCode, len(envs))
+	inBlock := map[string]bool{}
+	for i, env := range envs {
+		chdr, err := fabrictx.ChannelHeader(env)
+		if err != nil {
+			codes[i] = peer.TxValidationCode_BAD_PAYLOAD
+			continue
+		}
+		if _, ok := s.txs[chdr.TxId]; ok || inBlock[chdr.TxId] {
+			codes[i] = peer.TxValidationCode_DUPLICATE_TXID
+			continue
+		}
+		inBlock[chdr.TxId] = true
+		codes[i] = s.validate(env)
+	}
+	s.addBlock(block, codes)
+}
+
+// addBlock sets the validation codes, signs the block, adds it to the chain and wakes up the deliver streams.
+func (s *Server) addBlock(block *common.Block, codes []peer.TxValidationCode) {
+	filter := make([]byte, len(block.Data.Data))
+	bpd := &peer.BlockAndPrivateData{Block: block, PrivateDataMap: map[uint64]*rwset.TxPvtReadWriteSet{}}
+	for i := range block.Data.Data {
+		code := peer.TxValidationCode_VALID
+		if i < len(codes) {
+			code = codes[i]
+		}
+		filter[i] = byte(code)
+
+		env := &common.Envelope{}
+		if err := proto.Unmarshal(block.Data.Data[i], env); err != nil {
+			continue
+		}
+		chdr, err := fabrictx.ChannelHeader(env)
+		if err != nil || chdr.TxId == "" || code == peer.TxValidationCode_DUPLICATE_TXID {
+			continue
+		}
+		s.txs[chdr.TxId] = txLocation{block: block.Header.Number, index: i, code: code}
+		if pvt, ok := s.pvtData[chdr.TxId]; ok && code == peer.TxValidationCode_VALID {
+			bpd.PrivateDataMap[uint64(i)] = pvt
+		}
+		delete(s.pvtData, chdr.TxId)
+	}
+	block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] = filter
+	if err := fabrictx.SignBlock(block, s.signer, 0); err != nil {
+		// the key is in memory, so signing doesn't fail
+		panic(err)
+	}
+
+	s.blocks = append(s.blocks, bpd)
+	close(s.newBlock)
+	s.newBlock = make(chan struct{})
+}
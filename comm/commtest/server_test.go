@@ -0,0 +1,153 @@
+package commtest_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/arner/hacky-fabric/comm"
+	"github.com/arner/hacky-fabric/comm/commtest"
+	"github.com/arner/hacky-fabric/config"
+	"github.com/arner/hacky-fabric/cryptogen"
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+var errDone = errors.New("done")
+
+func TestServer(t *testing.T) {
+	org1, err := cryptogen.NewOrg(cryptogen.OrgSpec{Domain: "org1.example.com", MSPID: "Org1MSP", Peers: 1, Users: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := org1.Users[0].Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	endorser, err := org1.Peers[0].Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := commtest.NewServer("mychannel", commtest.WithOrgs(org1), commtest.WithBatchSize(3, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	genesis, ok := s.Block(0)
+	if !ok {
+		t.Fatal("expected a genesis block")
+	}
+	conf, err := config.FromBlock(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conf.MSPs()["Org1MSP"]; !ok {
+		t.Errorf("expected Org1MSP in the genesis block, got %v", conf.MSPs())
+	}
+
+	orderer, err := comm.NewOrderer(s.Addr, s.TLSCACert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orderer.Close()
+
+	rws := &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "key", Value: []byte("value")}}}
+	env, txID, err := fabrictx.NewEndorserTransaction("mychannel", "basic", user, []fabrictx.Signer{endorser}, rws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second one is a duplicate, and the block is cut after the timeout
+	for range 2 {
+		if err := orderer.Broadcast(env); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other, _, err := fabrictx.NewEndorserTransaction("otherchannel", "basic", user, []fabrictx.Signer{endorser}, rws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := orderer.Broadcast(other); err == nil {
+		t.Error("expected a transaction for another channel to be rejected")
+	}
+
+	p, err := comm.NewPeer(s.Addr, s.TLSCACert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	err = p.SubscribeBlocks("mychannel", 1, user, func(b *peer.DeliverResponse_BlockAndPrivateData) error {
+		block := b.BlockAndPrivateData.Block
+		if block.Header.Number != 1 {
+			t.Fatalf("expected block 1, got %d", block.Header.Number)
+		}
+		if !proto.Equal(&common.BlockHeader{
+			Number:       1,
+			PreviousHash: fabrictx.BlockHeaderHash(genesis.Header),
+			DataHash:     fabrictx.BlockDataHash(block.Data),
+		}, block.Header) {
+			t.Errorf("unexpected header %v", block.Header)
+		}
+		filter := block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
+		if len(filter) != 2 || filter[0] != byte(peer.TxValidationCode_VALID) || filter[1] != byte(peer.TxValidationCode_DUPLICATE_TXID) {
+			t.Errorf("unexpected transactions filter %v", filter)
+		}
+		return errDone
+	})
+	if !errors.Is(err, errDone) {
+		t.Fatal(err)
+	}
+
+	// the orderer delivers the same block without validation codes
+	err = orderer.SubscribeBlocks("mychannel", 1, user, func(b *peer.DeliverResponse_BlockAndPrivateData) error {
+		if filter := b.BlockAndPrivateData.Block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER]; len(filter) != 0 {
+			t.Errorf("expected no transactions filter from the orderer, got %v", filter)
+		}
+		return errDone
+	})
+	if !errors.Is(err, errDone) {
+		t.Fatal(err)
+	}
+
+	info := &common.BlockchainInfo{}
+	query(t, p, user, info, "GetChainInfo", "mychannel")
+	if info.Height != 2 {
+		t.Errorf("expected height 2, got %d", info.Height)
+	}
+	tx := &peer.ProcessedTransaction{}
+	query(t, p, user, tx, "GetTransactionByID", "mychannel", txID)
+	if tx.ValidationCode != int32(peer.TxValidationCode_VALID) {
+		t.Errorf("expected a valid transaction, got %s", peer.TxValidationCode(tx.ValidationCode))
+	}
+	block := &common.Block{}
+	query(t, p, user, block, "GetBlockByNumber", "mychannel", strconv.Itoa(1))
+	if block.Header.Number != 1 {
+		t.Errorf("expected block 1, got %d", block.Header.Number)
+	}
+}
+
+func query(t *testing.T, p *comm.Peer, signer fabrictx.Signer, out proto.Message, args ...string) {
+	t.Helper()
+	var bargs [][]byte
+	for _, a := range args {
+		bargs = append(bargs, []byte(a))
+	}
+	prop, err := fabrictx.NewProposal(signer, "mychannel", "qscc", bargs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := p.ProcessProposal(prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := proto.Unmarshal(res.Response.Payload, out); err != nil {
+		t.Fatal(err)
+	}
+}
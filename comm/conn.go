@@ -0,0 +1,35 @@
+package comm
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// newTLSConn creates a gRPC client connection that verifies the server certificate against the provided TLS CA.
+// The role is only used in error messages.
+func newTLSConn(role, addr string, tlsPem []byte) (*grpc.ClientConn, error) {
+	roots := x509.NewCertPool()
+	if ok := roots.AppendCertsFromPEM(tlsPem); !ok {
+		return nil, fmt.Errorf("failed to append %s TLS cert", role)
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s address [%s] must contain port: %w", role, addr, err)
+	}
+	creds := credentials.NewTLS(&tls.Config{
+		RootCAs:    roots,
+		ServerName: host,
+	})
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", role, err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,219 @@
+package comm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/orderer"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Gateway is a client for the Fabric Gateway service that runs on a peer. It takes care of
+// endorsement, ordering and commit status, so only a single peer endpoint is needed.
+type Gateway struct {
+	conn   *grpc.ClientConn
+	client gateway.GatewayClient
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewGateway(addr string, tlsPem []byte) (*Gateway, error) {
+	conn, err := newTLSConn("gateway", addr, tlsPem)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Gateway{
+		conn:   conn,
+		client: gateway.NewGatewayClient(conn),
+	}
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+	return g, nil
+}
+
+// Evaluate sends a proposal (from fabrictx.NewProposal) to a peer of one of the target organizations
+// (or any peer if none are given) and returns the result without submitting a transaction.
+func (g *Gateway) Evaluate(prop *peer.SignedProposal, targetOrgs ...string) (*peer.Response, error) {
+	chdr, err := fabrictx.ProposalHeader(prop)
+	if err != nil {
+		return nil, err
+	}
+	res, err := g.client.Evaluate(g.ctx, &gateway.EvaluateRequest{
+		TransactionId:       chdr.TxId,
+		ChannelId:           chdr.ChannelId,
+		ProposedTransaction: prop,
+		TargetOrganizations: targetOrgs,
+	})
+	if err != nil {
+		return nil, gatewayError("evaluate", err)
+	}
+	return res.Result, nil
+}
+
+// Endorse lets the gateway collect endorsements for a proposal (from fabrictx.NewProposal) and returns the
+// resulting transaction, signed by the submitter and ready to Submit.
+func (g *Gateway) Endorse(submitter fabrictx.Signer, prop *peer.SignedProposal, endorsingOrgs ...string) (*common.Envelope, error) {
+	chdr, err := fabrictx.ProposalHeader(prop)
+	if err != nil {
+		return nil, err
+	}
+	res, err := g.client.Endorse(g.ctx, &gateway.EndorseRequest{
+		TransactionId:          chdr.TxId,
+		ChannelId:              chdr.ChannelId,
+		ProposedTransaction:    prop,
+		EndorsingOrganizations: endorsingOrgs,
+	})
+	if err != nil {
+		return nil, gatewayError("endorse", err)
+	}
+
+	env := res.GetPreparedTransaction()
+	if env == nil {
+		return nil, errors.New("endorse: no prepared transaction in the response")
+	}
+	env.Signature, err = submitter.Sign(env.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("sign prepared transaction: %w", err)
+	}
+	return env, nil
+}
+
+// Submit sends a signed and endorsed transaction (for instance from fabrictx.NewEndorserTransaction)
+// to the orderer through the gateway. It returns the transaction ID.
+func (g *Gateway) Submit(env *common.Envelope) (string, error) {
+	chdr, err := fabrictx.ChannelHeader(env)
+	if err != nil {
+		return "", err
+	}
+	_, err = g.client.Submit(g.ctx, &gateway.SubmitRequest{
+		TransactionId:       chdr.TxId,
+		ChannelId:           chdr.ChannelId,
+		PreparedTransaction: env,
+	})
+	if err != nil {
+		return "", gatewayError("submit", err)
+	}
+	return chdr.TxId, nil
+}
+
+// CommitStatus blocks until the transaction is committed by the gateway peer, and returns the validation code
+// and the block it was committed in.
+func (g *Gateway) CommitStatus(ctx context.Context, signer fabrictx.Signer, channel, txID string) (peer.TxValidationCode, uint64, error) {
+	id, err := signer.Serialize()
+	if err != nil {
+		return 0, 0, err
+	}
+	req, err := proto.Marshal(&gateway.CommitStatusRequest{
+		TransactionId: txID,
+		ChannelId:     channel,
+		Identity:      id,
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("marshal CommitStatusRequest: %w", err)
+	}
+	sig, err := signer.Sign(req)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	res, err := g.client.CommitStatus(ctx, &gateway.SignedCommitStatusRequest{Request: req, Signature: sig})
+	if err != nil {
+		return 0, 0, gatewayError("commit status", err)
+	}
+	return res.Result, res.BlockNumber, nil
+}
+
+// SubmitAndWait submits a transaction and waits until it is committed.
+func (g *Gateway) SubmitAndWait(ctx context.Context, signer fabrictx.Signer, env *common.Envelope) (string, peer.TxValidationCode, error) {
+	txID, err := g.Submit(env)
+	if err != nil {
+		return "", 0, err
+	}
+	chdr, err := fabrictx.ChannelHeader(env)
+	if err != nil {
+		return txID, 0, err
+	}
+	code, _, err := g.CommitStatus(ctx, signer, chdr.ChannelId, txID)
+	return txID, code, err
+}
+
+// ChaincodeEventsHandler processes the chaincode events of a single block.
+// Returning an error will stop the subscription.
+type ChaincodeEventsHandler func(blockNum uint64, events []*peer.ChaincodeEvent) error
+
+// ChaincodeEvents streams the events emitted by valid transactions of a chaincode, starting at the given block
+// number. If startBlock is 0, only events of blocks that are committed after subscribing are delivered.
+func (g *Gateway) ChaincodeEvents(ctx context.Context, signer fabrictx.Signer, channel, chaincode string, startBlock uint64, handle ChaincodeEventsHandler) error {
+	id, err := signer.Serialize()
+	if err != nil {
+		return err
+	}
+	start := &orderer.SeekPosition{Type: &orderer.SeekPosition_NextCommit{NextCommit: &orderer.SeekNextCommit{}}}
+	if startBlock != 0 {
+		start = &orderer.SeekPosition{Type: &orderer.SeekPosition_Specified{Specified: &orderer.SeekSpecified{Number: startBlock}}}
+	}
+	req, err := proto.Marshal(&gateway.ChaincodeEventsRequest{
+		ChannelId:     channel,
+		ChaincodeId:   chaincode,
+		Identity:      id,
+		StartPosition: start,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal ChaincodeEventsRequest: %w", err)
+	}
+	sig, err := signer.Sign(req)
+	if err != nil {
+		return err
+	}
+
+	stream, err := g.client.ChaincodeEvents(ctx, &gateway.SignedChaincodeEventsRequest{Request: req, Signature: sig})
+	if err != nil {
+		return gatewayError("chaincode events", err)
+	}
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) || status.Code(err) == codes.Canceled {
+				return nil
+			}
+			return gatewayError("recv chaincode events", err)
+		}
+		if err := handle(res.BlockNumber, res.Events); err != nil {
+			return fmt.Errorf("handler: %w", err)
+		}
+	}
+}
+
+func (g *Gateway) Close() error {
+	g.cancel()
+	return g.conn.Close()
+}
+
+// gatewayError adds the error details of the individual peers and orderers to the error message.
+func gatewayError(op string, err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	var details []string
+	for _, d := range st.Details() {
+		if detail, ok := d.(*gateway.ErrorDetail); ok {
+			details = append(details, fmt.Sprintf("%s (%s): %s", detail.Address, detail.MspId, detail.Message))
+		}
+	}
+	if len(details) == 0 {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return fmt.Errorf("%s: %w [%s]", op, err, strings.Join(details, "; "))
+}
@@ -0,0 +1,256 @@
+package comm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/arner/hacky-fabric/cryptogen"
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeGateway answers like the gateway of a peer, or fails every call with err.
+type fakeGateway struct {
+	gateway.UnimplementedGatewayServer
+	err       error
+	prepared  *common.Envelope // the transaction that Endorse returns
+	committed map[string]uint64
+	events    []*gateway.ChaincodeEventsResponse
+}
+
+func (f *fakeGateway) Evaluate(_ context.Context, req *gateway.EvaluateRequest) (*gateway.EvaluateResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &gateway.EvaluateResponse{Result: &peer.Response{Status: 200, Payload: []byte(req.TransactionId)}}, nil
+}
+
+func (f *fakeGateway) Endorse(context.Context, *gateway.EndorseRequest) (*gateway.EndorseResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &gateway.EndorseResponse{PreparedTransaction: f.prepared}, nil
+}
+
+func (f *fakeGateway) Submit(_ context.Context, req *gateway.SubmitRequest) (*gateway.SubmitResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if len(req.PreparedTransaction.GetSignature()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "transaction not signed")
+	}
+	return &gateway.SubmitResponse{}, nil
+}
+
+func (f *fakeGateway) CommitStatus(_ context.Context, signed *gateway.SignedCommitStatusRequest) (*gateway.CommitStatusResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	req := &gateway.CommitStatusRequest{}
+	if err := proto.Unmarshal(signed.Request, req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	num, ok := f.committed[req.TransactionId]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "transaction %s not found", req.TransactionId)
+	}
+	return &gateway.CommitStatusResponse{Result: peer.TxValidationCode_VALID, BlockNumber: num}, nil
+}
+
+func (f *fakeGateway) ChaincodeEvents(_ *gateway.SignedChaincodeEventsRequest, stream grpc.ServerStreamingServer[gateway.ChaincodeEventsResponse]) error {
+	for _, res := range f.events {
+		if err := stream.Send(res); err != nil {
+			return err
+		}
+	}
+	return f.err
+}
+
+func newTestGateway(t *testing.T, srv gateway.GatewayServer) *Gateway {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	gateway.RegisterGatewayServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &Gateway{conn: conn, client: gateway.NewGatewayClient(conn)}
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+	t.Cleanup(func() { g.Close() })
+	return g
+}
+
+func TestGateway(t *testing.T) {
+	org1, err := cryptogen.NewOrg(cryptogen.OrgSpec{Domain: "org1.example.com", MSPID: "Org1MSP", Peers: 1, Users: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := org1.Users[0].Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	endorser, err := org1.Peers[0].Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prop, err := fabrictx.NewProposal(user, "mychannel", "basic", [][]byte{[]byte("ReadAsset"), []byte("asset1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chdr, err := fabrictx.ProposalHeader(prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rws := &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "asset1", Value: []byte("value")}}}
+	prepared, txID, err := fabrictx.NewEndorserTransaction("mychannel", "basic", user, []fabrictx.Signer{endorser}, rws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prepared.Signature = nil
+
+	srv := &fakeGateway{
+		prepared:  prepared,
+		committed: map[string]uint64{txID: 3},
+		events: []*gateway.ChaincodeEventsResponse{
+			{BlockNumber: 2, Events: []*peer.ChaincodeEvent{{ChaincodeId: "basic", TxId: txID, EventName: "created"}}},
+			{BlockNumber: 3, Events: []*peer.ChaincodeEvent{{ChaincodeId: "basic", EventName: "updated"}}},
+		},
+	}
+	g := newTestGateway(t, srv)
+	ctx := context.Background()
+
+	res, err := g.Evaluate(prop, "Org1MSP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != 200 || string(res.Payload) != chdr.TxId {
+		t.Errorf("unexpected response %v", res)
+	}
+
+	env, err := g.Endorse(user, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(env.Signature) == 0 {
+		t.Error("expected the prepared transaction to be signed by the submitter")
+	}
+	submitted, code, err := g.SubmitAndWait(ctx, user, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if submitted != txID || code != peer.TxValidationCode_VALID {
+		t.Errorf("unexpected status %s of transaction %s", code, submitted)
+	}
+	if _, num, err := g.CommitStatus(ctx, user, "mychannel", txID); err != nil || num != 3 {
+		t.Errorf("expected block 3, got %d (%v)", num, err)
+	}
+	if _, _, err := g.CommitStatus(ctx, user, "mychannel", "unknown"); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+
+	var blocks []uint64
+	err = g.ChaincodeEvents(ctx, user, "mychannel", "basic", 2, func(num uint64, events []*peer.ChaincodeEvent) error {
+		blocks = append(blocks, num)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0] != 2 || blocks[1] != 3 {
+		t.Errorf("unexpected events of blocks %v", blocks)
+	}
+	errStop := errors.New("stop")
+	err = g.ChaincodeEvents(ctx, user, "mychannel", "basic", 2, func(uint64, []*peer.ChaincodeEvent) error {
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Errorf("expected the error of the handler, got %v", err)
+	}
+
+	g = newTestGateway(t, &fakeGateway{})
+	if _, err := g.Endorse(user, prop); err == nil {
+		t.Error("expected an error for a response without prepared transaction")
+	}
+}
+
+func TestGatewayErrors(t *testing.T) {
+	org1, err := cryptogen.NewOrg(cryptogen.OrgSpec{Domain: "org1.example.com", MSPID: "Org1MSP", Peers: 1, Users: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := org1.Users[0].Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	endorser, err := org1.Peers[0].Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prop, err := fabrictx.NewProposal(user, "mychannel", "basic", [][]byte{[]byte("ReadAsset"), []byte("asset1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, _, err := fabrictx.NewEndorserTransaction("mychannel", "basic", user, []fabrictx.Signer{endorser}, &kvrwset.KVRWSet{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := status.New(codes.Aborted, "failed to endorse transaction").WithDetails(
+		&gateway.ErrorDetail{Address: "peer0.org1.example.com:7051", MspId: "Org1MSP", Message: "chaincode response 500, asset1 does not exist"},
+		&gateway.ErrorDetail{Address: "peer0.org2.example.com:9051", MspId: "Org2MSP", Message: "timeout"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := newTestGateway(t, &fakeGateway{err: st.Err()})
+	ctx := context.Background()
+
+	for op, call := range map[string]func() error{
+		"evaluate": func() error { _, err := g.Evaluate(prop); return err },
+		"endorse":  func() error { _, err := g.Endorse(user, prop); return err },
+		"submit":   func() error { _, err := g.Submit(env); return err },
+		"commit status": func() error {
+			_, _, err := g.CommitStatus(ctx, user, "mychannel", "tx")
+			return err
+		},
+		"recv chaincode events": func() error {
+			return g.ChaincodeEvents(ctx, user, "mychannel", "basic", 0, func(uint64, []*peer.ChaincodeEvent) error { return nil })
+		},
+	} {
+		err := call()
+		if status.Code(err) != codes.Aborted {
+			t.Errorf("%s: expected the status of the gateway, got %v", op, err)
+			continue
+		}
+		expected := op + ": rpc error: code = Aborted desc = failed to endorse transaction [" +
+			"peer0.org1.example.com:7051 (Org1MSP): chaincode response 500, asset1 does not exist; " +
+			"peer0.org2.example.com:9051 (Org2MSP): timeout]"
+		if err.Error() != expected {
+			t.Errorf("%s: unexpected error %q", op, err)
+		}
+	}
+
+	// errors without details are only wrapped
+	g = newTestGateway(t, &fakeGateway{err: status.Error(codes.Unavailable, "no peers available")})
+	if _, err := g.Submit(env); err == nil || !strings.HasPrefix(err.Error(), "submit: rpc error: code = Unavailable") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
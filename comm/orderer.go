@@ -2,15 +2,16 @@ package comm
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"fmt"
-	"net"
+
+	"github.com/arner/hacky-fabric/fabrictx"
 
 	"github.com/hyperledger/fabric-protos-go-apiv2/common"
 	"github.com/hyperledger/fabric-protos-go-apiv2/orderer"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type Orderer struct {
@@ -22,23 +23,9 @@ type Orderer struct {
 }
 
 func NewOrderer(addr string, tlsPem []byte) (*Orderer, error) {
-	roots := x509.NewCertPool()
-	if ok := roots.AppendCertsFromPEM(tlsPem); !ok {
-		return nil, fmt.Errorf("failed to append orderer TLS cert")
-	}
-
-	host, _, err := net.SplitHostPort(addr)
+	conn, err := newTLSConn("orderer", addr, tlsPem)
 	if err != nil {
-		return nil, fmt.Errorf("orderer address [%s] must contain port: %w", addr, err)
-	}
-	creds := credentials.NewTLS(&tls.Config{
-		RootCAs:    roots,
-		ServerName: host,
-	})
-
-	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
-	if err != nil {
-		return nil, fmt.Errorf("dial orderer: %w", err)
+		return nil, err
 	}
 	o := &Orderer{
 		conn:   conn,
@@ -68,6 +55,53 @@ func (o Orderer) Broadcast(env *common.Envelope) error {
 	return nil
 }
 
+// SubscribeBlocks connects to the orderer Deliver service and streams blocks from the given starting block number,
+// invoking the provided handler for each block. The blocks are ordered but not validated: the TRANSACTIONS_FILTER
+// in the metadata is not set and there is no private data. They are wrapped in the same type as the blocks that
+// are delivered by a peer, so that handlers can be used for both.
+func (o *Orderer) SubscribeBlocks(channel string, startBlock uint64, signer fabrictx.Signer, handle BlockHandler) error {
+	deliver, err := o.client.Deliver(o.ctx)
+	if err != nil {
+		return fmt.Errorf("open Deliver: %w", err)
+	}
+	defer deliver.CloseSend()
+
+	env, err := fabrictx.NewDeliverSeekInfo(signer, channel, startBlock)
+	if err != nil {
+		return fmt.Errorf("build seek envelope: %w", err)
+	}
+	if err := deliver.Send(env); err != nil {
+		return fmt.Errorf("send seek envelope: %w", err)
+	}
+
+	for {
+		msg, err := deliver.Recv()
+		if err != nil {
+			st, ok := status.FromError(err)
+			if ok && st.Code() == codes.Canceled {
+				// Orderer connection is closing from our side.
+				return nil
+			}
+			return fmt.Errorf("recv deliver: %w", err)
+		}
+
+		switch t := msg.Type.(type) {
+		case *orderer.DeliverResponse_Block:
+			block := &peer.DeliverResponse_BlockAndPrivateData{
+				BlockAndPrivateData: &peer.BlockAndPrivateData{Block: t.Block},
+			}
+			if err := handle(block); err != nil {
+				return fmt.Errorf("handler: %w", err)
+			}
+		case *orderer.DeliverResponse_Status:
+			if t.Status != common.Status_SUCCESS {
+				return fmt.Errorf("deliver stream ended: %s", t.Status)
+			}
+			return nil
+		}
+	}
+}
+
 func (o *Orderer) Close() error {
 	if err := o.stream.CloseSend(); err != nil {
 		o.cancel()
@@ -2,10 +2,7 @@ package comm
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"fmt"
-	"net"
 
 	"github.com/arner/hacky-fabric/fabrictx"
 
@@ -13,7 +10,6 @@ import (
 	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
 )
 
@@ -30,23 +26,9 @@ type StorageProvider interface {
 }
 
 func NewPeer(addr string, tlsPem []byte) (*Peer, error) {
-	roots := x509.NewCertPool()
-	if ok := roots.AppendCertsFromPEM(tlsPem); !ok {
-		return nil, fmt.Errorf("failed to append peer TLS cert")
-	}
-
-	host, _, err := net.SplitHostPort(addr)
-	if err != nil {
-		return nil, fmt.Errorf("peer address [%s] must contain port: %w", addr, err)
-	}
-	creds := credentials.NewTLS(&tls.Config{
-		RootCAs:    roots,
-		ServerName: host,
-	})
-
-	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+	conn, err := newTLSConn("peer", addr, tlsPem)
 	if err != nil {
-		return nil, fmt.Errorf("dial peer: %w", err)
+		return nil, err
 	}
 
 	p := &Peer{
@@ -78,9 +60,18 @@ type BlockHandler func(block *peer.DeliverResponse_BlockAndPrivateData) error
 // SubscribeBlocks connects to the peer DeliverWithPrivateData service and streams blocks
 // from the given starting block number, invoking the provided handler for each block.
 func (p *Peer) SubscribeBlocks(channel string, startBlock uint64, signer fabrictx.Signer, handle BlockHandler) error {
+	return p.SubscribeBlocksContext(context.Background(), channel, startBlock, signer, handle)
+}
+
+// SubscribeBlocksContext is SubscribeBlocks, but also stops without an error when ctx is done.
+func (p *Peer) SubscribeBlocksContext(ctx context.Context, channel string, startBlock uint64, signer fabrictx.Signer, handle BlockHandler) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(p.ctx, cancel)()
+
 	deliverClient := peer.NewDeliverClient(p.conn)
 
-	deliver, err := deliverClient.DeliverWithPrivateData(p.ctx)
+	deliver, err := deliverClient.DeliverWithPrivateData(ctx)
 	if err != nil {
 		return fmt.Errorf("open DeliverWithPrivateData: %w", err)
 	}
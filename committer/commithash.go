@@ -0,0 +1,187 @@
+package committer
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/arner/hacky-fabric/storage"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// A Fabric peer chains the update batches that it applies to its state database in the COMMIT_HASH of the block
+// metadata: the SHA-256 of the TRANSACTIONS_FILTER, the public and hashed private writes of the block and the
+// commit hash of the previous block. The chain starts at block 1. Recomputing it from the writes that the
+// committer stores proves that it applied exactly what the peer applied. Key metadata (key-level endorsement
+// policies) is not stored by the committer, so blocks that update it don't match.
+
+// WithCommitHashVerification recomputes the commit hash of every block from its writes and compares it with the
+// COMMIT_HASH that the peer set. A mismatch is handled like a block that can't be processed completely, according
+// to the error policy: Halt stops at the block, SkipAndRecord commits it and records the mismatch as a dead letter.
+// Every block is verified against the commit hash of the peer for the previous block, so a mismatch doesn't
+// carry over to the next blocks. Blocks without a commit hash, or after a block without one, are not verified.
+func WithCommitHashVerification() Option {
+	return func(c *Committer) {
+		c.commitHashes = true
+	}
+}
+
+// checkCommitHash returns the COMMIT_HASH that the peer set on the block, and a dead letter if commit hashes are
+// verified and it doesn't match the writes. prev is the COMMIT_HASH of the previous block.
+func (c *Committer) checkCommitHash(b *parsedBlock, prev []byte, writes []storage.WriteRecord) ([]byte, *storage.DeadLetter) {
+	block := deliveredBlock(b.block)
+	peerHash, err := commitHashMetadata(block)
+	switch {
+	case !c.commitHashes:
+		return peerHash, nil
+	case err != nil:
+		return nil, &storage.DeadLetter{BlockNum: b.num, TxNum: -1, Reason: err.Error()}
+	case peerHash == nil || (prev == nil && b.num > 1):
+		return peerHash, nil
+	}
+
+	var filter []byte
+	if metadata := block.GetMetadata().GetMetadata(); len(metadata) > int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		filter = metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
+	}
+	hash, err := computeCommitHash(prev, filter, writes)
+	if err != nil {
+		return peerHash, &storage.DeadLetter{BlockNum: b.num, TxNum: -1, Reason: err.Error()}
+	}
+	if !bytes.Equal(hash, peerHash) {
+		return peerHash, &storage.DeadLetter{BlockNum: b.num, TxNum: -1, Reason: fmt.Sprintf("commit hash %x doesn't match the COMMIT_HASH %x of the peer", hash, peerHash)}
+	}
+	return peerHash, nil
+}
+
+// commitHash returns the COMMIT_HASH of a committed block, from memory if that is the last block that was committed.
+func (c *Committer) commitHash(num uint64) ([]byte, error) {
+	if c.lastCommitHash.hash != nil && c.lastCommitHash.num == num {
+		return c.lastCommitHash.hash, nil
+	}
+	return c.db.GetCommitHash(num)
+}
+
+// commitHashMetadata returns the COMMIT_HASH in the metadata of a block, or nil if the peer didn't set it.
+func commitHashMetadata(block *common.Block) ([]byte, error) {
+	metadata := block.GetMetadata().GetMetadata()
+	if len(metadata) <= int(common.BlockMetadataIndex_COMMIT_HASH) || len(metadata[common.BlockMetadataIndex_COMMIT_HASH]) == 0 {
+		return nil, nil
+	}
+	m := &common.Metadata{}
+	if err := proto.Unmarshal(metadata[common.BlockMetadataIndex_COMMIT_HASH], m); err != nil {
+		return nil, fmt.Errorf("commit hash metadata: %w", err)
+	}
+	return m.Value, nil
+}
+
+// computeCommitHash returns the commit hash of a block like the peer computes it, from the commit hash of the
+// previous block (nil for block 1), the TRANSACTIONS_FILTER and the writes of the valid transactions.
+func computeCommitHash(prev, filter []byte, writes []storage.WriteRecord) ([]byte, error) {
+	batch, err := updateBatchBytes(writes)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write(protowire.AppendVarint(nil, uint64(len(filter))))
+	h.Write(filter)
+	h.Write(batch)
+	h.Write(prev)
+	return h.Sum(nil), nil
+}
+
+// batchWrite is the last write of a key in a block, with the version of the transaction that wrote it.
+type batchWrite struct {
+	namespace  string
+	collection string // empty for public writes
+	key        string // the key hash for private data
+	value      []byte // nil for deletes
+	block, tx  uint64
+}
+
+// updateBatchBytes returns the encoding of the writes of a block that the peer hashes: a KVWritesBatchProto
+// (core/ledger/kvledger/txmgmt/txmgr/update_batch_bytes.proto in Fabric) with a KVWriteProto for the last write
+// of every key, sorted by namespace, collection (public writes first) and key. The namespace is only set on the
+// first write of each namespace, and the collection on the first write of each collection in a namespace.
+func updateBatchBytes(writes []storage.WriteRecord) ([]byte, error) {
+	latest := map[[3]string]batchWrite{}
+	for _, w := range writes {
+		ns, coll, hashed := strings.Cut(w.Namespace, "$$h")
+		if strings.Contains(w.Namespace, "$$p") || ns == "" {
+			// the private data itself is not hashed, and the empty namespace is the channel config of Fabric 1.x
+			continue
+		}
+		key := w.Key
+		if hashed {
+			k, err := hex.DecodeString(w.Key)
+			if err != nil {
+				return nil, fmt.Errorf("key hash %s: %w", w.Key, err)
+			}
+			key = string(k)
+		}
+		bw := batchWrite{namespace: ns, collection: coll, key: key, block: w.BlockNum, tx: w.TxNum}
+		if !w.IsDelete && len(w.Value) > 0 {
+			bw.value = w.Value
+		}
+		id := [3]string{ns, coll, key}
+		if prev, ok := latest[id]; !ok || prev.tx <= bw.tx {
+			latest[id] = bw
+		}
+	}
+	sorted := make([]batchWrite, 0, len(latest))
+	for _, w := range latest {
+		sorted = append(sorted, w)
+	}
+	slices.SortFunc(sorted, func(a, b batchWrite) int {
+		return cmp.Or(
+			strings.Compare(a.namespace, b.namespace),
+			strings.Compare(a.collection, b.collection),
+			strings.Compare(a.key, b.key),
+		)
+	})
+
+	var out, kv []byte
+	for i, w := range sorted {
+		kv = kv[:0]
+		if i == 0 || w.namespace != sorted[i-1].namespace {
+			kv = appendField(kv, 1, []byte(w.namespace))
+		}
+		if i == 0 || w.namespace != sorted[i-1].namespace || w.collection != sorted[i-1].collection {
+			kv = appendField(kv, 2, []byte(w.collection))
+		}
+		kv = appendField(kv, 3, []byte(w.key))
+		if w.value == nil {
+			kv = protowire.AppendTag(kv, 4, protowire.VarintType)
+			kv = protowire.AppendVarint(kv, 1)
+		}
+		kv = appendField(kv, 5, w.value)
+		kv = appendField(kv, 6, append(versionBytes(w.block), versionBytes(w.tx)...))
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, kv)
+	}
+	return out, nil
+}
+
+// appendField appends a string or bytes field, which proto3 leaves out if it is empty.
+func appendField(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+// versionBytes is the order preserving encoding of a block or transaction number in a Fabric version: the
+// number of significant bytes, followed by those bytes.
+func versionBytes(n uint64) []byte {
+	b := bytes.TrimLeft(binary.BigEndian.AppendUint64(nil, n), "\x00")
+	return append([]byte{byte(len(b))}, b...)
+}
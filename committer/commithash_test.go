@@ -0,0 +1,169 @@
+package committer
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/arner/hacky-fabric/storage"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCommitHash(t *testing.T) {
+	writes := []storage.WriteRecord{
+		{Namespace: "basic", Key: "b", BlockNum: 3, TxNum: 2, IsDelete: true},
+		{Namespace: "basic", Key: "a", BlockNum: 3, TxNum: 0, Value: []byte("A0")},
+		{Namespace: "basic", Key: "a", BlockNum: 3, TxNum: 1, Value: []byte("A1")},
+		{Namespace: storage.HashedNamespace("basic", "coll"), Key: storage.HashedKey([]byte{1, 2}), BlockNum: 3, TxNum: 1, Value: []byte{0xff}},
+		{Namespace: storage.PrivateNamespace("basic", "coll"), Key: "secret", BlockNum: 3, TxNum: 1, Value: []byte("s")},
+		{Namespace: "asset", Key: "x", BlockNum: 3, TxNum: 0, Value: []byte("X")},
+	}
+	// asset/x, basic/a (the last write), basic/b (deleted) and the hash in basic/coll, as encoded by
+	// deterministicBytesForPubAndHashUpdates in Fabric
+	expected := "0a120a0561737365741a01782a015832030103000a140a0562617369631a01612a0241313204010301010a0b1a016220013204010301020a131204636f6c6c1a0201022a01ff320401030101"
+	batch, err := updateBatchBytes(writes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(batch) != expected {
+		t.Errorf("unexpected update batch %x", batch)
+	}
+	hash, err := computeCommitHash(nil, []byte{0, 11, 0}, writes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(hash) != "5bd382a362b93186faaa5d7700fd88081bb9c10a2423c80e4b0ee158338e2e9d" {
+		t.Errorf("unexpected commit hash %x", hash)
+	}
+}
+
+// setCommitHashes sets the COMMIT_HASH of consecutive blocks like a peer, starting from the commit hash of the
+// block before the first. It returns the commit hash of the last block.
+func setCommitHashes(t *testing.T, prev []byte, blocks ...*peer.DeliverResponse_BlockAndPrivateData) []byte {
+	for _, b := range blocks {
+		txs, num, err := parseBlock(b, true, nopLogger{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		metadata := b.BlockAndPrivateData.Block.Metadata.Metadata
+		if prev, err = computeCommitHash(prev, metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER], validWrites(num, txs)); err != nil {
+			t.Fatal(err)
+		}
+		metadata[common.BlockMetadataIndex_COMMIT_HASH] = mustMarshal(t, &common.Metadata{Value: prev})
+	}
+	return prev
+}
+
+func TestVerifyCommitHash(t *testing.T) {
+	blocks := fixtureChain(t, 4)
+	setCommitHashes(t, nil, blocks...)
+
+	// a peer whose commit hash differs from block 3 on
+	forked := make([]*peer.DeliverResponse_BlockAndPrivateData, len(blocks))
+	for i, b := range blocks {
+		forked[i] = &peer.DeliverResponse_BlockAndPrivateData{BlockAndPrivateData: proto.Clone(b.BlockAndPrivateData).(*peer.BlockAndPrivateData)}
+	}
+	bogus := bytes.Repeat([]byte{1}, 32)
+	forked[2].BlockAndPrivateData.Block.Metadata.Metadata[common.BlockMetadataIndex_COMMIT_HASH] = mustMarshal(t, &common.Metadata{Value: bogus})
+	setCommitHashes(t, bogus, forked[3])
+
+	t.Run("matching", func(t *testing.T) {
+		c := newTestCommitter(t, WithCommitHashVerification(), WithErrorPolicy(Halt))
+		for _, b := range blocks[:2] {
+			if err := c.ProcessBlock(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// after a restart, the commit hash of the previous block comes from the database
+		restarted, err := NewCommitter(context.Background(), c.db, "mychannel", nil, nil, nopLogger{}, WithCommitHashVerification(), WithErrorPolicy(Halt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer restarted.Stop()
+		for _, b := range blocks[2:] {
+			if err := restarted.ProcessBlock(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected, _ := commitHashMetadata(blocks[3].BlockAndPrivateData.Block)
+		if h, err := c.db.GetCommitHash(4); err != nil || !bytes.Equal(h, expected) {
+			t.Errorf("expected the commit hash of the peer, got %x (%v)", h, err)
+		}
+	})
+
+	t.Run("halt", func(t *testing.T) {
+		c := newTestCommitter(t, WithCommitHashVerification(), WithErrorPolicy(Halt))
+		var err error
+		for _, b := range forked {
+			if err = c.ProcessBlock(b); err != nil {
+				break
+			}
+		}
+		var perr *ProcessError
+		if !errors.As(err, &perr) || perr.BlockNum != 3 || !perr.Halt {
+			t.Fatalf("expected a halt at block 3, got %v", err)
+		}
+		if last, err := c.db.LastProcessedBlock(); err != nil || last != 2 {
+			t.Errorf("expected last block 2, got %d (%v)", last, err)
+		}
+	})
+
+	t.Run("skip and record", func(t *testing.T) {
+		c := newTestCommitter(t, WithCommitHashVerification(), WithErrorPolicy(SkipAndRecord))
+		for _, b := range forked {
+			if err := c.ProcessBlock(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// block 4 is verified against the commit hash of the peer for block 3
+		dead, err := c.DeadLetters(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dead) != 1 || dead[0].BlockNum != 3 || dead[0].TxNum != -1 {
+			t.Errorf("expected a dead letter for block 3, got %+v", dead)
+		}
+	})
+}
+
+// TestPeerCommitHash verifies the COMMIT_HASH that a Fabric peer set on blocks 1 and 2 of its channel.
+func TestPeerCommitHash(t *testing.T) {
+	var blocks []*peer.DeliverResponse_BlockAndPrivateData
+	for _, name := range []string{"genesis", "channel"} {
+		raw, err := os.ReadFile("../fabrictx/fixtures/" + name + ".block")
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := &common.Block{}
+		if err := proto.Unmarshal(raw, block); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, &peer.DeliverResponse_BlockAndPrivateData{BlockAndPrivateData: &peer.BlockAndPrivateData{Block: block}})
+	}
+
+	c := newTestCommitter(t, WithCommitHashVerification(), WithErrorPolicy(Halt))
+	for i, b := range blocks {
+		if err := c.ProcessBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		expected, _ := commitHashMetadata(b.BlockAndPrivateData.Block)
+		if h, err := c.db.GetCommitHash(uint64(i + 1)); err != nil || len(h) == 0 || !bytes.Equal(h, expected) {
+			t.Errorf("expected the commit hash %x of the peer for block %d, got %x (%v)", expected, i+1, h, err)
+		}
+	}
+
+	// the same block with another commit hash of the peer for block 1
+	c = newTestCommitter(t, WithCommitHashVerification(), WithErrorPolicy(Halt))
+	forked := &peer.DeliverResponse_BlockAndPrivateData{BlockAndPrivateData: proto.Clone(blocks[0].BlockAndPrivateData).(*peer.BlockAndPrivateData)}
+	forked.BlockAndPrivateData.Block.Metadata.Metadata[common.BlockMetadataIndex_COMMIT_HASH] = mustMarshal(t, &common.Metadata{Value: bytes.Repeat([]byte{1}, 32)})
+	var perr *ProcessError
+	if err := c.ProcessBlock(forked); !errors.As(err, &perr) || perr.BlockNum != 1 {
+		t.Errorf("expected a halt at block 1, got %v", err)
+	}
+}
@@ -2,16 +2,17 @@ package committer
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"os"
 	"time"
 
+	"github.com/arner/hacky-fabric/blockstore"
 	"github.com/arner/hacky-fabric/comm"
 	"github.com/arner/hacky-fabric/fabrictx"
 	"github.com/arner/hacky-fabric/storage"
 
 	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset"
 	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
 	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
 	"google.golang.org/protobuf/proto"
@@ -22,34 +23,64 @@ type Logger interface {
 }
 
 type Committer struct {
-	db      *storage.VersionedDB
-	peer    *comm.Peer
-	channel string
-	signer  fabrictx.Signer
-	ctx     context.Context
-	cancel  context.CancelFunc
-	log     Logger
+	db             *storage.VersionedDB
+	peer           *comm.Peer
+	channel        string
+	signer         fabrictx.Signer
+	ctx            context.Context
+	cancel         context.CancelFunc
+	log            Logger
+	validation     ValidationMode
+	onMismatch     func(Mismatch)
+	notifier       *notifier
+	blocks         *blockstore.Store
+	state          runState
+	workers        int
+	depth          int
+	errorPolicy    ErrorPolicy
+	commitHashes   bool      // verify the COMMIT_HASH of the peer
+	lastHash       blockHash // state hash of the last committed block
+	lastCommitHash blockHash // COMMIT_HASH of the last committed block
 }
 
-func NewCommitter(ctx context.Context, db *storage.VersionedDB, channel string, peer *comm.Peer, signer fabrictx.Signer, logger Logger) (*Committer, error) {
+// WithBlockStore archives the blocks from the peer in the store before they are committed, so that the
+// database can be rebuilt with Replay. An empty store starts at the next block that is committed; the blocks that
+// a store misses, because it is behind the database, are received from the peer again by Run.
+func WithBlockStore(store *blockstore.Store) Option {
+	return func(c *Committer) {
+		c.blocks = store
+	}
+}
+
+// NewCommitter returns a committer that follows the channel on the peer with Run. The peer and signer may be
+// nil if the blocks are passed to ProcessBlock instead.
+func NewCommitter(ctx context.Context, db *storage.VersionedDB, channel string, peer *comm.Peer, signer fabrictx.Signer, logger Logger, opts ...Option) (*Committer, error) {
 	cctx, cancel := context.WithCancel(ctx)
 
-	return &Committer{
-		db:      db,
-		peer:    peer,
-		signer:  signer,
-		channel: channel,
-		ctx:     cctx,
-		cancel:  cancel,
-		log:     logger,
-	}, nil
+	c := &Committer{
+		db:       db,
+		peer:     peer,
+		signer:   signer,
+		channel:  channel,
+		ctx:      cctx,
+		cancel:   cancel,
+		log:      logger,
+		notifier: newNotifier(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
 }
 
+// Run follows the channel on the peer from the block after the last processed block, and commits the blocks
+// until the committer is stopped. It reconnects with an exponential backoff when the stream fails; the
+// progress is reported by Status. It returns the *ProcessError of a block that can't be processed, unless the
+// error policy is SkipAndRecord.
 func (c *Committer) Run() error {
-	lastBlock, _ := c.db.LastProcessedBlock()
-	start := lastBlock + 1
-
 	backoff := time.Second
+	var failedBlock uint64
+	var blockRetries int
 	for {
 		select {
 		case <-c.ctx.Done():
@@ -57,13 +88,9 @@ func (c *Committer) Run() error {
 		default:
 		}
 
-		err := c.peer.SubscribeBlocks(c.channel, start, c.signer, func(block *peer.DeliverResponse_BlockAndPrivateData) error {
-			select {
-			case <-c.ctx.Done():
-				return fmt.Errorf("stopped")
-			default:
-			}
-			return c.processBlock(block)
+		err := c.follow(func() {
+			backoff = time.Second
+			c.state.received()
 		})
 		if err != nil {
 			select {
@@ -71,8 +98,28 @@ func (c *Committer) Run() error {
 				return nil
 			default:
 			}
+			var perr *ProcessError
+			if errors.As(err, &perr) {
+				if perr.BlockNum != failedBlock {
+					failedBlock, blockRetries = perr.BlockNum, 0
+				}
+				if blockRetries >= MaxBlockRetries {
+					perr.Halt = true
+				}
+				blockRetries++
+				if perr.Halt {
+					c.log.Printf("halted: %v", err)
+					c.state.halt(err)
+					return err
+				}
+			}
 			c.log.Printf("deliver error: %v — retrying in %s", err, backoff)
-			time.Sleep(backoff)
+			c.state.failed(err, backoff)
+			select {
+			case <-c.ctx.Done():
+				return nil
+			case <-time.After(backoff):
+			}
 			backoff *= 2
 			if backoff > 30*time.Second {
 				backoff = 30 * time.Second
@@ -83,65 +130,362 @@ func (c *Committer) Run() error {
 	}
 }
 
-// storeAsFile stores blocks as files
-func storeAsFile(block *peer.DeliverResponse_BlockAndPrivateData) error {
-	b := block.BlockAndPrivateData.Block
-	blockB, err := proto.Marshal(b)
+// follow subscribes to the blocks after the last processed block and commits them, directly or through the
+// pipeline, until the stream ends. onCommit is called after every commit.
+func (c *Committer) follow(onCommit func()) error {
+	lastBlock, err := c.db.LastProcessedBlock()
 	if err != nil {
 		return err
 	}
-	if err = os.WriteFile(fmt.Sprintf("./%d.block", b.Header.Number), blockB, 0644); err != nil {
-		return err
+	handle := func(block *peer.DeliverResponse_BlockAndPrivateData) error {
+		if err := c.archive(block); err != nil {
+			return err
+		}
+		if err := c.ProcessBlock(block); err != nil {
+			return err
+		}
+		onCommit()
+		return nil
+	}
+	var p *pipeline
+	if c.workers > 0 {
+		p = newPipeline(c, c.workers, c.depth, onCommit)
+		handle = p.submit
+	}
+
+	// a block store that is behind the database gets the blocks that it misses from the peer again. They are
+	// archived, but not committed again.
+	start := lastBlock + 1
+	if c.blocks != nil && c.blocks.Height() > 0 && c.blocks.Height() < start {
+		start = c.blocks.Height()
+	}
+	err = c.peer.SubscribeBlocksContext(c.ctx, c.channel, start, c.signer, func(block *peer.DeliverResponse_BlockAndPrivateData) error {
+		select {
+		case <-c.ctx.Done():
+			return fmt.Errorf("stopped")
+		default:
+		}
+		if b := deliveredBlock(block); start <= lastBlock && b.GetHeader() != nil && b.Header.Number <= lastBlock {
+			return c.archive(block)
+		}
+		return handle(block)
+	})
+	if p != nil {
+		// the blocks that were received are committed before reconnecting
+		if perr := p.close(); err == nil {
+			err = perr
+		}
 	}
+	return err
+}
 
+// archive appends a block from the peer to the block store, if there is one. Blocks that are already in the
+// store, because the committer stopped after archiving them, are skipped.
+func (c *Committer) archive(block *peer.DeliverResponse_BlockAndPrivateData) error {
+	if c.blocks == nil || block.BlockAndPrivateData.Block.Header.Number < c.blocks.Height() {
+		return nil
+	}
+	if err := c.blocks.Append(block.BlockAndPrivateData); err != nil {
+		return fmt.Errorf("archive block: %w", err)
+	}
 	return nil
 }
 
-func (c *Committer) processBlock(block *peer.DeliverResponse_BlockAndPrivateData) error {
-	w, num, err := parseBlock(block, c.log)
+// Replay commits the blocks in the store that come after the last processed block, without contacting a peer.
+// With an empty database, it rebuilds the world state from scratch.
+func (c *Committer) Replay(store *blockstore.Store) error {
+	lastBlock, err := c.db.LastProcessedBlock()
 	if err != nil {
-		c.log.Printf("error parsing block: %s", err.Error()) // TODO error handling
+		return err
+	}
+	return store.Replay(lastBlock+1, c.ProcessBlock)
+}
+
+// ProcessBlock validates (depending on the validation mode) and commits a block, and notifies the waiting
+// clients. Run calls it for every block from the peer, but it can also be used as a comm.BlockHandler for blocks
+// from another source, in order. Blocks that can't be processed completely are handled according to the error
+// policy.
+func (c *Committer) ProcessBlock(block *peer.DeliverResponse_BlockAndPrivateData) error {
+	return c.commit([]*parsedBlock{c.parse(block)})
+}
+
+// parsedBlock is a block with the transactions that parseBlock extracted.
+type parsedBlock struct {
+	block *peer.DeliverResponse_BlockAndPrivateData
+	num   uint64
+	txs   []*blockTx
+	err   error // if the block can't be processed at all
+}
+
+// parse extracts the transactions of a block. It doesn't depend on the state, so blocks can be parsed in parallel.
+func (c *Committer) parse(block *peer.DeliverResponse_BlockAndPrivateData) *parsedBlock {
+	txs, num, err := parseBlock(block, c.validation != ValidateLocally, c.log)
+	if err != nil {
+		c.log.Printf("error parsing block %d: %s", num, err)
+	}
+	return &parsedBlock{block: block, num: num, txs: txs, err: err}
+}
+
+// commit validates (depending on the validation mode) and commits consecutive blocks in one database transaction,
+// and notifies the waiting clients. Blocks are validated against the committed state, so only a single block can
+// be committed at a time when validating. If a block can't be processed, the blocks before it are committed.
+func (c *Committer) commit(blocks []*parsedBlock) error {
+	var writes []storage.BlockWrites
+	var failed error
+	var prevCommit []byte
+	if c.commitHashes && blocks[0].num > 1 {
+		var err error
+		if prevCommit, err = c.commitHash(blocks[0].num - 1); err != nil {
+			return err
+		}
 	}
-	// c.log.Printf("block %d - %d writes\n", num, len(w))
-	if len(w) == 0 {
-		if err := c.db.MarkProcessed(nil, num); err != nil {
-			log.Printf("error marking block as processed: %s (ignoring)", err.Error()) // this breaks waitUntilSynced
+	for _, b := range blocks {
+		w, err := c.prepare(b, prevCommit)
+		if err != nil {
+			failed = err
+			break
 		}
+		writes = append(writes, w)
+		prevCommit = w.CommitHash
+	}
+	if len(writes) == 0 {
+		return failed
+	}
+	var prev []byte
+	if writes[0].BlockNum > 0 {
+		var err error
+		if prev, err = c.stateHash(writes[0].BlockNum - 1); err != nil {
+			return err
+		}
+	}
+	for i := range writes {
+		writes[i].StateHash = storage.StateHash(prev, writes[i].Writes)
+		prev = writes[i].StateHash
+	}
+	if err := c.db.CommitBlocks(writes); err != nil {
+		return fmt.Errorf("commit blocks %d-%d: %w", writes[0].BlockNum, writes[len(writes)-1].BlockNum, err)
+	}
+	c.lastHash = blockHash{num: writes[len(writes)-1].BlockNum, hash: prev}
+	c.lastCommitHash = blockHash{num: writes[len(writes)-1].BlockNum, hash: prevCommit}
+	for _, b := range blocks[:len(writes)] {
+		c.notifier.publish(statuses(b.num, b.txs))
+	}
+	return failed
+}
+
+// blockHash is a hash of a block or of the state after it.
+type blockHash struct {
+	num  uint64
+	hash []byte
+}
+
+// stateHash returns the state hash after a block, from memory if that is the last block that was committed.
+// It is nil for the genesis block, and for blocks that were committed without a state hash.
+func (c *Committer) stateHash(num uint64) ([]byte, error) {
+	if c.lastHash.hash != nil && c.lastHash.num == num {
+		return c.lastHash.hash, nil
+	}
+	return c.db.GetStateHash(num)
+}
+
+// prepare validates a block and returns what to commit. It returns a *ProcessError if the block can't be
+// processed completely or doesn't match its commit hash, unless the error policy is SkipAndRecord. A block without
+// header can't be skipped, because it is unknown which block it is. prevCommit is the COMMIT_HASH of the previous
+// block.
+func (c *Committer) prepare(b *parsedBlock, prevCommit []byte) (storage.BlockWrites, error) {
+	if deliveredBlock(b.block).GetHeader() == nil {
+		return storage.BlockWrites{}, &ProcessError{Failures: deadLetters(b), Halt: c.errorPolicy == Halt}
+	}
+	if b.err == nil && c.validation != TrustPeer {
+		if err := c.validate(b.num, b.txs); err != nil {
+			return storage.BlockWrites{}, err
+		}
+	}
+	dead := deadLetters(b)
+	writes := validWrites(b.num, b.txs)
+	commitHash, mismatch := c.checkCommitHash(b, prevCommit, writes)
+	if mismatch != nil && len(dead) == 0 {
+		// an incomplete block doesn't match anyway
+		dead = append(dead, *mismatch)
+	}
+	if len(dead) > 0 {
+		if c.errorPolicy != SkipAndRecord {
+			return storage.BlockWrites{}, &ProcessError{BlockNum: b.num, Failures: dead, Halt: c.errorPolicy == Halt}
+		}
+		c.log.Printf("recording %d dead letters for block %d", len(dead), b.num)
+	}
+	return storage.BlockWrites{
+		BlockNum:    b.num,
+		Writes:      writes,
+		Txs:         txRecords(b.num, b.txs),
+		DeadLetters: dead,
+		CommitHash:  commitHash,
+	}, nil
+}
+
+// blockTx is a transaction in a block with the validation code that decides whether its writes are stored.
+type blockTx struct {
+	num       uint64
+	id        string
+	code      peer.TxValidationCode
+	rwsets    []fabrictx.NsRwset
+	private   []privateRwset
+	event     *peer.ChaincodeEvent
+	malformed bool  // the read/write set can't be parsed
+	err       error // why the transaction can't be processed completely
+}
+
+// validEvent returns the chaincode event of the transaction if it is valid. Events of invalid transactions
+// are not delivered, like in Fabric.
+func (tx *blockTx) validEvent() *storage.Event {
+	if tx.code != peer.TxValidationCode_VALID || tx.event == nil {
 		return nil
 	}
-	return c.db.BatchInsert(w)
+	return &storage.Event{Namespace: tx.event.ChaincodeId, Name: tx.event.EventName, Payload: tx.event.Payload}
 }
 
-func parseBlock(block *peer.DeliverResponse_BlockAndPrivateData, log Logger) ([]storage.WriteRecord, uint64, error) {
-	writes := []storage.WriteRecord{}
+// privateRwset is the private data of a collection that was delivered with the block.
+type privateRwset struct {
+	namespace  string
+	collection string
+	rwset      *kvrwset.KVRWSet
+}
+
+// deliveredBlock returns the block of a deliver response, or nil if there is none.
+func deliveredBlock(block *peer.DeliverResponse_BlockAndPrivateData) *common.Block {
+	if block == nil {
+		return nil
+	}
+	return block.BlockAndPrivateData.GetBlock()
+}
 
-	b := block.BlockAndPrivateData.Block
-	if len(b.Metadata.Metadata) <= int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
-		return writes, 0, fmt.Errorf("block metadata missing TRANSACTIONS_FILTER")
+// parseBlock extracts the transactions of a block. If useFilter is true, the validation codes are taken from
+// the TRANSACTIONS_FILTER in the block metadata, otherwise all transactions are considered valid until validated.
+// Read/write sets are only extracted from transactions whose validation code can still be VALID.
+//
+// It returns an error, with the block number if the block has a header, if the block can't be processed at all.
+// Transactions that can't be parsed are returned with their error.
+func parseBlock(block *peer.DeliverResponse_BlockAndPrivateData, useFilter bool, log Logger) ([]*blockTx, uint64, error) {
+	txs := []*blockTx{}
+
+	b := deliveredBlock(block)
+	if b.GetHeader() == nil {
+		return txs, 0, errors.New("block without header")
+	}
+	if b.Data == nil {
+		return txs, b.Header.Number, errors.New("block without data")
+	}
+	var txFilter []byte
+	if useFilter {
+		metadata := b.GetMetadata().GetMetadata()
+		if len(metadata) <= int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+			return txs, b.Header.Number, errors.New("block metadata missing TRANSACTIONS_FILTER")
+		}
+		txFilter = metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
+		if len(txFilter) < len(b.Data.Data) {
+			return txs, b.Header.Number, fmt.Errorf("TRANSACTIONS_FILTER has %d codes for %d transactions", len(txFilter), len(b.Data.Data))
+		}
 	}
-	txFilter := b.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
 
 	for txNum, envBytes := range b.Data.Data {
-		if txNum >= len(txFilter) || peer.TxValidationCode(txFilter[txNum]) != peer.TxValidationCode_VALID {
-			log.Printf("%d:%d %s", b.Header.Number, txNum, peer.TxValidationCode_name[int32(peer.TxValidationCode(txFilter[txNum]))])
-			continue
+		tx := &blockTx{num: uint64(txNum), code: peer.TxValidationCode_VALID}
+		txs = append(txs, tx)
+		if useFilter {
+			tx.code = peer.TxValidationCode(txFilter[txNum])
+			log.Printf("%d:%d %s", b.Header.Number, txNum, tx.code)
 		}
-		log.Printf("%d:%d %s", b.Header.Number, txNum, peer.TxValidationCode_name[int32(peer.TxValidationCode(txFilter[txNum]))])
 		env := &common.Envelope{}
 		if err := proto.Unmarshal(envBytes, env); err != nil {
-			log.Printf("%d:%d invalid envelope: %s", b.Header.Number, txNum, err.Error())
+			tx.malformed, tx.err = true, fmt.Errorf("invalid envelope: %w", err)
+			log.Printf("%d:%d %s", b.Header.Number, txNum, tx.err)
 			continue
 		}
-		rwsets, err := fabrictx.RWSets(env)
+		chdr, err := fabrictx.ChannelHeader(env)
 		if err != nil {
-			log.Printf("%d:%d invalid rwset: %s", b.Header.Number, txNum, err.Error())
+			tx.malformed, tx.err = true, fmt.Errorf("invalid header: %w", err)
+			log.Printf("%d:%d %s", b.Header.Number, txNum, tx.err)
 			continue
 		}
-		for _, rw := range rwsets {
-			writes = append(writes, records(rw.Namespace, b.Header.Number, uint64(txNum), rw.TxID, rw.Rwset)...)
+		tx.id = chdr.TxId
+		if !revalidate(tx.code) || common.HeaderType(chdr.Type) != common.HeaderType_ENDORSER_TRANSACTION {
+			continue
 		}
+		tx.rwsets, err = fabrictx.RWSets(env)
+		if err != nil {
+			tx.malformed, tx.err = true, fmt.Errorf("invalid rwset: %w", err)
+			log.Printf("%d:%d %s", b.Header.Number, txNum, tx.err)
+			continue
+		}
+		if tx.event, err = fabrictx.ChaincodeEvent(env); err != nil {
+			tx.err = fmt.Errorf("invalid chaincode event: %w", err)
+			log.Printf("%d:%d %s", b.Header.Number, txNum, tx.err)
+		}
+		tx.private = privateData(tx.rwsets, block.BlockAndPrivateData.PrivateDataMap[uint64(txNum)], func(err error) {
+			log.Printf("%d:%d %s", b.Header.Number, txNum, err.Error())
+		})
 	}
-	return writes, b.Header.Number, nil
+	return txs, b.Header.Number, nil
+}
+
+// privateData returns the private read/write sets of the collections in the transaction for which private
+// data was delivered. Private data that doesn't match the hash in the transaction is left out, like the peer does.
+func privateData(rwsets []fabrictx.NsRwset, pvt *rwset.TxPvtReadWriteSet, onError func(error)) []privateRwset {
+	if pvt == nil {
+		return nil
+	}
+	var out []privateRwset
+	for _, ns := range rwsets {
+		for _, coll := range ns.Collections {
+			kvs, err := fabrictx.PrivateWrites(pvt, ns.Namespace, coll)
+			if err != nil {
+				onError(err)
+				continue
+			}
+			if kvs != nil {
+				out = append(out, privateRwset{namespace: ns.Namespace, collection: coll.Collection, rwset: kvs})
+			}
+		}
+	}
+	return out
+}
+
+// txRecords returns the status of all transactions in the block that have a transaction ID.
+func txRecords(blockNum uint64, txs []*blockTx) []storage.TxRecord {
+	records := make([]storage.TxRecord, 0, len(txs))
+	for _, tx := range txs {
+		if tx.id == "" {
+			continue
+		}
+		records = append(records, storage.TxRecord{
+			TxID:           tx.id,
+			BlockNum:       blockNum,
+			TxNum:          tx.num,
+			ValidationCode: int32(tx.code),
+			Event:          tx.validEvent(),
+		})
+	}
+	return records
+}
+
+// validWrites returns the writes of all valid transactions in the block, including the hashes of the private
+// data writes and the private data itself if it was delivered.
+func validWrites(blockNum uint64, txs []*blockTx) []storage.WriteRecord {
+	writes := []storage.WriteRecord{}
+	for _, tx := range txs {
+		if tx.code != peer.TxValidationCode_VALID {
+			continue
+		}
+		for _, rw := range tx.rwsets {
+			writes = append(writes, records(rw.Namespace, blockNum, tx.num, rw.TxID, rw.Rwset)...)
+			for _, coll := range rw.Collections {
+				writes = append(writes, hashedRecords(storage.HashedNamespace(rw.Namespace, coll.Collection), blockNum, tx.num, rw.TxID, coll.Rwset)...)
+			}
+		}
+		for _, p := range tx.private {
+			writes = append(writes, records(storage.PrivateNamespace(p.namespace, p.collection), blockNum, tx.num, tx.id, p.rwset)...)
+		}
+	}
+	return writes
 }
 
 // records returns the writes in a format that makes them easy to store.
@@ -161,6 +505,23 @@ func records(namespace string, blockNum, txNum uint64, txID string, rws *kvrwset
 	return writes
 }
 
+// hashedRecords returns the hashed writes of a collection, with the key hash as key and the value hash as value.
+func hashedRecords(namespace string, blockNum, txNum uint64, txID string, rws *kvrwset.HashedRWSet) []storage.WriteRecord {
+	writes := make([]storage.WriteRecord, len(rws.HashedWrites))
+	for i, w := range rws.HashedWrites {
+		writes[i] = storage.WriteRecord{
+			Namespace: namespace,
+			BlockNum:  blockNum,
+			TxNum:     txNum,
+			TxID:      txID,
+			Key:       storage.HashedKey(w.KeyHash),
+			Value:     w.ValueHash,
+			IsDelete:  w.IsDelete,
+		}
+	}
+	return writes
+}
+
 func (c *Committer) BlockHeight() (uint64, error) {
 	lpb, err := c.db.LastProcessedBlock()
 	if err != nil {
@@ -200,6 +561,9 @@ func (c *Committer) WaitUntilSynced(ctx context.Context) error {
 		case <-ctx.Done():
 			return fmt.Errorf("time out waiting for sync")
 		case <-ticker.C:
+			if st := c.state.status(); st.Halted {
+				return fmt.Errorf("committer halted: %w", st.LastError)
+			}
 			peerHeight, err := c.PeerBlockHeight()
 			if err != nil {
 				backoff *= 2
@@ -0,0 +1,374 @@
+package committer
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/arner/hacky-fabric/blockstore"
+	"github.com/arner/hacky-fabric/comm"
+	"github.com/arner/hacky-fabric/comm/commtest"
+	"github.com/arner/hacky-fabric/cryptogen"
+	"github.com/arner/hacky-fabric/fabrictx"
+	"github.com/arner/hacky-fabric/storage"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+	_ "modernc.org/sqlite"
+)
+
+func TestPrivateData(t *testing.T) {
+	submitter, err := fabrictx.SignerFromMSP("../fabrictx/fixtures/user", "Org1MSP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endorser, err := fabrictx.SignerFromMSP("../fabrictx/fixtures/endorser", "Org1MSP")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public := &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "public", Value: []byte("p")}}}
+	collections := []fabrictx.CollectionRwset{
+		{Collection: "coll1", Rwset: &kvrwset.KVRWSet{
+			Reads:  []*kvrwset.KVRead{{Key: "read", Version: &kvrwset.Version{BlockNum: 1}}},
+			Writes: []*kvrwset.KVWrite{{Key: "secret", Value: []byte("s")}, {Key: "gone", IsDelete: true}},
+		}},
+		{Collection: "coll2", Rwset: &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "other", Value: []byte("o")}}}},
+	}
+	env, txID, pvt, err := fabrictx.NewEndorserTxWithPrivateData("mychannel", "basic", submitter, []fabrictx.Signer{endorser}, public, collections)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// coll2 doesn't match the hash, so only its hashes are stored
+	pvt.NsPvtRwset[0].CollectionPvtRwset[1].Rwset = mustMarshal(t, &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "other", Value: []byte("tampered")}}})
+
+	block := &common.Block{
+		Header: &common.BlockHeader{Number: 5},
+		Data:   &common.BlockData{Data: [][]byte{mustMarshal(t, env)}},
+	}
+	txs, num, err := parseBlock(&peer.DeliverResponse_BlockAndPrivateData{
+		BlockAndPrivateData: &peer.BlockAndPrivateData{Block: block, PrivateDataMap: map[uint64]*rwset.TxPvtReadWriteSet{0: pvt}},
+	}, false, nopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 1 || txs[0].id != txID || len(txs[0].private) != 1 {
+		t.Fatalf("unexpected transactions %+v", txs)
+	}
+
+	got := map[string]storage.WriteRecord{}
+	for _, w := range validWrites(num, txs) {
+		got[w.Namespace+":"+w.Key] = w
+	}
+	expected := map[string][]byte{
+		"basic:public": []byte("p"),
+		storage.PrivateNamespace("basic", "coll1") + ":secret":                      []byte("s"),
+		storage.PrivateNamespace("basic", "coll1") + ":gone":                        nil,
+		storage.HashedNamespace("basic", "coll1") + ":" + storage.KeyHash("secret"): fabrictx.Hash([]byte("s")),
+		storage.HashedNamespace("basic", "coll1") + ":" + storage.KeyHash("gone"):   nil,
+		storage.HashedNamespace("basic", "coll2") + ":" + storage.KeyHash("other"):  fabrictx.Hash([]byte("o")),
+	}
+	if len(got) != len(expected) {
+		t.Errorf("expected %d writes, got %d: %v", len(expected), len(got), got)
+	}
+	for k, v := range expected {
+		w, ok := got[k]
+		if !ok {
+			t.Errorf("missing write %q", k)
+			continue
+		}
+		if !bytes.Equal(w.Value, v) || w.IsDelete != (v == nil) || w.BlockNum != 5 {
+			t.Errorf("unexpected write %q: %+v", k, w)
+		}
+	}
+
+	// the private reads are validated against the hashes
+	hashedRead := storage.HashedNamespace("basic", "coll1") + ":" + storage.KeyHash("read")
+	v := newMVCCValidator(mockState{hashedRead: {Key: storage.KeyHash("read"), BlockNum: 1}}, 5)
+	if code, reason, err := v.validate(txs[0]); err != nil || code != peer.TxValidationCode_VALID {
+		t.Errorf("expected valid transaction, got %s %s (err: %v)", code, reason, err)
+	}
+	v = newMVCCValidator(mockState{hashedRead: {Key: storage.KeyHash("read"), BlockNum: 2}}, 5)
+	if code, _, _ := v.validate(txs[0]); code != peer.TxValidationCode_MVCC_READ_CONFLICT {
+		t.Errorf("expected a read conflict on the private read, got %s", code)
+	}
+}
+
+func TestChaincodeEvent(t *testing.T) {
+	submitter, err := fabrictx.SignerFromMSP("../fabrictx/fixtures/user", "Org1MSP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endorser, err := fabrictx.SignerFromMSP("../fabrictx/fixtures/endorser", "Org1MSP")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rws := []*rwset.NsReadWriteSet{{Namespace: "basic", Rwset: mustMarshal(t, &kvrwset.KVRWSet{
+		Writes: []*kvrwset.KVWrite{{Key: "key", Value: []byte("value")}},
+	})}}
+	event := &peer.ChaincodeEvent{EventName: "created", Payload: []byte("key")}
+	withEvent, txID, err := fabrictx.NewEndorserTxWithEvent("mychannel", "basic", "1.0", submitter, []fabrictx.Signer{endorser}, rws, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutEvent, _, err := fabrictx.NewEndorserTxWithNsRwSet("mychannel", "basic", "1.0", submitter, []fabrictx.Signer{endorser}, rws)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := &common.Block{
+		Header: &common.BlockHeader{Number: 3},
+		Data:   &common.BlockData{Data: [][]byte{mustMarshal(t, withEvent), mustMarshal(t, withoutEvent)}},
+	}
+	txs, num, err := parseBlock(&peer.DeliverResponse_BlockAndPrivateData{
+		BlockAndPrivateData: &peer.BlockAndPrivateData{Block: block},
+	}, false, nopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 2 {
+		t.Fatalf("expected 2 transactions, got %d", len(txs))
+	}
+
+	records := txRecords(num, txs)
+	ev := records[0].Event
+	if ev == nil || ev.Namespace != "basic" || ev.Name != "created" || string(ev.Payload) != "key" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	if records[0].TxID != txID || records[1].Event != nil {
+		t.Errorf("unexpected records %+v", records)
+	}
+
+	// events of invalid transactions are not delivered
+	txs[0].code = peer.TxValidationCode_MVCC_READ_CONFLICT
+	if st := statuses(num, txs); st[0].Event != nil {
+		t.Errorf("expected no event for an invalid transaction, got %+v", st[0].Event)
+	}
+}
+
+func TestRun(t *testing.T) {
+	org1, err := cryptogen.NewOrg(cryptogen.OrgSpec{Domain: "org1.example.com", MSPID: "Org1MSP", Peers: 1, Users: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := org1.Users[0].Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	endorser, err := org1.Peers[0].Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := commtest.NewServer("mychannel", commtest.WithOrgs(org1), commtest.WithBatchSize(1, time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	sqlDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	defer sqlDB.Close()
+	db := storage.New("mychannel", sqlDB)
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := comm.NewPeer(s.Addr, s.TLSCACert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	orderer, err := comm.NewOrderer(s.Addr, s.TLSCACert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orderer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	store, err := blockstore.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	c, err := NewCommitter(ctx, db, "mychannel", p, user, nopLogger{}, WithBlockStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go c.Run()
+	defer c.Stop()
+
+	public := &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "public", Value: []byte("p")}}}
+	collections := []fabrictx.CollectionRwset{
+		{Collection: "coll1", Rwset: &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "secret", Value: []byte("s")}}}},
+	}
+	env, txID, pvt, err := fabrictx.NewEndorserTxWithPrivateData("mychannel", "basic", user, []fabrictx.Signer{endorser}, public, collections)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AddPrivateData(txID, pvt)
+	if err := orderer.Broadcast(env); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := c.WaitForTx(ctx, txID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Valid() || st.BlockNum != 1 {
+		t.Errorf("unexpected status %s", st)
+	}
+	if err := c.WaitUntilSynced(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	checkWrites(t, db)
+
+	// rebuild the database from the archived blocks, without the peer
+	replayDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayDB.SetMaxOpenConns(1)
+	defer replayDB.Close()
+	db = storage.New("mychannel", replayDB)
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	c, err = NewCommitter(ctx, db, "mychannel", nil, nil, nopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Replay(store); err != nil {
+		t.Fatal(err)
+	}
+	checkWrites(t, db)
+}
+
+func TestRunBlockStoreBehind(t *testing.T) {
+	org1, err := cryptogen.NewOrg(cryptogen.OrgSpec{Domain: "org1.example.com", MSPID: "Org1MSP", Peers: 1, Users: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := org1.Users[0].Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	endorser, err := org1.Peers[0].Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := commtest.NewServer("mychannel", commtest.WithOrgs(org1), commtest.WithBatchSize(1, time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	p, err := comm.NewPeer(s.Addr, s.TLSCACert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	orderer, err := comm.NewOrderer(s.Addr, s.TLSCACert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orderer.Close()
+
+	sqlDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	defer sqlDB.Close()
+	db := storage.New("mychannel", sqlDB)
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	store, err := blockstore.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// commit transactions with a committer that runs until they are committed
+	commit := func(n int, opts ...Option) *Committer {
+		c, err := NewCommitter(ctx, db, "mychannel", p, user, nopLogger{}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stopped := make(chan struct{})
+		go func() {
+			c.Run()
+			close(stopped)
+		}()
+		t.Cleanup(func() {
+			c.Stop()
+			<-stopped
+		})
+		for range n {
+			env, txID, err := fabrictx.NewEndorserTransaction("mychannel", "basic", user, []fabrictx.Signer{endorser}, &kvrwset.KVRWSet{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := orderer.Broadcast(env); err != nil {
+				t.Fatal(err)
+			}
+			if st, err := c.WaitForTx(ctx, txID); err != nil || !st.Valid() {
+				t.Fatalf("unexpected status %s (%v)", st, err)
+			}
+		}
+		c.Stop()
+		<-stopped
+		return c
+	}
+
+	// the store has block 1, the database blocks 1 to 3
+	commit(1, WithBlockStore(store))
+	commit(2)
+	if h := store.Height(); h != 2 {
+		t.Fatalf("expected store height 2, got %d", h)
+	}
+
+	c := commit(1, WithBlockStore(store))
+	if h := store.Height(); h != 5 {
+		t.Errorf("expected the missing blocks in the store, got height %d", h)
+	}
+	if st := c.Status(); st.LastError != nil || st.Retries != 0 {
+		t.Errorf("unexpected status %s", st)
+	}
+	if last, err := db.LastProcessedBlock(); err != nil || last != 4 {
+		t.Errorf("expected last block 4, got %d (%v)", last, err)
+	}
+}
+
+func checkWrites(t *testing.T, db *storage.VersionedDB) {
+	t.Helper()
+	w, err := db.GetCurrent("basic", "public")
+	if err != nil || w == nil || string(w.Value) != "p" {
+		t.Errorf("expected public write, got %+v (%v)", w, err)
+	}
+	w, err = db.GetPrivateData("basic", "coll1", "secret", 1)
+	if err != nil || w == nil || string(w.Value) != "s" {
+		t.Errorf("expected private write, got %+v (%v)", w, err)
+	}
+}
+
+func mustMarshal(t *testing.T, m proto.Message) []byte {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
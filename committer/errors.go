@@ -0,0 +1,88 @@
+package committer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/arner/hacky-fabric/storage"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+// ErrorPolicy decides what the committer does with a block that it can't process completely: a block without
+// a (complete) TRANSACTIONS_FILTER, or a valid transaction of which the read/write set or chaincode event can't be
+// parsed. These failures are permanent: the peer delivers the same block again. Database and stream errors are
+// transient and always retried by Run.
+type ErrorPolicy int
+
+const (
+	// Halt returns an error that stops Run at the block (default). The status of the committer reports the error.
+	Halt ErrorPolicy = iota
+	// Retry returns an error, so that Run reconnects and retries the block after a backoff, for instance because
+	// the private data may still arrive. Run halts after MaxBlockRetries attempts at the same block.
+	Retry
+	// SkipAndRecord commits what could be processed, records the rest in the dead-letter table and continues.
+	SkipAndRecord
+)
+
+// MaxBlockRetries is the number of times that Run retries a block that can't be processed with the Retry policy.
+var MaxBlockRetries = 5
+
+func (p ErrorPolicy) String() string {
+	switch p {
+	case Halt:
+		return "halt"
+	case Retry:
+		return "retry"
+	case SkipAndRecord:
+		return "skip and record"
+	}
+	return fmt.Sprintf("ErrorPolicy(%d)", int(p))
+}
+
+// WithErrorPolicy sets what to do with blocks that can't be processed completely.
+func WithErrorPolicy(p ErrorPolicy) Option {
+	return func(c *Committer) {
+		c.errorPolicy = p
+	}
+}
+
+// ProcessError is returned for a block that can't be processed completely, unless the error policy is
+// SkipAndRecord. The block is not marked as processed.
+type ProcessError struct {
+	BlockNum uint64
+	Failures []storage.DeadLetter
+	Halt     bool // the error policy is Halt, or the block was retried MaxBlockRetries times
+}
+
+func (e *ProcessError) Error() string {
+	reasons := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		if f.TxNum < 0 {
+			reasons[i] = f.Reason
+		} else {
+			reasons[i] = fmt.Sprintf("tx %d (%s): %s", f.TxNum, f.TxID, f.Reason)
+		}
+	}
+	return fmt.Sprintf("block %d can't be processed: %s", e.BlockNum, strings.Join(reasons, "; "))
+}
+
+// DeadLetters returns the blocks and transactions that were skipped with SkipAndRecord, from a block on.
+func (c *Committer) DeadLetters(fromBlock uint64) ([]storage.DeadLetter, error) {
+	return c.db.GetDeadLetters(fromBlock)
+}
+
+// deadLetters returns what can't be processed of a block: the whole block, or the valid transactions with
+// writes or events that are missing. Invalid transactions don't need to be processed.
+func deadLetters(b *parsedBlock) []storage.DeadLetter {
+	if b.err != nil {
+		return []storage.DeadLetter{{BlockNum: b.num, TxNum: -1, Reason: b.err.Error()}}
+	}
+	var out []storage.DeadLetter
+	for _, tx := range b.txs {
+		if tx.err != nil && tx.code == peer.TxValidationCode_VALID {
+			out = append(out, storage.DeadLetter{BlockNum: b.num, TxNum: int64(tx.num), TxID: tx.id, Reason: tx.err.Error()})
+		}
+	}
+	return out
+}
@@ -0,0 +1,170 @@
+package committer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/arner/hacky-fabric/comm"
+	"github.com/arner/hacky-fabric/comm/commtest"
+	"github.com/arner/hacky-fabric/cryptogen"
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+func TestErrorPolicy(t *testing.T) {
+	submitter, err := fabrictx.SignerFromMSP("../fabrictx/fixtures/user", "Org1MSP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endorser, err := fabrictx.SignerFromMSP("../fabrictx/fixtures/endorser", "Org1MSP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rws := &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "key", Value: []byte("value")}}}
+	env, txID, err := fabrictx.NewEndorserTransaction("mychannel", "basic", submitter, []fabrictx.Signer{endorser}, rws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := brokenEnvelope(t)
+	newBlock := func(num uint64, filter []byte, envs ...*common.Envelope) *peer.DeliverResponse_BlockAndPrivateData {
+		b, err := fabrictx.NewBlock(num, nil, envs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] = filter
+		return &peer.DeliverResponse_BlockAndPrivateData{BlockAndPrivateData: &peer.BlockAndPrivateData{Block: b}}
+	}
+	withoutFilter := newBlock(1, nil, env)
+	withBrokenTx := newBlock(1, []byte{0, 0}, env, broken)
+
+	for _, policy := range []ErrorPolicy{Retry, Halt} {
+		c := newTestCommitter(t, WithErrorPolicy(policy))
+		for _, block := range []*peer.DeliverResponse_BlockAndPrivateData{withoutFilter, withBrokenTx} {
+			err := c.ProcessBlock(block)
+			var perr *ProcessError
+			if !errors.As(err, &perr) || perr.BlockNum != 1 || perr.Halt != (policy == Halt) {
+				t.Errorf("%s: unexpected error %v", policy, err)
+			}
+		}
+		if last, _ := c.db.LastProcessedBlock(); last != 0 {
+			t.Errorf("%s: expected no processed blocks, got %d", policy, last)
+		}
+	}
+
+	c := newTestCommitter(t, WithErrorPolicy(SkipAndRecord))
+	if err := c.ProcessBlock(withoutFilter); err != nil {
+		t.Fatal(err)
+	}
+	withBrokenTx.BlockAndPrivateData.Block.Header.Number = 2
+	if err := c.ProcessBlock(withBrokenTx); err != nil {
+		t.Fatal(err)
+	}
+	// the number of a block without header is unknown, so it can't be skipped
+	if err := c.ProcessBlock(&peer.DeliverResponse_BlockAndPrivateData{BlockAndPrivateData: &peer.BlockAndPrivateData{Block: &common.Block{}}}); err == nil {
+		t.Error("expected an error for a block without header")
+	}
+
+	if last, _ := c.db.LastProcessedBlock(); last != 2 {
+		t.Errorf("expected last block 2, got %d", last)
+	}
+	dead, err := c.DeadLetters(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 2 || dead[0].BlockNum != 1 || dead[0].TxNum != -1 || dead[1].BlockNum != 2 || dead[1].TxNum != 1 || dead[1].TxID != "broken" {
+		t.Errorf("unexpected dead letters %+v", dead)
+	}
+	if w, err := c.db.GetCurrent("basic", "key"); err != nil || w == nil || w.BlockNum != 2 {
+		t.Errorf("expected the write of the valid transaction in block 2, got %+v (%v)", w, err)
+	}
+	if tx, err := c.db.GetTx(txID); err != nil || tx == nil || tx.BlockNum != 2 {
+		t.Errorf("expected the transaction in block 2, got %+v (%v)", tx, err)
+	}
+}
+
+// brokenEnvelope returns a transaction that the peer says is valid, but of which the read/write set can't be parsed.
+func brokenEnvelope(t *testing.T) *common.Envelope {
+	return &common.Envelope{Payload: mustMarshal(t, &common.Payload{
+		Header: &common.Header{ChannelHeader: mustMarshal(t, &common.ChannelHeader{
+			Type: int32(common.HeaderType_ENDORSER_TRANSACTION), ChannelId: "mychannel", TxId: "broken",
+		})},
+		Data: []byte("garbage"),
+	})}
+}
+
+// TestRunStopsAtBrokenBlock checks that Run doesn't keep reconnecting for a block that will never be processed.
+func TestRunStopsAtBrokenBlock(t *testing.T) {
+	org1, err := cryptogen.NewOrg(cryptogen.OrgSpec{Domain: "org1.example.com", MSPID: "Org1MSP", Users: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := org1.Users[0].Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := commtest.NewServer("mychannel", commtest.WithOrgs(org1), commtest.WithBatchSize(1, time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	p, err := comm.NewPeer(s.Addr, s.TLSCACert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	orderer, err := comm.NewOrderer(s.Addr, s.TLSCACert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orderer.Close()
+	if err := orderer.Broadcast(brokenEnvelope(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(n int) { MaxBlockRetries = n }(MaxBlockRetries)
+	MaxBlockRetries = 1
+	for _, policy := range []ErrorPolicy{Halt, Retry} {
+		t.Run(policy.String(), func(t *testing.T) {
+			c := newTestCommitter(t, WithErrorPolicy(policy))
+			c.peer, c.signer = p, user
+
+			done := make(chan error, 1)
+			go func() { done <- c.Run() }()
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("Run keeps retrying the block")
+			}
+			var perr *ProcessError
+			if !errors.As(err, &perr) || perr.BlockNum != 1 || !perr.Halt {
+				t.Errorf("expected a halt at block 1, got %v", err)
+			}
+			st := c.state.status()
+			if !st.Halted || (policy == Retry) != (st.Retries == MaxBlockRetries) {
+				t.Errorf("unexpected status %+v", st)
+			}
+			if last, _ := c.db.LastProcessedBlock(); last != 0 {
+				t.Errorf("expected no processed blocks, got %d", last)
+			}
+		})
+	}
+}
+
+func TestParseBlockNumberOnError(t *testing.T) {
+	b, err := fabrictx.NewBlock(7, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Metadata = nil
+	_, num, err := parseBlock(&peer.DeliverResponse_BlockAndPrivateData{
+		BlockAndPrivateData: &peer.BlockAndPrivateData{Block: b},
+	}, true, nopLogger{})
+	if err == nil || num != 7 {
+		t.Errorf("expected an error for block 7, got %d (%v)", num, err)
+	}
+}
@@ -0,0 +1,154 @@
+package committer
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// ChaincodeEvent is an event that was emitted by a valid transaction.
+type ChaincodeEvent struct {
+	BlockNum  uint64
+	TxNum     uint64
+	TxID      string
+	Namespace string
+	Name      string
+	Payload   []byte
+}
+
+func (e ChaincodeEvent) String() string {
+	return fmt.Sprintf("%d:%d (%s) %s/%s", e.BlockNum, e.TxNum, e.TxID, e.Namespace, e.Name)
+}
+
+// ChaincodeEvents returns a channel that receives the events of valid transactions of a chaincode (namespace)
+// from startBlock on, in block order, like the ChaincodeEvents service of the Fabric Gateway. If names are given,
+// only events with one of those names are delivered. Events of blocks that have already been processed are read
+// from the database, so a consumer can resume after the last block it handled. A consumer that falls too far
+// behind the committer (see TxStatuses) continues from the database as well, so it doesn't miss events. The
+// channel is closed when the context is done, or when the events can't be read from the database.
+func (c *Committer) ChaincodeEvents(ctx context.Context, namespace string, startBlock uint64, names ...string) (<-chan ChaincodeEvent, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &eventStream{c: c, namespace: namespace, names: names, out: make(chan ChaincodeEvent), nextBlock: startBlock}
+	sub, err := s.subscribe(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go func() {
+		defer close(s.out)
+		defer cancel()
+		for {
+			ok := s.deliver(ctx, sub)
+			sub.cancel()
+			if !ok || ctx.Err() != nil {
+				return
+			}
+			// the subscription was dropped because the consumer fell behind.
+			if sub, err = s.subscribe(ctx); err != nil {
+				c.log.Printf("chaincode events of %s: %v", namespace, err)
+				return
+			}
+		}
+	}()
+	return s.out, nil
+}
+
+// eventStream delivers the chaincode events of a namespace to a consumer of ChaincodeEvents.
+type eventStream struct {
+	c         *Committer
+	namespace string
+	names     []string
+	out       chan ChaincodeEvent
+
+	// the position of the first transaction that hasn't been delivered, to skip the events that are both
+	// read from the database and received from the committer.
+	nextBlock, nextTx uint64
+}
+
+// eventSubscription is a subscription to the transaction statuses of the committer, with the events that were
+// committed before it.
+type eventSubscription struct {
+	live   <-chan TxStatus
+	cancel context.CancelFunc
+	past   []ChaincodeEvent
+	last   uint64 // the last processed block when subscribing, up to which past has the events
+}
+
+// subscribe subscribes to the committer and reads the events from the next position of the stream up to the last
+// processed block from the database.
+func (s *eventStream) subscribe(ctx context.Context) (*eventSubscription, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	// subscribe before reading the database, so that we can't miss a block that is committed in between.
+	sub := &eventSubscription{live: s.c.notifier.subscribe(ctx), cancel: cancel}
+
+	var err error
+	if sub.last, err = s.c.db.LastProcessedBlock(); err != nil {
+		cancel()
+		return nil, err
+	}
+	if s.nextBlock > sub.last {
+		return sub, nil
+	}
+	records, err := s.c.db.GetEvents(s.namespace, s.nextBlock, sub.last)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for _, rec := range records {
+		sub.past = append(sub.past, ChaincodeEvent{
+			BlockNum:  rec.BlockNum,
+			TxNum:     rec.TxNum,
+			TxID:      rec.TxID,
+			Namespace: rec.Event.Namespace,
+			Name:      rec.Event.Name,
+			Payload:   rec.Event.Payload,
+		})
+	}
+	return sub, nil
+}
+
+// deliver sends the events of the subscription until it is closed. It returns false if the context is done.
+func (s *eventStream) deliver(ctx context.Context, sub *eventSubscription) bool {
+	for _, e := range sub.past {
+		if !s.send(ctx, e) {
+			return false
+		}
+	}
+	for st := range sub.live {
+		// blocks up to last have been read from the database.
+		if st.BlockNum <= sub.last || st.Event == nil || st.Event.Namespace != s.namespace {
+			continue
+		}
+		e := ChaincodeEvent{
+			BlockNum:  st.BlockNum,
+			TxNum:     st.TxNum,
+			TxID:      st.TxID,
+			Namespace: st.Event.Namespace,
+			Name:      st.Event.Name,
+			Payload:   st.Event.Payload,
+		}
+		if !s.send(ctx, e) {
+			return false
+		}
+	}
+	return true
+}
+
+// send delivers an event, unless it was delivered before or its name is filtered out. It returns false if the
+// context is done.
+func (s *eventStream) send(ctx context.Context, e ChaincodeEvent) bool {
+	if e.BlockNum < s.nextBlock || (e.BlockNum == s.nextBlock && e.TxNum < s.nextTx) {
+		return true
+	}
+	s.nextBlock, s.nextTx = e.BlockNum, e.TxNum+1
+	if len(s.names) > 0 && !slices.Contains(s.names, e.Name) {
+		return true
+	}
+	select {
+	case s.out <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,248 @@
+package committer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arner/hacky-fabric/comm"
+	"github.com/arner/hacky-fabric/fabrictx"
+	"github.com/arner/hacky-fabric/storage"
+)
+
+// Manager follows several channels on the same peer connection, with a committer per channel. The committers
+// share a database: every channel has its own tables and its own row in channel_progress, and reconnects to the
+// peer with its own backoff.
+type Manager struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	db     *sql.DB
+	peer   *comm.Peer
+	signer fabrictx.Signer
+	log    Logger
+	opts   []Option
+
+	mu         sync.Mutex
+	committers map[string]*Committer
+	wg         sync.WaitGroup
+}
+
+// NewManager returns a manager without channels. The options apply to the committers of all channels.
+func NewManager(ctx context.Context, db *sql.DB, peer *comm.Peer, signer fabrictx.Signer, logger Logger, opts ...Option) *Manager {
+	mctx, cancel := context.WithCancel(ctx)
+	return &Manager{
+		ctx:        mctx,
+		cancel:     cancel,
+		db:         db,
+		peer:       peer,
+		signer:     signer,
+		log:        logger,
+		opts:       opts,
+		committers: map[string]*Committer{},
+	}
+}
+
+// AddChannel creates the tables of the channel if needed and starts following it in the background. The options
+// are applied after the options of the manager, for instance to archive the blocks of the channel in its own
+// block store.
+func (m *Manager) AddChannel(channel string, opts ...Option) (*Committer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.ctx.Done():
+		return nil, errors.New("manager stopped")
+	default:
+	}
+	if _, ok := m.committers[channel]; ok {
+		return nil, fmt.Errorf("channel %s already added", channel)
+	}
+
+	db := storage.New(channel, m.db)
+	if err := db.Init(); err != nil {
+		return nil, err
+	}
+	c, err := NewCommitter(m.ctx, db, channel, m.peer, m.signer, channelLogger{m.log, channel}, append(slices.Clone(m.opts), opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	m.committers[channel] = c
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		if err := c.Run(); err != nil {
+			c.log.Printf("committer stopped: %s", err)
+		}
+	}()
+	return c, nil
+}
+
+// Committer returns the committer of a channel, to wait for transactions or follow chaincode events.
+func (m *Manager) Committer(channel string) (*Committer, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.committers[channel]
+	return c, ok
+}
+
+// Channels returns the channels of the manager, sorted.
+func (m *Manager) Channels() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	channels := make([]string, 0, len(m.committers))
+	for ch := range m.committers {
+		channels = append(channels, ch)
+	}
+	slices.Sort(channels)
+	return channels
+}
+
+// Report returns the status of every channel.
+func (m *Manager) Report() Report {
+	var r Report
+	for _, ch := range m.Channels() {
+		c, _ := m.Committer(ch)
+		r.Channels = append(r.Channels, c.Status())
+	}
+	return r
+}
+
+// WaitUntilSynced blocks until the committers of all channels have processed the blocks up to the current
+// height of the peer.
+func (m *Manager) WaitUntilSynced(ctx context.Context) error {
+	for _, ch := range m.Channels() {
+		c, _ := m.Committer(ch)
+		if err := c.WaitUntilSynced(ctx); err != nil {
+			return fmt.Errorf("channel %s: %w", ch, err)
+		}
+	}
+	return nil
+}
+
+// Stop stops the committers and waits until they have returned.
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	m.cancel()
+	m.mu.Unlock()
+	m.wg.Wait()
+}
+
+// Report is the combined status of the channels of a manager.
+type Report struct {
+	Channels []ChannelStatus
+}
+
+// Healthy returns whether every channel is connected to the peer or caught up with it.
+func (r Report) Healthy() bool {
+	for _, st := range r.Channels {
+		if !st.Healthy() {
+			return false
+		}
+	}
+	return true
+}
+
+func (r Report) String() string {
+	lines := make([]string, len(r.Channels))
+	for i, st := range r.Channels {
+		lines[i] = st.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ChannelStatus is the progress of the committer of a channel.
+type ChannelStatus struct {
+	Channel    string
+	Height     uint64        // local block height
+	PeerHeight uint64        // 0 if the peer can't be queried
+	LastError  error         // of the last connection attempt, until a block is received again
+	Retries    int           // consecutive failed connection attempts
+	Backoff    time.Duration // before the next attempt
+	Halted     bool          // Run stopped at a block that can't be processed, see ErrorPolicy
+}
+
+// Synced returns whether all blocks of the peer have been processed.
+func (s ChannelStatus) Synced() bool {
+	return s.PeerHeight > 0 && s.Height >= s.PeerHeight
+}
+
+// Healthy returns whether the committer is running and the last connection to the peer succeeded, or the
+// channel is synced anyway.
+func (s ChannelStatus) Healthy() bool {
+	return !s.Halted && (s.LastError == nil || s.Synced())
+}
+
+func (s ChannelStatus) String() string {
+	out := fmt.Sprintf("%s: height %d/%d", s.Channel, s.Height, s.PeerHeight)
+	if s.Halted {
+		return out + fmt.Sprintf(", halted: %s", s.LastError)
+	}
+	if s.LastError != nil {
+		out += fmt.Sprintf(", %d retries (next in %s): %s", s.Retries, s.Backoff, s.LastError)
+	}
+	return out
+}
+
+// Status returns the progress of the committer. The height of the peer is queried with qscc.
+func (c *Committer) Status() ChannelStatus {
+	st := c.state.status()
+	st.Channel = c.channel
+	if h, err := c.BlockHeight(); err == nil {
+		st.Height = h
+	} else if st.LastError == nil {
+		st.LastError = err
+	}
+	if c.peer != nil {
+		st.PeerHeight, _ = c.PeerBlockHeight()
+	}
+	return st
+}
+
+// runState is what Run reports about its connection to the peer.
+type runState struct {
+	mu      sync.Mutex
+	lastErr error
+	retries int
+	backoff time.Duration
+	halted  bool
+}
+
+func (s *runState) received() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr, s.retries, s.backoff = nil, 0, 0
+}
+
+func (s *runState) failed(err error, backoff time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr, s.backoff = err, backoff
+	s.retries++
+}
+
+func (s *runState) halt(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr, s.backoff, s.halted = err, 0, true
+}
+
+func (s *runState) status() ChannelStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return ChannelStatus{LastError: s.lastErr, Retries: s.retries, Backoff: s.backoff, Halted: s.halted}
+}
+
+// channelLogger prefixes the messages of a committer with its channel.
+type channelLogger struct {
+	log     Logger
+	channel string
+}
+
+func (l channelLogger) Printf(format string, v ...any) {
+	l.log.Printf("[%s] "+format, append([]any{l.channel}, v...)...)
+}
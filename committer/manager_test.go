@@ -0,0 +1,100 @@
+package committer
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/arner/hacky-fabric/comm"
+	"github.com/arner/hacky-fabric/comm/commtest"
+	"github.com/arner/hacky-fabric/cryptogen"
+	"github.com/arner/hacky-fabric/fabrictx"
+	"github.com/arner/hacky-fabric/storage"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	_ "modernc.org/sqlite"
+)
+
+func TestManager(t *testing.T) {
+	org1, err := cryptogen.NewOrg(cryptogen.OrgSpec{Domain: "org1.example.com", MSPID: "Org1MSP", Peers: 1, Users: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := org1.Users[0].Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	endorser, err := org1.Peers[0].Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := commtest.NewServer("mychannel", commtest.WithOrgs(org1), commtest.WithBatchSize(1, time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	p, err := comm.NewPeer(s.Addr, s.TLSCACert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	orderer, err := comm.NewOrderer(s.Addr, s.TLSCACert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orderer.Close()
+
+	sqlDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	defer sqlDB.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	m := NewManager(ctx, sqlDB, p, user, nopLogger{})
+	defer m.Stop()
+	// the peer doesn't serve otherchannel, which must not hold up mychannel
+	for _, ch := range []string{"mychannel", "otherchannel"} {
+		if _, err := m.AddChannel(ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.AddChannel("mychannel"); err == nil {
+		t.Error("expected an error for a channel that was already added")
+	}
+
+	rws := &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "key", Value: []byte("value")}}}
+	env, txID, err := fabrictx.NewEndorserTransaction("mychannel", "basic", user, []fabrictx.Signer{endorser}, rws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := orderer.Broadcast(env); err != nil {
+		t.Fatal(err)
+	}
+	c, _ := m.Committer("mychannel")
+	if st, err := c.WaitForTx(ctx, txID); err != nil || !st.Valid() {
+		t.Fatalf("unexpected status %s (%v)", st, err)
+	}
+	w, err := storage.New("mychannel", sqlDB).GetCurrent("basic", "key")
+	if err != nil || w == nil || string(w.Value) != "value" {
+		t.Errorf("expected the write in the tables of mychannel, got %+v (%v)", w, err)
+	}
+
+	var report Report
+	for report = m.Report(); report.Channels[1].Retries == 0; report = m.Report() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if report.Healthy() {
+		t.Errorf("expected an unhealthy report:\n%s", report)
+	}
+	mine, other := report.Channels[0], report.Channels[1]
+	if mine.Channel != "mychannel" || !mine.Healthy() || mine.Height != 2 {
+		t.Errorf("unexpected status %s", mine)
+	}
+	if other.Channel != "otherchannel" || other.Healthy() || other.Height != 1 {
+		t.Errorf("unexpected status %s", other)
+	}
+}
@@ -0,0 +1,165 @@
+package committer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/arner/hacky-fabric/storage"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+// TxStatus is the final status of a transaction that has been committed in a block.
+type TxStatus struct {
+	TxID     string
+	BlockNum uint64
+	TxNum    uint64
+	Code     peer.TxValidationCode
+	Event    *storage.Event // only for valid transactions
+}
+
+// Valid returns whether the writes of the transaction have been applied to the world state.
+func (s TxStatus) Valid() bool {
+	return s.Code == peer.TxValidationCode_VALID
+}
+
+func (s TxStatus) String() string {
+	return fmt.Sprintf("%d:%d (%s) %s", s.BlockNum, s.TxNum, s.TxID, s.Code)
+}
+
+// WaitForTx blocks until the transaction is committed (valid or invalid) and returns its status.
+// It returns immediately if the transaction has already been processed.
+func (c *Committer) WaitForTx(ctx context.Context, txID string) (TxStatus, error) {
+	// register before looking in the database, so that we can't miss a block that is committed in between.
+	ch := c.notifier.register(txID)
+	defer c.notifier.unregister(txID, ch)
+
+	rec, err := c.db.GetTx(txID)
+	if err != nil {
+		return TxStatus{}, err
+	}
+	if rec != nil {
+		return txStatus(*rec), nil
+	}
+
+	select {
+	case st := <-ch:
+		return st, nil
+	case <-ctx.Done():
+		return TxStatus{}, fmt.Errorf("wait for transaction %s: %w", txID, ctx.Err())
+	}
+}
+
+// TxStatuses returns a channel that receives the status of every transaction that is committed from now on,
+// in block order. The channel is closed when the context is done, or when the consumer falls more than
+// subscriberBuffer statuses behind, so that a slow consumer can't block the committer.
+func (c *Committer) TxStatuses(ctx context.Context) <-chan TxStatus {
+	return c.notifier.subscribe(ctx)
+}
+
+func statuses(blockNum uint64, txs []*blockTx) []TxStatus {
+	out := make([]TxStatus, 0, len(txs))
+	for _, tx := range txs {
+		if tx.id == "" {
+			continue
+		}
+		out = append(out, TxStatus{TxID: tx.id, BlockNum: blockNum, TxNum: tx.num, Code: tx.code, Event: tx.validEvent()})
+	}
+	return out
+}
+
+// txStatus converts a stored transaction record.
+func txStatus(rec storage.TxRecord) TxStatus {
+	return TxStatus{TxID: rec.TxID, BlockNum: rec.BlockNum, TxNum: rec.TxNum, Code: peer.TxValidationCode(rec.ValidationCode), Event: rec.Event}
+}
+
+// notifier distributes transaction statuses to the callers of WaitForTx and to subscribers.
+type notifier struct {
+	mu      sync.Mutex
+	waiters map[string][]chan TxStatus
+	subs    map[chan TxStatus]struct{}
+}
+
+func newNotifier() *notifier {
+	return &notifier{
+		waiters: make(map[string][]chan TxStatus),
+		subs:    make(map[chan TxStatus]struct{}),
+	}
+}
+
+func (n *notifier) register(txID string) chan TxStatus {
+	ch := make(chan TxStatus, 1)
+	n.mu.Lock()
+	n.waiters[txID] = append(n.waiters[txID], ch)
+	n.mu.Unlock()
+	return ch
+}
+
+func (n *notifier) unregister(txID string, ch chan TxStatus) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	waiters := n.waiters[txID]
+	for i, w := range waiters {
+		if w == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(n.waiters, txID)
+	} else {
+		n.waiters[txID] = waiters
+	}
+}
+
+// subscriberBuffer is the number of statuses that a subscriber can fall behind before it is dropped.
+const subscriberBuffer = 100
+
+func (n *notifier) subscribe(ctx context.Context) <-chan TxStatus {
+	ch := make(chan TxStatus, subscriberBuffer)
+	n.mu.Lock()
+	n.subs[ch] = struct{}{}
+	n.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		n.mu.Lock()
+		n.drop(ch)
+		n.mu.Unlock()
+	}()
+	return ch
+}
+
+// drop closes a subscription, if it is still open. The lock must be held.
+func (n *notifier) drop(ch chan TxStatus) {
+	if _, ok := n.subs[ch]; ok {
+		delete(n.subs, ch)
+		close(ch)
+	}
+}
+
+// publish notifies waiters and subscribers. Sending never blocks, so it can happen while holding the lock:
+// waiters receive a single status in a buffered channel, and subscribers whose buffer is full are dropped.
+func (n *notifier) publish(statuses []TxStatus) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, st := range statuses {
+		// only the first occurrence of a transaction ID counts, duplicates are invalid.
+		for _, w := range n.waiters[st.TxID] {
+			select {
+			case w <- st:
+			default:
+			}
+		}
+		delete(n.waiters, st.TxID)
+
+		for ch := range n.subs {
+			select {
+			case ch <- st:
+			default:
+				n.drop(ch)
+			}
+		}
+	}
+}
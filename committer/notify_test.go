@@ -0,0 +1,195 @@
+package committer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNotifier(t *testing.T) {
+	n := newNotifier()
+	ctx, cancel := context.WithCancel(t.Context())
+	sub := n.subscribe(ctx)
+
+	ch := n.register("tx1")
+	n.publish([]TxStatus{
+		{TxID: "tx1", BlockNum: 5, TxNum: 0, Code: peer.TxValidationCode_VALID},
+		{TxID: "tx1", BlockNum: 5, TxNum: 1, Code: peer.TxValidationCode_DUPLICATE_TXID},
+	})
+
+	select {
+	case st := <-ch:
+		if !st.Valid() || st.TxNum != 0 {
+			t.Errorf("expected first occurrence to be returned, got %s", st)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter was not notified")
+	}
+	n.unregister("tx1", ch)
+
+	for i := range 2 {
+		st := <-sub
+		if st.TxNum != uint64(i) {
+			t.Errorf("expected tx %d, got %s", i, st)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-sub:
+		if ok {
+			t.Error("expected subscription to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscription was not closed")
+	}
+}
+
+func TestSlowConsumer(t *testing.T) {
+	c := newTestCommitter(t)
+	blocks := fixtureChain(t, subscriberBuffer)
+
+	// consumers that never read
+	statuses := c.TxStatuses(t.Context())
+	if _, err := c.ChaincodeEvents(t.Context(), "basic", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		for _, b := range blocks {
+			if err := c.ProcessBlock(b); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the committer is blocked by a consumer")
+	}
+
+	env := &common.Envelope{}
+	if err := proto.Unmarshal(blocks[0].BlockAndPrivateData.Block.Data.Data[0], env); err != nil {
+		t.Fatal(err)
+	}
+	chdr, err := fabrictx.ChannelHeader(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+	if _, err := c.WaitForTx(ctx, chdr.TxId); err != nil {
+		t.Fatal(err)
+	}
+
+	// the subscription was dropped when its buffer was full
+	n := 0
+	for range statuses {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("expected %d statuses before the subscription was closed, got %d", subscriberBuffer, n)
+	}
+}
+
+// eventChain returns blocks numbered from 1 with a transaction that emits an event of basic.
+func eventChain(t *testing.T, n int) []*peer.DeliverResponse_BlockAndPrivateData {
+	submitter, err := fabrictx.SignerFromMSP("../fabrictx/fixtures/user", "Org1MSP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endorser, err := fabrictx.SignerFromMSP("../fabrictx/fixtures/endorser", "Org1MSP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rws := []*rwset.NsReadWriteSet{{Namespace: "basic", Rwset: mustMarshal(t, &kvrwset.KVRWSet{
+		Writes: []*kvrwset.KVWrite{{Key: "key", Value: []byte("value")}},
+	})}}
+
+	blocks := make([]*peer.DeliverResponse_BlockAndPrivateData, n)
+	for i := range blocks {
+		event := &peer.ChaincodeEvent{EventName: "created", Payload: []byte{byte(i)}}
+		env, _, err := fabrictx.NewEndorserTxWithEvent("mychannel", "basic", "1.0", submitter, []fabrictx.Signer{endorser}, rws, event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks[i] = &peer.DeliverResponse_BlockAndPrivateData{BlockAndPrivateData: &peer.BlockAndPrivateData{Block: &common.Block{
+			Header:   &common.BlockHeader{Number: uint64(i + 1)},
+			Data:     &common.BlockData{Data: [][]byte{mustMarshal(t, env)}},
+			Metadata: &common.BlockMetadata{Metadata: [][]byte{{}, {}, {byte(peer.TxValidationCode_VALID)}}},
+		}}}
+	}
+	return blocks
+}
+
+func TestChaincodeEventsSlowConsumer(t *testing.T) {
+	c := newTestCommitter(t)
+	// 10 blocks are read from the database, the others are committed while the consumer doesn't read, so that it
+	// falls behind and continues from the database.
+	blocks := eventChain(t, subscriberBuffer+20)
+	for _, b := range blocks[:10] {
+		if err := c.ProcessBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	events, err := c.ChaincodeEvents(ctx, "basic", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range blocks[10 : len(blocks)-1] {
+		if err := c.ProcessBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	next := func() ChaincodeEvent {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatal("events channel was closed")
+			}
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event received")
+		}
+		return ChaincodeEvent{}
+	}
+	for num := uint64(2); num < uint64(len(blocks)); num++ {
+		if e := next(); e.BlockNum != num || e.TxNum != 0 || e.Name != "created" {
+			t.Fatalf("expected the event of block %d, got %s", num, e)
+		}
+	}
+	// the stream continues with the blocks that are committed next
+	if err := c.ProcessBlock(blocks[len(blocks)-1]); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e.BlockNum != uint64(len(blocks)) {
+		t.Fatalf("expected the event of block %d, got %s", len(blocks), e)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("expected the events channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("events channel was not closed")
+	}
+}
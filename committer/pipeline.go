@@ -0,0 +1,133 @@
+package committer
+
+import (
+	"sync"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+// WithPipeline parses the blocks from the peer in parallel workers, while they are still committed in order.
+// Up to depth blocks are queued, and the consecutive blocks that are parsed are committed together in a single
+// database transaction, which speeds up the initial sync of a long chain. When validating locally, blocks are
+// still parsed in parallel but committed one by one.
+func WithPipeline(workers, depth int) Option {
+	return func(c *Committer) {
+		c.workers = max(workers, 1)
+		c.depth = max(depth, 1)
+	}
+}
+
+// pipeline parses blocks in workers and commits them in the order in which they were submitted.
+type pipeline struct {
+	c        *Committer
+	onCommit func()
+	work     chan *pipelineBlock // to the workers
+	ordered  chan *pipelineBlock // to the commit loop, in order
+	done     chan struct{}       // closed when the commit loop returns
+	err      error               // of the commit loop, set before done is closed
+	workers  sync.WaitGroup
+}
+
+type pipelineBlock struct {
+	block  *peer.DeliverResponse_BlockAndPrivateData
+	parsed *parsedBlock
+	ready  chan struct{} // closed when parsed is set
+}
+
+func newPipeline(c *Committer, workers, depth int, onCommit func()) *pipeline {
+	p := &pipeline{
+		c:        c,
+		onCommit: onCommit,
+		work:     make(chan *pipelineBlock, depth),
+		ordered:  make(chan *pipelineBlock, depth),
+		done:     make(chan struct{}),
+	}
+	for range workers {
+		p.workers.Add(1)
+		go func() {
+			defer p.workers.Done()
+			for b := range p.work {
+				b.parsed = c.parse(b.block)
+				close(b.ready)
+			}
+		}()
+	}
+	go p.commitLoop(depth)
+	return p
+}
+
+// submit queues a block. It blocks while depth blocks are queued, and returns the error of a failed commit, after
+// which the pipeline doesn't accept blocks anymore.
+func (p *pipeline) submit(block *peer.DeliverResponse_BlockAndPrivateData) error {
+	// a failed commit must be reported even if there is room in the queue, which select would pick at random.
+	select {
+	case <-p.done:
+		return p.err
+	default:
+	}
+	b := &pipelineBlock{block: block, ready: make(chan struct{})}
+	select {
+	case p.ordered <- b:
+	case <-p.done:
+		return p.err
+	}
+	p.work <- b
+	return nil
+}
+
+// close waits until the queued blocks are committed and returns the error of a failed commit. Blocks can't be
+// submitted concurrently.
+func (p *pipeline) close() error {
+	close(p.ordered)
+	close(p.work)
+	<-p.done
+	p.workers.Wait()
+	return p.err
+}
+
+func (p *pipeline) commitLoop(maxBatch int) {
+	defer close(p.done)
+	for b := range p.ordered {
+		<-b.ready
+		batch := []*pipelineBlock{b}
+		if p.c.validation == TrustPeer {
+			batch = p.collect(batch, maxBatch)
+		}
+		if err := p.commit(batch); err != nil {
+			p.err = err
+			return
+		}
+	}
+}
+
+// collect adds the blocks that are already queued to the batch.
+func (p *pipeline) collect(batch []*pipelineBlock, maxBatch int) []*pipelineBlock {
+	for len(batch) < maxBatch {
+		select {
+		case b, ok := <-p.ordered:
+			if !ok {
+				return batch
+			}
+			<-b.ready
+			batch = append(batch, b)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (p *pipeline) commit(batch []*pipelineBlock) error {
+	blocks := make([]*parsedBlock, len(batch))
+	for i, b := range batch {
+		if err := p.c.archive(b.block); err != nil {
+			return err
+		}
+		blocks[i] = b.parsed
+	}
+	if err := p.c.commit(blocks); err != nil {
+		return err
+	}
+	p.onCommit()
+	return nil
+}
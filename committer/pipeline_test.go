@@ -0,0 +1,162 @@
+package committer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/arner/hacky-fabric/fabrictx"
+	"github.com/arner/hacky-fabric/storage"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+	_ "modernc.org/sqlite"
+)
+
+// fixtureChain returns n copies of the endorsed fixture block, numbered from 1.
+func fixtureChain(tb testing.TB, n int) []*peer.DeliverResponse_BlockAndPrivateData {
+	raw, err := os.ReadFile("../fabrictx/fixtures/endorsed.block")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	fixture := &common.Block{}
+	if err := proto.Unmarshal(raw, fixture); err != nil {
+		tb.Fatal(err)
+	}
+	filter := make([]byte, len(fixture.Data.Data))
+	fixture.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] = filter
+
+	blocks := make([]*peer.DeliverResponse_BlockAndPrivateData, n)
+	for i := range blocks {
+		b := proto.Clone(fixture).(*common.Block)
+		b.Header.Number = uint64(i + 1)
+		blocks[i] = &peer.DeliverResponse_BlockAndPrivateData{BlockAndPrivateData: &peer.BlockAndPrivateData{Block: b}}
+	}
+	return blocks
+}
+
+func newTestCommitter(tb testing.TB, opts ...Option) *Committer {
+	sqlDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	tb.Cleanup(func() { sqlDB.Close() })
+	db := storage.New("mychannel", sqlDB)
+	if err := db.Init(); err != nil {
+		tb.Fatal(err)
+	}
+	c, err := NewCommitter(context.Background(), db, "mychannel", nil, nil, nopLogger{}, opts...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(c.Stop)
+	return c
+}
+
+func TestPipeline(t *testing.T) {
+	blocks := fixtureChain(t, 50)
+	for name, opt := range map[string]Option{
+		"trust peer":       WithValidation(TrustPeer, nil),
+		"validate locally": WithValidation(ValidateLocally, nil),
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := newTestCommitter(t, opt, WithPipeline(4, 8))
+			commits := 0
+			p := newPipeline(c, c.workers, c.depth, func() { commits++ })
+			for _, b := range blocks {
+				if err := p.submit(b); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := p.close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if last, err := c.db.LastProcessedBlock(); err != nil || last != 50 {
+				t.Errorf("expected last block 50, got %d (%v)", last, err)
+			}
+			if commits == 0 || commits > 50 {
+				t.Errorf("unexpected number of commits %d", commits)
+			}
+			env := &common.Envelope{}
+			if err := proto.Unmarshal(blocks[0].BlockAndPrivateData.Block.Data.Data[0], env); err != nil {
+				t.Fatal(err)
+			}
+			chdr, err := fabrictx.ChannelHeader(env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the copies of the transaction in later blocks are duplicates
+			st, err := c.WaitForTx(context.Background(), chdr.TxId)
+			if err != nil || st.BlockNum != 1 {
+				t.Errorf("expected the transaction in block 1, got %s (%v)", st, err)
+			}
+		})
+	}
+}
+
+func TestPipelineError(t *testing.T) {
+	blocks := fixtureChain(t, 3)
+	blocks[1].BlockAndPrivateData.Block.Data.Data[0] = []byte("broken")
+	c := newTestCommitter(t, WithPipeline(2, 8), WithErrorPolicy(Halt))
+	p := newPipeline(c, c.workers, c.depth, func() {})
+	for _, b := range blocks[:2] {
+		if err := p.submit(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-p.done
+
+	// there is room in the queue, but the pipeline doesn't accept blocks after the failed commit
+	for range 20 {
+		var perr *ProcessError
+		if err := p.submit(blocks[2]); !errors.As(err, &perr) || perr.BlockNum != 2 {
+			t.Fatalf("expected the error of block 2, got %v", err)
+		}
+	}
+	if err := p.close(); err == nil {
+		t.Error("expected the error of block 2 when closing")
+	}
+	if last, err := c.db.LastProcessedBlock(); err != nil || last != 1 {
+		t.Errorf("expected last block 1, got %d (%v)", last, err)
+	}
+}
+
+// BenchmarkProcessBlock commits copies of the fixture block to an in-memory database, one by one and through
+// pipelines of different depths.
+func BenchmarkProcessBlock(b *testing.B) {
+	b.Run("serial", func(b *testing.B) {
+		c := newTestCommitter(b)
+		chain := fixtureChain(b, b.N)
+		b.ResetTimer()
+		for _, block := range chain {
+			if err := c.ProcessBlock(block); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "blocks/s")
+	})
+	for _, depth := range []int{1, 16, 64} {
+		b.Run(fmt.Sprintf("pipeline/depth=%d", depth), func(b *testing.B) {
+			c := newTestCommitter(b, WithPipeline(runtime.NumCPU(), depth))
+			chain := fixtureChain(b, b.N)
+			b.ResetTimer()
+			p := newPipeline(c, c.workers, c.depth, func() {})
+			for _, block := range chain {
+				if err := p.submit(block); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := p.close(); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "blocks/s")
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package committer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/arner/hacky-fabric/blockstore"
+	"github.com/arner/hacky-fabric/storage"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+var errStopReplay = errors.New("stop replay")
+
+// Divergence is the first block after which two states differ, and the first key in that block that differs.
+type Divergence struct {
+	BlockNum  uint64
+	Namespace string // empty if the writes of the block are the same, but the state hashes before it differ
+	Key       string
+	Reason    string
+}
+
+func (d *Divergence) String() string {
+	if d.Namespace == "" {
+		return fmt.Sprintf("block %d: %s", d.BlockNum, d.Reason)
+	}
+	return fmt.Sprintf("block %d, %s:%s: %s", d.BlockNum, d.Namespace, d.Key, d.Reason)
+}
+
+// CompareStates compares the state hashes of two databases, for instance of two committers that follow different
+// peers, up to the last block that both processed. It returns the first divergence, or nil if the states are the
+// same. Because the state hash is a running hash, the first diverging block is found with a binary search.
+func CompareStates(a, b *storage.VersionedDB) (*Divergence, error) {
+	lastA, err := a.LastProcessedBlock()
+	if err != nil {
+		return nil, err
+	}
+	lastB, err := b.LastProcessedBlock()
+	if err != nil {
+		return nil, err
+	}
+	same := func(num uint64) (bool, error) {
+		ha, err := a.GetStateHash(num)
+		if err != nil {
+			return false, err
+		}
+		hb, err := b.GetStateHash(num)
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(ha, hb), nil
+	}
+
+	// the first block in [lo, hi] with a different state hash; hi is different
+	lo, hi := uint64(1), min(lastA, lastB)
+	if hi == 0 {
+		return nil, nil
+	}
+	if ok, err := same(hi); err != nil || ok {
+		return nil, err
+	}
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		ok, err := same(mid)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	writesA, err := a.GetBlockWrites(hi)
+	if err != nil {
+		return nil, err
+	}
+	writesB, err := b.GetBlockWrites(hi)
+	if err != nil {
+		return nil, err
+	}
+	return diffWrites(hi, writesA, writesB), nil
+}
+
+// CompareWithBlockStore recomputes the state hashes from the blocks in the store, with the validation codes of the
+// peer, and compares them with the database up to the last block that both have. It returns the first divergence,
+// or nil if the database matches the blocks. If the store doesn't start at block 1, the state hash of the database
+// before the first block in the store is the starting point.
+func CompareWithBlockStore(db *storage.VersionedDB, store *blockstore.Store) (*Divergence, error) {
+	last, err := db.LastProcessedBlock()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		prev       []byte
+		divergence *Divergence
+		started    bool
+	)
+	discard := log.New(io.Discard, "", 0)
+	err = store.Replay(1, func(block *peer.DeliverResponse_BlockAndPrivateData) error {
+		txs, num, err := parseBlock(block, true, discard)
+		if err != nil {
+			return err
+		}
+		if num > last {
+			return errStopReplay
+		}
+		if !started {
+			if prev, err = db.GetStateHash(num - 1); err != nil {
+				return err
+			}
+			started = true
+		}
+		writes := validWrites(num, txs)
+		hash := storage.StateHash(prev, writes)
+		stored, err := db.GetStateHash(num)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(hash, stored) {
+			dbWrites, err := db.GetBlockWrites(num)
+			if err != nil {
+				return err
+			}
+			divergence = diffWrites(num, writes, dbWrites)
+			return errStopReplay
+		}
+		prev = hash
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopReplay) {
+		return nil, err
+	}
+	return divergence, nil
+}
+
+// diffWrites returns the first difference between the writes that the state hash covers.
+func diffWrites(num uint64, a, b []storage.WriteRecord) *Divergence {
+	a, b = storage.StateWrites(a), storage.StateWrites(b)
+	for i := range min(len(a), len(b)) {
+		wa, wb := a[i], b[i]
+		d := &Divergence{BlockNum: num, Namespace: wa.Namespace, Key: wa.Key}
+		switch {
+		case wa.Namespace != wb.Namespace || wa.Key != wb.Key:
+			if wb.Namespace < wa.Namespace || (wb.Namespace == wa.Namespace && wb.Key < wa.Key) {
+				d.Namespace, d.Key = wb.Namespace, wb.Key
+				d.Reason = "only written in the second state"
+			} else {
+				d.Reason = "only written in the first state"
+			}
+		case wa.TxNum != wb.TxNum:
+			d.Reason = fmt.Sprintf("written by transaction %d and %d", wa.TxNum, wb.TxNum)
+		case wa.IsDelete != wb.IsDelete || !bytes.Equal(wa.Value, wb.Value):
+			d.Reason = fmt.Sprintf("value %q and %q (deleted: %t and %t)", wa.Value, wb.Value, wa.IsDelete, wb.IsDelete)
+		default:
+			continue
+		}
+		return d
+	}
+	switch {
+	case len(a) > len(b):
+		return &Divergence{BlockNum: num, Namespace: a[len(b)].Namespace, Key: a[len(b)].Key, Reason: "only written in the first state"}
+	case len(b) > len(a):
+		return &Divergence{BlockNum: num, Namespace: b[len(a)].Namespace, Key: b[len(a)].Key, Reason: "only written in the second state"}
+	}
+	return &Divergence{BlockNum: num, Reason: "the writes are the same, but the state hash differs"}
+}
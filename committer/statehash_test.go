@@ -0,0 +1,103 @@
+package committer
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/arner/hacky-fabric/blockstore"
+	"github.com/arner/hacky-fabric/fabrictx"
+	"github.com/arner/hacky-fabric/storage"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestStateHash(t *testing.T) {
+	blocks := fixtureChain(t, 5)
+	for i := 1; i < len(blocks); i++ {
+		blocks[i].BlockAndPrivateData.Block.Header.PreviousHash = fabrictx.BlockHeaderHash(blocks[i-1].BlockAndPrivateData.Block.Header)
+	}
+	store, err := blockstore.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	a, b := newTestCommitter(t), newTestCommitter(t)
+	for _, block := range blocks {
+		if err := store.Append(block.BlockAndPrivateData); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.ProcessBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		// b doesn't store the writes of block 3, because its first transaction is invalid
+		if block.BlockAndPrivateData.Block.Header.Number == 3 {
+			block = &peer.DeliverResponse_BlockAndPrivateData{BlockAndPrivateData: proto.Clone(block.BlockAndPrivateData).(*peer.BlockAndPrivateData)}
+			block.BlockAndPrivateData.Block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER][0] = byte(peer.TxValidationCode_MVCC_READ_CONFLICT)
+		}
+		if err := b.ProcessBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hash, err := a.db.GetStateHash(5)
+	if err != nil || len(hash) == 0 {
+		t.Fatalf("expected a state hash for block 5, got %x (%v)", hash, err)
+	}
+	if d, err := CompareStates(a.db, a.db); err != nil || d != nil {
+		t.Errorf("expected the same state, got %v (%v)", d, err)
+	}
+	if d, err := CompareWithBlockStore(a.db, store); err != nil || d != nil {
+		t.Errorf("expected the state of the blocks, got %v (%v)", d, err)
+	}
+
+	for name, compare := range map[string]func() (*Divergence, error){
+		"databases":   func() (*Divergence, error) { return CompareStates(a.db, b.db) },
+		"block store": func() (*Divergence, error) { return CompareWithBlockStore(b.db, store) },
+	} {
+		d, err := compare()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d == nil || d.BlockNum != 3 || d.Namespace == "" || d.Key == "" {
+			t.Errorf("%s: expected a divergence at a key in block 3, got %v", name, d)
+		}
+		if d != nil && !strings.Contains(d.Reason, "only written in the first state") {
+			t.Errorf("%s: unexpected reason %q", name, d.Reason)
+		}
+	}
+}
+
+func TestGenesisStateHash(t *testing.T) {
+	raw, err := os.ReadFile("../fabrictx/fixtures/genesis.block")
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := &common.Block{}
+	if err := proto.Unmarshal(raw, genesis); err != nil {
+		t.Fatal(err)
+	}
+	// the fixture is a config block, but block 1 of its channel
+	genesis.Header.Number = 0
+
+	c := newTestCommitter(t)
+	blocks := append([]*peer.DeliverResponse_BlockAndPrivateData{
+		{BlockAndPrivateData: &peer.BlockAndPrivateData{Block: genesis}},
+	}, fixtureChain(t, 2)...)
+	for _, block := range blocks {
+		if err := c.ProcessBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if last, err := c.db.LastProcessedBlock(); err != nil || last != 2 {
+		t.Errorf("expected last block 2, got %d (%v)", last, err)
+	}
+	expected := storage.StateHash(nil, nil)
+	if hash, err := c.db.GetStateHash(0); err != nil || !bytes.Equal(hash, expected) {
+		t.Errorf("expected the state hash of an empty state for the genesis block, got %x (%v)", hash, err)
+	}
+}
@@ -0,0 +1,299 @@
+package committer
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/arner/hacky-fabric/fabrictx"
+	"github.com/arner/hacky-fabric/storage"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+// ValidationMode determines which validation codes decide whether the writes of a transaction are stored.
+type ValidationMode int
+
+const (
+	// TrustPeer stores the writes of transactions that the peer flagged VALID in the TRANSACTIONS_FILTER (default).
+	TrustPeer ValidationMode = iota
+	// CompareWithPeer validates the read sets locally and reports every disagreement with the peer,
+	// but stores the writes based on the peer's validation codes.
+	CompareWithPeer
+	// ValidateLocally ignores the TRANSACTIONS_FILTER and only stores the writes of transactions that pass
+	// local MVCC validation. This allows processing blocks that were not validated by a peer.
+	ValidateLocally
+)
+
+// Mismatch describes a transaction for which the local validation differs from the peer's.
+type Mismatch struct {
+	BlockNum uint64
+	TxNum    uint64
+	TxID     string
+	Peer     peer.TxValidationCode
+	Local    peer.TxValidationCode
+	Reason   string
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%d:%d (%s) peer: %s, local: %s %s", m.BlockNum, m.TxNum, m.TxID, m.Peer, m.Local, m.Reason)
+}
+
+// Option configures optional behavior of the Committer.
+type Option func(*Committer)
+
+// WithValidation enables local MVCC validation. onMismatch is called for every transaction where the local
+// validation code is different from the one assigned by the peer. If it is nil, mismatches are logged.
+func WithValidation(mode ValidationMode, onMismatch func(Mismatch)) Option {
+	return func(c *Committer) {
+		c.validation = mode
+		c.onMismatch = onMismatch
+	}
+}
+
+// validate re-validates the transactions of a block against the world state as of the previous block,
+// and sets the final validation codes depending on the validation mode.
+func (c *Committer) validate(blockNum uint64, txs []*blockTx) error {
+	v := newMVCCValidator(c.db, blockNum)
+	for _, tx := range txs {
+		local := tx.code
+		reason := ""
+		if revalidate(tx.code) {
+			var err error
+			local, reason, err = v.validate(tx)
+			if err != nil {
+				return fmt.Errorf("validate %d:%d: %w", blockNum, tx.num, err)
+			}
+		}
+		if c.validation == CompareWithPeer && local != tx.code {
+			m := Mismatch{BlockNum: blockNum, TxNum: tx.num, TxID: tx.id, Peer: tx.code, Local: local, Reason: reason}
+			if c.onMismatch != nil {
+				c.onMismatch(m)
+			} else {
+				c.log.Printf("validation mismatch: %s", m)
+			}
+		}
+		if c.validation == ValidateLocally {
+			tx.code = local
+		}
+		if local == peer.TxValidationCode_VALID {
+			v.apply(tx.num, tx.rwsets)
+		}
+	}
+	return nil
+}
+
+// ValidateBlock runs MVCC validation on the transactions of a block that has not been committed by a peer yet,
+// against the state as of the previous block. Only transactions that are VALID in the TRANSACTIONS_FILTER are
+// validated, so that the filter can carry the outcome of earlier checks (signatures, endorsement policies).
+// It returns the final validation codes, which are not set on the block.
+func ValidateBlock(state storage.ReadStore, block *common.Block, log Logger) ([]peer.TxValidationCode, error) {
+	txs, num, err := parseBlock(&peer.DeliverResponse_BlockAndPrivateData{
+		BlockAndPrivateData: &peer.BlockAndPrivateData{Block: block},
+	}, true, log)
+	if err != nil {
+		return nil, err
+	}
+	v := newMVCCValidator(state, num)
+	codes := make([]peer.TxValidationCode, len(txs))
+	for i, tx := range txs {
+		if tx.code == peer.TxValidationCode_VALID {
+			if tx.code, _, err = v.validate(tx); err != nil {
+				return nil, fmt.Errorf("validate %d:%d: %w", num, tx.num, err)
+			}
+		}
+		if tx.code == peer.TxValidationCode_VALID {
+			v.apply(tx.num, tx.rwsets)
+		}
+		codes[i] = tx.code
+	}
+	return codes, nil
+}
+
+// revalidate returns whether the outcome of MVCC validation can change the validation code.
+// Transactions that failed for other reasons (signatures, endorsement policy) stay invalid.
+func revalidate(code peer.TxValidationCode) bool {
+	switch code {
+	case peer.TxValidationCode_VALID, peer.TxValidationCode_MVCC_READ_CONFLICT, peer.TxValidationCode_PHANTOM_READ_CONFLICT:
+		return true
+	}
+	return false
+}
+
+type nsKey struct {
+	namespace string
+	key       string
+}
+
+// mvccValidator follows Fabric's rules: every read must match the committed version of the key (nil if it
+// does not exist or is deleted), and a key that was written by an earlier valid transaction in the same block
+// can't be read. Range queries must return the same results over the committed state with the writes of the
+// earlier valid transactions in the block applied (no phantom reads).
+type mvccValidator struct {
+	state    storage.ReadStore
+	blockNum uint64
+	height   uint64
+	genesis  bool
+	updates  map[nsKey]*kvrwset.Version // nil for deletes
+}
+
+func newMVCCValidator(state storage.ReadStore, blockNum uint64) *mvccValidator {
+	v := &mvccValidator{
+		state:    state,
+		blockNum: blockNum,
+		genesis:  blockNum == 0,
+		updates:  make(map[nsKey]*kvrwset.Version),
+	}
+	if blockNum > 0 {
+		v.height = blockNum - 1
+	}
+	return v
+}
+
+func (v *mvccValidator) validate(tx *blockTx) (peer.TxValidationCode, string, error) {
+	if tx.malformed {
+		return peer.TxValidationCode_BAD_PAYLOAD, "could not parse read/write set", nil
+	}
+	for _, ns := range tx.rwsets {
+		for _, r := range ns.Rwset.Reads {
+			reason, err := v.checkRead(ns.Namespace, r.Key, r.Version)
+			if err != nil {
+				return 0, "", err
+			}
+			if reason != "" {
+				return peer.TxValidationCode_MVCC_READ_CONFLICT, reason, nil
+			}
+		}
+		for _, rq := range ns.Rwset.RangeQueriesInfo {
+			same, err := v.validateRange(ns.Namespace, rq)
+			if err != nil {
+				return 0, "", err
+			}
+			if !same {
+				return peer.TxValidationCode_PHANTOM_READ_CONFLICT, fmt.Sprintf("%s:[%q, %q] returns different results", ns.Namespace, rq.StartKey, rq.EndKey), nil
+			}
+		}
+		// private data reads are validated against the hashes, which every peer has
+		for _, coll := range ns.Collections {
+			hashedNs := storage.HashedNamespace(ns.Namespace, coll.Collection)
+			for _, r := range coll.Rwset.HashedReads {
+				reason, err := v.checkRead(hashedNs, storage.HashedKey(r.KeyHash), r.Version)
+				if err != nil {
+					return 0, "", err
+				}
+				if reason != "" {
+					return peer.TxValidationCode_MVCC_READ_CONFLICT, reason, nil
+				}
+			}
+		}
+	}
+	return peer.TxValidationCode_VALID, "", nil
+}
+
+// checkRead returns the reason why a read is invalid, or an empty string if it is valid.
+func (v *mvccValidator) checkRead(namespace, key string, version *kvrwset.Version) (string, error) {
+	if _, ok := v.updates[nsKey{namespace, key}]; ok {
+		return fmt.Sprintf("%s:%s was updated earlier in the block", namespace, key), nil
+	}
+	committed, err := v.committedVersion(namespace, key)
+	if err != nil {
+		return "", err
+	}
+	if !sameVersion(version, committed) {
+		return fmt.Sprintf("%s:%s read version %s, committed %s", namespace, key, versionString(version), versionString(committed)), nil
+	}
+	return "", nil
+}
+
+// committedVersion returns the version of a key at the end of the previous block, or nil if it doesn't exist.
+func (v *mvccValidator) committedVersion(namespace, key string) (*kvrwset.Version, error) {
+	if v.genesis {
+		return nil, nil
+	}
+	rec, err := v.state.Get(namespace, key, v.height)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil || rec.IsDelete {
+		return nil, nil
+	}
+	return &kvrwset.Version{BlockNum: rec.BlockNum, TxNum: rec.TxNum}, nil
+}
+
+// validateRange executes a range query again to check for phantom reads, over the committed state merged with
+// the writes of the earlier transactions in the block. Like on a peer, a write to a key in the range changes the
+// results (the key gets the version of this block), but a delete of a key that doesn't exist doesn't.
+func (v *mvccValidator) validateRange(namespace string, rq *kvrwset.RangeQueryInfo) (bool, error) {
+	endKey := rq.EndKey
+	if !rq.ItrExhausted {
+		// the iterator stopped at the end key, so it is part of the results.
+		endKey += "\x00"
+	}
+
+	var records []storage.WriteRecord
+	if !v.genesis {
+		var err error
+		records, err = v.state.GetRange(namespace, rq.StartKey, endKey, v.height)
+		if err != nil {
+			return false, err
+		}
+	}
+	versions := make(map[string]*kvrwset.Version, len(records))
+	for _, rec := range records {
+		versions[rec.Key] = &kvrwset.Version{BlockNum: rec.BlockNum, TxNum: rec.TxNum}
+	}
+	for k, version := range v.updates {
+		if k.namespace != namespace || k.key < rq.StartKey || (endKey != "" && k.key >= endKey) {
+			continue
+		}
+		if version == nil {
+			delete(versions, k.key)
+		} else {
+			versions[k.key] = version
+		}
+	}
+	keys := slices.Sorted(maps.Keys(versions))
+	results := make([]*kvrwset.KVRead, len(keys))
+	for i, key := range keys {
+		results[i] = &kvrwset.KVRead{Key: key, Version: versions[key]}
+	}
+	return fabrictx.SameRangeQueryResults(rq, results)
+}
+
+// apply registers the writes of a valid transaction, so that later transactions in the block that read them are
+// invalidated and range queries see them.
+func (v *mvccValidator) apply(txNum uint64, rwsets []fabrictx.NsRwset) {
+	version := func(isDelete bool) *kvrwset.Version {
+		if isDelete {
+			return nil
+		}
+		return &kvrwset.Version{BlockNum: v.blockNum, TxNum: txNum}
+	}
+	for _, ns := range rwsets {
+		for _, w := range ns.Rwset.Writes {
+			v.updates[nsKey{ns.Namespace, w.Key}] = version(w.IsDelete)
+		}
+		for _, coll := range ns.Collections {
+			hashedNs := storage.HashedNamespace(ns.Namespace, coll.Collection)
+			for _, w := range coll.Rwset.HashedWrites {
+				v.updates[nsKey{hashedNs, storage.HashedKey(w.KeyHash)}] = version(w.IsDelete)
+			}
+		}
+	}
+}
+
+func sameVersion(a, b *kvrwset.Version) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.BlockNum == b.BlockNum && a.TxNum == b.TxNum
+}
+
+func versionString(v *kvrwset.Version) string {
+	if v == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("%d:%d", v.BlockNum, v.TxNum)
+}
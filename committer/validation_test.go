@@ -0,0 +1,243 @@
+package committer
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/arner/hacky-fabric/fabrictx"
+	"github.com/arner/hacky-fabric/storage"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(format string, v ...any) {}
+
+type mockState map[string]*storage.WriteRecord
+
+func (m mockState) Get(ns, key string, block uint64) (*storage.WriteRecord, error) {
+	return m[ns+":"+key], nil
+}
+
+func (m mockState) GetRange(ns, start, end string, block uint64) ([]storage.WriteRecord, error) {
+	var out []storage.WriteRecord
+	for k, w := range m {
+		if strings.HasPrefix(k, ns+":") && w.Key >= start && (end == "" || w.Key < end) && !w.IsDelete {
+			out = append(out, *w)
+		}
+	}
+	slices.SortFunc(out, func(a, b storage.WriteRecord) int { return strings.Compare(a.Key, b.Key) })
+	return out, nil
+}
+
+func read(key string, version *kvrwset.Version) *kvrwset.KVRead {
+	return &kvrwset.KVRead{Key: key, Version: version}
+}
+
+func tx(num uint64, reads []*kvrwset.KVRead, writes []*kvrwset.KVWrite) *blockTx {
+	return &blockTx{
+		num:  num,
+		code: peer.TxValidationCode_VALID,
+		rwsets: []fabrictx.NsRwset{
+			{Namespace: "ns", Rwset: &kvrwset.KVRWSet{Reads: reads, Writes: writes}},
+		},
+	}
+}
+
+func TestMVCCValidator(t *testing.T) {
+	state := mockState{
+		"ns:existing": {Key: "existing", BlockNum: 3, TxNum: 1, Value: []byte("v")},
+		"ns:deleted":  {Key: "deleted", BlockNum: 4, TxNum: 0, IsDelete: true},
+	}
+	tests := []struct {
+		name     string
+		tx       *blockTx
+		expected peer.TxValidationCode
+	}{
+		{
+			name:     "read current version",
+			tx:       tx(0, []*kvrwset.KVRead{read("existing", &kvrwset.Version{BlockNum: 3, TxNum: 1})}, nil),
+			expected: peer.TxValidationCode_VALID,
+		},
+		{
+			name:     "read old version",
+			tx:       tx(0, []*kvrwset.KVRead{read("existing", &kvrwset.Version{BlockNum: 2, TxNum: 0})}, nil),
+			expected: peer.TxValidationCode_MVCC_READ_CONFLICT,
+		},
+		{
+			name:     "read missing key",
+			tx:       tx(0, []*kvrwset.KVRead{read("missing", nil)}, nil),
+			expected: peer.TxValidationCode_VALID,
+		},
+		{
+			name:     "read missing key with a version",
+			tx:       tx(0, []*kvrwset.KVRead{read("missing", &kvrwset.Version{BlockNum: 0, TxNum: 0})}, nil),
+			expected: peer.TxValidationCode_MVCC_READ_CONFLICT,
+		},
+		{
+			name:     "read deleted key",
+			tx:       tx(0, []*kvrwset.KVRead{read("deleted", nil)}, nil),
+			expected: peer.TxValidationCode_VALID,
+		},
+		{
+			name:     "existing key read as missing",
+			tx:       tx(0, []*kvrwset.KVRead{read("existing", nil)}, nil),
+			expected: peer.TxValidationCode_MVCC_READ_CONFLICT,
+		},
+		{
+			name:     "malformed transaction",
+			tx:       &blockTx{malformed: true},
+			expected: peer.TxValidationCode_BAD_PAYLOAD,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := newMVCCValidator(state, 5)
+			code, reason, err := v.validate(tc.tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != tc.expected {
+				t.Errorf("expected %s, got %s (%s)", tc.expected, code, reason)
+			}
+		})
+	}
+}
+
+func TestMVCCValidatorIntraBlock(t *testing.T) {
+	state := mockState{
+		"ns:a": {Key: "a", BlockNum: 1, TxNum: 0, Value: []byte("v")},
+	}
+	v := newMVCCValidator(state, 2)
+	txs := []*blockTx{
+		tx(0, []*kvrwset.KVRead{read("a", &kvrwset.Version{BlockNum: 1, TxNum: 0})}, []*kvrwset.KVWrite{{Key: "a", Value: []byte("new")}}),
+		tx(1, []*kvrwset.KVRead{read("a", &kvrwset.Version{BlockNum: 1, TxNum: 0})}, []*kvrwset.KVWrite{{Key: "b", Value: []byte("new")}}),
+		tx(2, []*kvrwset.KVRead{read("b", nil)}, nil),
+	}
+	expected := []peer.TxValidationCode{
+		peer.TxValidationCode_VALID,
+		peer.TxValidationCode_MVCC_READ_CONFLICT,
+		peer.TxValidationCode_VALID, // b was not written because tx 1 is invalid
+	}
+	for i, btx := range txs {
+		code, _, err := v.validate(btx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected[i] {
+			t.Errorf("tx %d: expected %s, got %s", i, expected[i], code)
+		}
+		if code == peer.TxValidationCode_VALID {
+			v.apply(btx.num, btx.rwsets)
+		}
+	}
+}
+
+func rangeTx(start, end string, exhausted bool, reads ...*kvrwset.KVRead) *blockTx {
+	info, err := fabrictx.NewRangeQueryInfo(start, end, exhausted, reads, fabrictx.DefaultMaxDegree)
+	if err != nil {
+		panic(err)
+	}
+	t := tx(0, nil, nil)
+	t.rwsets[0].Rwset.RangeQueriesInfo = []*kvrwset.RangeQueryInfo{info}
+	return t
+}
+
+func TestMVCCValidatorRangeQueries(t *testing.T) {
+	state := mockState{
+		"ns:a": {Key: "a", BlockNum: 1, TxNum: 0, Value: []byte("v")},
+		"ns:b": {Key: "b", BlockNum: 2, TxNum: 0, Value: []byte("v")},
+		"ns:c": {Key: "c", BlockNum: 2, TxNum: 1, IsDelete: true},
+		"ns:d": {Key: "d", BlockNum: 3, TxNum: 0, Value: []byte("v")},
+	}
+	a := read("a", &kvrwset.Version{BlockNum: 1, TxNum: 0})
+	b := read("b", &kvrwset.Version{BlockNum: 2, TxNum: 0})
+	d := read("d", &kvrwset.Version{BlockNum: 3, TxNum: 0})
+
+	tests := []struct {
+		name     string
+		tx       *blockTx
+		expected peer.TxValidationCode
+	}{
+		{"same results", rangeTx("a", "d", true, a, b), peer.TxValidationCode_VALID},
+		{"unbounded", rangeTx("a", "", true, a, b, d), peer.TxValidationCode_VALID},
+		{"phantom key", rangeTx("a", "e", true, a, b), peer.TxValidationCode_PHANTOM_READ_CONFLICT},
+		{"missing key", rangeTx("a", "d", true, a), peer.TxValidationCode_PHANTOM_READ_CONFLICT},
+		{"stopped early", rangeTx("a", "b", false, a, b), peer.TxValidationCode_VALID},
+		{"updated version", rangeTx("a", "d", true, a, read("b", &kvrwset.Version{BlockNum: 1, TxNum: 0})), peer.TxValidationCode_PHANTOM_READ_CONFLICT},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := newMVCCValidator(state, 5)
+			code, reason, err := v.validate(tc.tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != tc.expected {
+				t.Errorf("expected %s, got %s (%s)", tc.expected, code, reason)
+			}
+		})
+	}
+
+	// writes earlier in the block, applied to the results of the range query
+	for _, tc := range []struct {
+		name     string
+		write    *kvrwset.KVWrite
+		expected peer.TxValidationCode
+	}{
+		{"new key in range", &kvrwset.KVWrite{Key: "bb", Value: []byte("v")}, peer.TxValidationCode_PHANTOM_READ_CONFLICT},
+		{"updated key in range", &kvrwset.KVWrite{Key: "b", Value: []byte("new")}, peer.TxValidationCode_PHANTOM_READ_CONFLICT},
+		{"deleted key in range", &kvrwset.KVWrite{Key: "b", IsDelete: true}, peer.TxValidationCode_PHANTOM_READ_CONFLICT},
+		{"deleted missing key in range", &kvrwset.KVWrite{Key: "bb", IsDelete: true}, peer.TxValidationCode_VALID},
+		{"deleted key that was deleted before", &kvrwset.KVWrite{Key: "c", IsDelete: true}, peer.TxValidationCode_VALID},
+		{"key after the range", &kvrwset.KVWrite{Key: "e", Value: []byte("v")}, peer.TxValidationCode_VALID},
+	} {
+		t.Run("earlier write to "+tc.name, func(t *testing.T) {
+			v := newMVCCValidator(state, 5)
+			v.apply(0, tx(0, nil, []*kvrwset.KVWrite{tc.write}).rwsets)
+			code, reason, err := v.validate(rangeTx("a", "d", true, a, b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != tc.expected {
+				t.Errorf("expected %s, got %s (%s)", tc.expected, code, reason)
+			}
+		})
+	}
+}
+
+func TestParseBlockWithoutFilter(t *testing.T) {
+	b, err := os.ReadFile("../fabrictx/fixtures/endorsed.block")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &common.Block{}
+	if err := proto.Unmarshal(b, block); err != nil {
+		t.Fatal(err)
+	}
+	// blocks from an orderer don't have a transactions filter yet
+	block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] = nil
+
+	txs, num, err := parseBlock(&peer.DeliverResponse_BlockAndPrivateData{
+		BlockAndPrivateData: &peer.BlockAndPrivateData{Block: block},
+	}, false, nopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if num != block.Header.Number {
+		t.Errorf("expected block %d, got %d", block.Header.Number, num)
+	}
+	if len(txs) != len(block.Data.Data) {
+		t.Fatalf("expected %d transactions, got %d", len(block.Data.Data), len(txs))
+	}
+	if txs[0].id == "" || len(txs[0].rwsets) == 0 {
+		t.Errorf("expected transaction id and read/write sets to be parsed: %+v", txs[0])
+	}
+}
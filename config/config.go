@@ -0,0 +1,416 @@
+// Package config gives access to the contents of a channel configuration: organizations and their MSPs,
+// orderer endpoints and consenters, anchor peers, capabilities and policies.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"github.com/hyperledger/fabric-protos-go-apiv2/orderer"
+	"github.com/hyperledger/fabric-protos-go-apiv2/orderer/etcdraft"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+// Names of the groups in the config tree.
+const (
+	ChannelGroup     = "Channel"
+	OrdererGroup     = "Orderer"
+	ApplicationGroup = "Application"
+)
+
+// ChannelConfig is the decoded configuration of a channel.
+type ChannelConfig struct {
+	ChannelID        string
+	Sequence         uint64
+	HashingAlgorithm string
+	Capabilities     []string
+	// OrdererAddresses is the deprecated global list of orderer endpoints, see Orderer.Endpoints.
+	OrdererAddresses []string
+	Orderer          *Orderer
+	Application      *Application
+	Policies         map[string]Policy
+}
+
+type Orderer struct {
+	ConsensusType string
+	State         string
+	BatchSize     BatchSize
+	BatchTimeout  string
+	Consenters    []Consenter
+	Organizations map[string]Organization
+	Capabilities  []string
+	Policies      map[string]Policy
+}
+
+type BatchSize struct {
+	MaxMessageCount   uint32
+	AbsoluteMaxBytes  uint32
+	PreferredMaxBytes uint32
+}
+
+// Consenter is a member of the consensus cluster. ID, MSPID and Identity are only set for BFT.
+type Consenter struct {
+	ID            uint32
+	Host          string
+	Port          uint32
+	MSPID         string
+	Identity      []byte
+	ClientTLSCert []byte
+	ServerTLSCert []byte
+}
+
+func (c Consenter) Address() string {
+	return c.Host + ":" + strconv.FormatUint(uint64(c.Port), 10)
+}
+
+type Application struct {
+	Organizations map[string]Organization
+	Capabilities  []string
+	ACLs          map[string]string
+	Policies      map[string]Policy
+}
+
+// Organization is a member of the orderer or application group. Endpoints are only set for orderer
+// organizations and AnchorPeers only for application organizations.
+type Organization struct {
+	Name        string
+	MSP         MSP
+	Endpoints   []string
+	AnchorPeers []string
+	Policies    map[string]Policy
+}
+
+// MSP is the definition of an x509 based membership service provider. Certificates are PEM encoded.
+type MSP struct {
+	ID                   string
+	RootCerts            [][]byte
+	IntermediateCerts    [][]byte
+	Admins               [][]byte
+	RevocationList       [][]byte
+	TLSRootCerts         [][]byte
+	TLSIntermediateCerts [][]byte
+	OUs                  []OU
+	NodeOUs              *NodeOUs
+}
+
+type OU struct {
+	Identifier  string
+	Certificate []byte
+}
+
+// NodeOUs determine the role of an identity (client, peer, admin, orderer) based on the OU in its certificate.
+type NodeOUs struct {
+	Enabled   bool
+	ClientOU  *OU
+	PeerOU    *OU
+	AdminOU   *OU
+	OrdererOU *OU
+}
+
+// Policy is a policy in the config tree. Signature is set for SIGNATURE policies and ImplicitMeta
+// (for instance "MAJORITY Admins") for IMPLICIT_META policies.
+type Policy struct {
+	Type         string
+	ModPolicy    string
+	Signature    *common.SignaturePolicyEnvelope
+	ImplicitMeta string
+}
+
+// Organizations returns all organizations of the channel, both orderer and application, by name.
+func (c *ChannelConfig) Organizations() map[string]Organization {
+	out := map[string]Organization{}
+	if c.Orderer != nil {
+		maps.Copy(out, c.Orderer.Organizations)
+	}
+	if c.Application != nil {
+		maps.Copy(out, c.Application.Organizations)
+	}
+	return out
+}
+
+// MSPs returns the MSP definitions of all organizations by MSP ID.
+func (c *ChannelConfig) MSPs() map[string]MSP {
+	out := map[string]MSP{}
+	for _, org := range c.Organizations() {
+		out[org.MSP.ID] = org.MSP
+	}
+	return out
+}
+
+// OrdererEndpoints returns the endpoints of the orderer organizations, or the global orderer addresses
+// if no organization has endpoints.
+func (c *ChannelConfig) OrdererEndpoints() []string {
+	var out []string
+	if c.Orderer != nil {
+		for _, name := range slices.Sorted(maps.Keys(c.Orderer.Organizations)) {
+			out = append(out, c.Orderer.Organizations[name].Endpoints...)
+		}
+	}
+	if len(out) == 0 {
+		return c.OrdererAddresses
+	}
+	return out
+}
+
+// Verifier returns a verifier that validates identities against the MSP definition.
+func (m MSP) Verifier() (*fabrictx.MSPVerifier, error) {
+	conf := &msp.FabricMSPConfig{
+		Name:                 m.ID,
+		RootCerts:            m.RootCerts,
+		IntermediateCerts:    m.IntermediateCerts,
+		Admins:               m.Admins,
+		RevocationList:       m.RevocationList,
+		TlsRootCerts:         m.TLSRootCerts,
+		TlsIntermediateCerts: m.TLSIntermediateCerts,
+	}
+	for _, ou := range m.OUs {
+		conf.OrganizationalUnitIdentifiers = append(conf.OrganizationalUnitIdentifiers, fabricOU(&ou))
+	}
+	if n := m.NodeOUs; n != nil {
+		conf.FabricNodeOus = &msp.FabricNodeOUs{
+			Enable:              n.Enabled,
+			ClientOuIdentifier:  fabricOU(n.ClientOU),
+			PeerOuIdentifier:    fabricOU(n.PeerOU),
+			AdminOuIdentifier:   fabricOU(n.AdminOU),
+			OrdererOuIdentifier: fabricOU(n.OrdererOU),
+		}
+	}
+	return fabrictx.NewMSPVerifier(conf)
+}
+
+// MSPVerifiers returns verifiers for the MSPs of all organizations by MSP ID, for instance for policy.MSPMatcher.
+func (c *ChannelConfig) MSPVerifiers() (map[string]*fabrictx.MSPVerifier, error) {
+	out := map[string]*fabrictx.MSPVerifier{}
+	for id, m := range c.MSPs() {
+		v, err := m.Verifier()
+		if err != nil {
+			return nil, err
+		}
+		out[id] = v
+	}
+	return out, nil
+}
+
+// FromBlock returns the configuration in a config block.
+func FromBlock(b *common.Block) (*ChannelConfig, error) {
+	parsed, err := fabrictx.BlockToStruct(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(parsed.Data) != 1 || parsed.Data[0].Payload.Config == nil {
+		return nil, errors.New("not a config block")
+	}
+	conf, err := fromParsed(parsed.Data[0].Payload.Config.Config)
+	if err != nil {
+		return nil, err
+	}
+	conf.ChannelID = parsed.Data[0].Payload.Header.ChannelHeader.ChannelId
+	return conf, nil
+}
+
+// FromConfig returns the decoded form of a config. The channel ID is not part of it.
+func FromConfig(c *common.Config) (*ChannelConfig, error) {
+	group, err := fabrictx.ParseConfigGroup(c.ChannelGroup)
+	if err != nil {
+		return nil, err
+	}
+	return fromParsed(fabrictx.Config{Sequence: c.Sequence, ChannelGroup: group})
+}
+
+func fromParsed(c fabrictx.Config) (*ChannelConfig, error) {
+	ch := c.ChannelGroup
+	out := &ChannelConfig{
+		Sequence:     c.Sequence,
+		Capabilities: capabilities(ch),
+		Policies:     policies(ch),
+	}
+	if v, ok := value[*common.HashingAlgorithm](ch, "HashingAlgorithm"); ok {
+		out.HashingAlgorithm = v.Name
+	}
+	if v, ok := value[*common.OrdererAddresses](ch, "OrdererAddresses"); ok {
+		out.OrdererAddresses = v.Addresses
+	}
+
+	if g, ok := ch.Groups[OrdererGroup]; ok {
+		o, err := ordererConfig(g)
+		if err != nil {
+			return nil, fmt.Errorf("orderer: %w", err)
+		}
+		out.Orderer = o
+	}
+	if g, ok := ch.Groups[ApplicationGroup]; ok {
+		a, err := applicationConfig(g)
+		if err != nil {
+			return nil, fmt.Errorf("application: %w", err)
+		}
+		out.Application = a
+	}
+	return out, nil
+}
+
+func ordererConfig(g fabrictx.ConfigGroup) (*Orderer, error) {
+	orgs, err := organizations(g)
+	if err != nil {
+		return nil, err
+	}
+	out := &Orderer{
+		Organizations: orgs,
+		Capabilities:  capabilities(g),
+		Policies:      policies(g),
+	}
+	if v, ok := value[*orderer.BatchSize](g, "BatchSize"); ok {
+		out.BatchSize = BatchSize{
+			MaxMessageCount:   v.MaxMessageCount,
+			AbsoluteMaxBytes:  v.AbsoluteMaxBytes,
+			PreferredMaxBytes: v.PreferredMaxBytes,
+		}
+	}
+	if v, ok := value[*orderer.BatchTimeout](g, "BatchTimeout"); ok {
+		out.BatchTimeout = v.Timeout
+	}
+	if v, ok := value[fabrictx.ConsensusTypeValue](g, "ConsensusType"); ok {
+		out.ConsensusType = v.Type
+		out.State = v.State
+		if md, ok := v.Metadata.(*etcdraft.ConfigMetadata); ok {
+			for _, c := range md.Consenters {
+				out.Consenters = append(out.Consenters, Consenter{
+					Host:          c.Host,
+					Port:          c.Port,
+					ClientTLSCert: c.ClientTlsCert,
+					ServerTLSCert: c.ServerTlsCert,
+				})
+			}
+		}
+	}
+	// BFT consenters are a separate value
+	if v, ok := value[*common.Orderers](g, "Orderers"); ok {
+		for _, c := range v.ConsenterMapping {
+			out.Consenters = append(out.Consenters, Consenter{
+				ID:            c.Id,
+				Host:          c.Host,
+				Port:          c.Port,
+				MSPID:         c.MspId,
+				Identity:      c.Identity,
+				ClientTLSCert: c.ClientTlsCert,
+				ServerTLSCert: c.ServerTlsCert,
+			})
+		}
+	}
+	return out, nil
+}
+
+func applicationConfig(g fabrictx.ConfigGroup) (*Application, error) {
+	orgs, err := organizations(g)
+	if err != nil {
+		return nil, err
+	}
+	out := &Application{
+		Organizations: orgs,
+		Capabilities:  capabilities(g),
+		ACLs:          map[string]string{},
+		Policies:      policies(g),
+	}
+	if v, ok := value[*peer.ACLs](g, "ACLs"); ok {
+		for name, res := range v.Acls {
+			out.ACLs[name] = res.PolicyRef
+		}
+	}
+	return out, nil
+}
+
+func organizations(g fabrictx.ConfigGroup) (map[string]Organization, error) {
+	out := map[string]Organization{}
+	for name, og := range g.Groups {
+		org := Organization{Name: name, Policies: policies(og)}
+		v, ok := value[fabrictx.MSPValue](og, "MSP")
+		if !ok || v.Config == nil {
+			return nil, fmt.Errorf("organization %s: no x509 MSP definition", name)
+		}
+		org.MSP = mspConfig(v.Config)
+		if v, ok := value[*common.OrdererAddresses](og, "Endpoints"); ok {
+			org.Endpoints = v.Addresses
+		}
+		if v, ok := value[*peer.AnchorPeers](og, "AnchorPeers"); ok {
+			for _, ap := range v.AnchorPeers {
+				org.AnchorPeers = append(org.AnchorPeers, ap.Host+":"+strconv.Itoa(int(ap.Port)))
+			}
+		}
+		out[name] = org
+	}
+	return out, nil
+}
+
+func mspConfig(c *msp.FabricMSPConfig) MSP {
+	out := MSP{
+		ID:                   c.Name,
+		RootCerts:            c.RootCerts,
+		IntermediateCerts:    c.IntermediateCerts,
+		Admins:               c.Admins,
+		RevocationList:       c.RevocationList,
+		TLSRootCerts:         c.TlsRootCerts,
+		TLSIntermediateCerts: c.TlsIntermediateCerts,
+	}
+	for _, ou := range c.OrganizationalUnitIdentifiers {
+		out.OUs = append(out.OUs, *ouIdentifier(ou))
+	}
+	if n := c.FabricNodeOus; n != nil {
+		out.NodeOUs = &NodeOUs{
+			Enabled:   n.Enable,
+			ClientOU:  ouIdentifier(n.ClientOuIdentifier),
+			PeerOU:    ouIdentifier(n.PeerOuIdentifier),
+			AdminOU:   ouIdentifier(n.AdminOuIdentifier),
+			OrdererOU: ouIdentifier(n.OrdererOuIdentifier),
+		}
+	}
+	return out
+}
+
+func ouIdentifier(ou *msp.FabricOUIdentifier) *OU {
+	if ou == nil {
+		return nil
+	}
+	return &OU{Identifier: ou.OrganizationalUnitIdentifier, Certificate: ou.Certificate}
+}
+
+func fabricOU(ou *OU) *msp.FabricOUIdentifier {
+	if ou == nil {
+		return nil
+	}
+	return &msp.FabricOUIdentifier{OrganizationalUnitIdentifier: ou.Identifier, Certificate: ou.Certificate}
+}
+
+func capabilities(g fabrictx.ConfigGroup) []string {
+	v, ok := value[*common.Capabilities](g, "Capabilities")
+	if !ok {
+		return nil
+	}
+	return slices.Sorted(maps.Keys(v.Capabilities))
+}
+
+func policies(g fabrictx.ConfigGroup) map[string]Policy {
+	out := map[string]Policy{}
+	for name, p := range g.Policies {
+		pol := Policy{Type: p.Type, ModPolicy: p.ModPolicy}
+		switch v := p.Value.(type) {
+		case *common.SignaturePolicyEnvelope:
+			pol.Signature = v
+		case *common.ImplicitMetaPolicy:
+			pol.ImplicitMeta = v.Rule.String() + " " + v.SubPolicy
+		}
+		out[name] = pol
+	}
+	return out
+}
+
+// value returns the decoded config value of the key if it has the expected type.
+func value[T any](g fabrictx.ConfigGroup, key string) (T, bool) {
+	v, ok := g.Values[key].Value.(T)
+	return v, ok
+}
@@ -0,0 +1,73 @@
+package config_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/arner/hacky-fabric/config"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFromBlock(t *testing.T) {
+	b, err := os.ReadFile("../fabrictx/fixtures/channel.block")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &common.Block{}
+	if err = proto.Unmarshal(b, block); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := config.FromBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.ChannelID == "" || conf.HashingAlgorithm != "SHA256" {
+		t.Errorf("unexpected channel config %+v", conf)
+	}
+	if conf.Orderer == nil || conf.Application == nil {
+		t.Fatal("expected orderer and application config")
+	}
+
+	if conf.Orderer.ConsensusType != "etcdraft" || len(conf.Orderer.Consenters) == 0 {
+		t.Errorf("expected etcdraft consenters, got %s %v", conf.Orderer.ConsensusType, conf.Orderer.Consenters)
+	}
+	if conf.Orderer.BatchSize.MaxMessageCount == 0 {
+		t.Error("expected a batch size")
+	}
+	if len(conf.OrdererEndpoints()) == 0 {
+		t.Error("expected orderer endpoints")
+	}
+
+	msps := conf.MSPs()
+	for _, id := range []string{"OrdererMSP", "Org1MSP", "Org2MSP"} {
+		m, ok := msps[id]
+		if !ok {
+			t.Errorf("missing MSP %s", id)
+			continue
+		}
+		if len(m.RootCerts) == 0 {
+			t.Errorf("expected root certificates for %s", id)
+		}
+	}
+	if m := msps["Org1MSP"]; m.NodeOUs == nil || !m.NodeOUs.Enabled || m.NodeOUs.PeerOU.Identifier != "peer" {
+		t.Errorf("expected node OUs for Org1MSP, got %+v", m.NodeOUs)
+	}
+
+	anchorPeers := 0
+	for _, org := range conf.Application.Organizations {
+		anchorPeers += len(org.AnchorPeers)
+	}
+	if anchorPeers == 0 {
+		t.Error("expected anchor peers")
+	}
+
+	if p := conf.Application.Policies["Admins"]; p.ImplicitMeta != "MAJORITY Admins" {
+		t.Errorf("unexpected application admins policy %+v", p)
+	}
+	if p := conf.Application.Organizations["Org1MSP"].Policies["Readers"]; p.Signature == nil {
+		t.Errorf("expected a signature policy, got %+v", p)
+	}
+}
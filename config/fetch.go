@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/arner/hacky-fabric/comm"
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"google.golang.org/protobuf/proto"
+)
+
+// FetchConfigBlock returns the latest config block of a channel from a peer, using the configuration
+// system chaincode. The signer must be a member of the channel.
+func FetchConfigBlock(p *comm.Peer, signer fabrictx.Signer, channel string) (*common.Block, error) {
+	prop, err := fabrictx.NewProposal(signer, channel, "cscc", [][]byte{[]byte("GetConfigBlock"), []byte(channel)})
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.ProcessProposal(prop)
+	if err != nil {
+		return nil, fmt.Errorf("get config block: %w", err)
+	}
+	block := &common.Block{}
+	if err := proto.Unmarshal(res.Response.Payload, block); err != nil {
+		return nil, fmt.Errorf("config block: %w", err)
+	}
+	return block, nil
+}
+
+// Fetch returns the current configuration of a channel from a peer.
+func Fetch(p *comm.Peer, signer fabrictx.Signer, channel string) (*ChannelConfig, error) {
+	block, err := FetchConfigBlock(p, signer, channel)
+	if err != nil {
+		return nil, err
+	}
+	return FromBlock(block)
+}
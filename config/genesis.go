@@ -0,0 +1,191 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+
+	"github.com/arner/hacky-fabric/fabrictx"
+	"github.com/arner/hacky-fabric/policy"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"github.com/hyperledger/fabric-protos-go-apiv2/orderer"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Genesis describes a new channel, with the policies and capabilities of the sample configtx.yaml of Fabric.
+type Genesis struct {
+	OrdererOrgs      []*msp.FabricMSPConfig
+	ApplicationOrgs  []*msp.FabricMSPConfig
+	OrdererAddresses []string
+	BatchSize        BatchSize // MaxMessageCount defaults to 10
+	BatchTimeout     string    // defaults to 2s
+}
+
+// GenesisBlock returns the config block (block 0) of a new channel, for tests and local networks.
+// The consensus type is left empty.
+func GenesisBlock(channel string, g Genesis) (*common.Block, error) {
+	if len(g.OrdererOrgs) == 0 {
+		return nil, errors.New("at least one orderer organization is required")
+	}
+	if g.BatchSize.MaxMessageCount == 0 {
+		g.BatchSize.MaxMessageCount = 10
+	}
+	if g.BatchSize.AbsoluteMaxBytes == 0 {
+		g.BatchSize.AbsoluteMaxBytes = 10 * 1024 * 1024
+	}
+	if g.BatchSize.PreferredMaxBytes == 0 {
+		g.BatchSize.PreferredMaxBytes = 2 * 1024 * 1024
+	}
+	if g.BatchTimeout == "" {
+		g.BatchTimeout = "2s"
+	}
+
+	ordererGroup := newGroup(implicitMetaPolicies(map[string]*common.ImplicitMetaPolicy{
+		"BlockValidation": {Rule: common.ImplicitMetaPolicy_ANY, SubPolicy: "Writers"},
+	}))
+	ordererGroup.Values["BatchSize"] = configValue(&orderer.BatchSize{
+		MaxMessageCount:   g.BatchSize.MaxMessageCount,
+		AbsoluteMaxBytes:  g.BatchSize.AbsoluteMaxBytes,
+		PreferredMaxBytes: g.BatchSize.PreferredMaxBytes,
+	})
+	ordererGroup.Values["BatchTimeout"] = configValue(&orderer.BatchTimeout{Timeout: g.BatchTimeout})
+	ordererGroup.Values["Capabilities"] = capabilitiesValue("V2_0")
+	for _, m := range g.OrdererOrgs {
+		org, err := orgGroup(m, false)
+		if err != nil {
+			return nil, err
+		}
+		ordererGroup.Groups[m.Name] = org
+	}
+
+	appGroup := newGroup(implicitMetaPolicies(map[string]*common.ImplicitMetaPolicy{
+		"Endorsement":          {Rule: common.ImplicitMetaPolicy_MAJORITY, SubPolicy: "Endorsement"},
+		"LifecycleEndorsement": {Rule: common.ImplicitMetaPolicy_MAJORITY, SubPolicy: "Endorsement"},
+	}))
+	appGroup.Values["Capabilities"] = capabilitiesValue("V2_0")
+	for _, m := range g.ApplicationOrgs {
+		org, err := orgGroup(m, true)
+		if err != nil {
+			return nil, err
+		}
+		appGroup.Groups[m.Name] = org
+	}
+
+	channelGroup := newGroup(implicitMetaPolicies(nil))
+	channelGroup.Groups[OrdererGroup] = ordererGroup
+	channelGroup.Groups[ApplicationGroup] = appGroup
+	channelGroup.Values["HashingAlgorithm"] = configValue(&common.HashingAlgorithm{Name: "SHA256"})
+	channelGroup.Values["BlockDataHashingStructure"] = configValue(&common.BlockDataHashingStructure{Width: math.MaxUint32})
+	channelGroup.Values["Capabilities"] = capabilitiesValue("V2_0")
+	if len(g.OrdererAddresses) > 0 {
+		channelGroup.Values["OrdererAddresses"] = configValue(&common.OrdererAddresses{Addresses: g.OrdererAddresses})
+	}
+
+	ts := timestamppb.Now()
+	ts.Nanos = 0
+	payload := mustMarshal(&common.Payload{
+		Header: &common.Header{
+			ChannelHeader:   mustMarshal(&common.ChannelHeader{Type: int32(common.HeaderType_CONFIG), ChannelId: channel, Timestamp: ts}),
+			SignatureHeader: mustMarshal(&common.SignatureHeader{}),
+		},
+		Data: mustMarshal(&common.ConfigEnvelope{Config: &common.Config{ChannelGroup: channelGroup}}),
+	})
+	block, err := fabrictx.NewBlock(0, nil, &common.Envelope{Payload: payload})
+	if err != nil {
+		return nil, err
+	}
+	// the genesis block is not signed, but refers to itself as the last config block
+	block.Metadata.Metadata[common.BlockMetadataIndex_SIGNATURES] = mustMarshal(&common.Metadata{
+		Value: mustMarshal(&common.OrdererBlockMetadata{LastConfig: &common.LastConfig{Index: 0}}),
+	})
+	return block, nil
+}
+
+// orgGroup returns the group of an organization with the MSP and the sample policies. Only members of
+// application organizations can endorse.
+func orgGroup(m *msp.FabricMSPConfig, application bool) (*common.ConfigGroup, error) {
+	id := m.Name
+	rules := map[string]string{
+		"Readers": fmt.Sprintf("OR('%s.member')", id),
+		"Writers": fmt.Sprintf("OR('%s.member')", id),
+		"Admins":  fmt.Sprintf("OR('%s.admin')", id),
+	}
+	if application {
+		rules["Readers"] = fmt.Sprintf("OR('%[1]s.admin', '%[1]s.peer', '%[1]s.client')", id)
+		rules["Writers"] = fmt.Sprintf("OR('%[1]s.admin', '%[1]s.client')", id)
+		rules["Endorsement"] = fmt.Sprintf("OR('%s.peer')", id)
+	}
+
+	policies := map[string]*common.ConfigPolicy{}
+	for name, rule := range rules {
+		env, err := policy.FromString(rule)
+		if err != nil {
+			return nil, err
+		}
+		policies[name] = configPolicy(common.Policy_SIGNATURE, env)
+	}
+	g := newGroup(policies)
+	mspConf, err := proto.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("marshal msp %s: %w", id, err)
+	}
+	g.Values["MSP"] = configValue(&msp.MSPConfig{Type: 0, Config: mspConf})
+	return g, nil
+}
+
+// implicitMetaPolicies returns the Readers, Writers and Admins policies of a group, which refer to the policies of
+// its subgroups, and the extra policies.
+func implicitMetaPolicies(extra map[string]*common.ImplicitMetaPolicy) map[string]*common.ConfigPolicy {
+	rules := map[string]*common.ImplicitMetaPolicy{
+		"Readers": {Rule: common.ImplicitMetaPolicy_ANY, SubPolicy: "Readers"},
+		"Writers": {Rule: common.ImplicitMetaPolicy_ANY, SubPolicy: "Writers"},
+		"Admins":  {Rule: common.ImplicitMetaPolicy_MAJORITY, SubPolicy: "Admins"},
+	}
+	maps.Copy(rules, extra)
+	out := map[string]*common.ConfigPolicy{}
+	for name, rule := range rules {
+		out[name] = configPolicy(common.Policy_IMPLICIT_META, rule)
+	}
+	return out
+}
+
+func newGroup(policies map[string]*common.ConfigPolicy) *common.ConfigGroup {
+	return &common.ConfigGroup{
+		Groups:    map[string]*common.ConfigGroup{},
+		Values:    map[string]*common.ConfigValue{},
+		Policies:  policies,
+		ModPolicy: "Admins",
+	}
+}
+
+func configValue(m proto.Message) *common.ConfigValue {
+	return &common.ConfigValue{Value: mustMarshal(m), ModPolicy: "Admins"}
+}
+
+func configPolicy(typ common.Policy_PolicyType, m proto.Message) *common.ConfigPolicy {
+	return &common.ConfigPolicy{
+		Policy:    &common.Policy{Type: int32(typ), Value: mustMarshal(m)},
+		ModPolicy: "Admins",
+	}
+}
+
+func capabilitiesValue(names ...string) *common.ConfigValue {
+	c := &common.Capabilities{Capabilities: map[string]*common.Capability{}}
+	for _, n := range names {
+		c.Capabilities[n] = &common.Capability{}
+	}
+	return configValue(c)
+}
+
+// mustMarshal marshals the protos that are built in this package, which can't fail.
+func mustMarshal(m proto.Message) []byte {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
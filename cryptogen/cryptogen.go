@@ -0,0 +1,332 @@
+// Package cryptogen generates the crypto material of a Fabric test network without the cryptogen binary:
+// a CA and TLS CA per organization, with peers, orderers, an admin and users that are enrolled with NodeOUs.
+// The material can be used in memory or written to disk in the directory layout of cryptogen, which can be
+// read with fabrictx.SignerFromMSP and fabrictx.MSPVerifierFromDir.
+package cryptogen
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+)
+
+// Node OUs, as configured in the config.yaml of every generated MSP.
+const (
+	ClientOU  = "client"
+	PeerOU    = "peer"
+	AdminOU   = "admin"
+	OrdererOU = "orderer"
+)
+
+// validity of the generated certificates, like cryptogen.
+const validity = 10 * 365 * 24 * time.Hour
+
+// OrgSpec describes an organization to generate.
+type OrgSpec struct {
+	Domain   string // e.g. org1.example.com
+	MSPID    string // e.g. Org1MSP
+	Peers    int    // peer0.<domain>, peer1.<domain>, ...
+	Orderers int    // orderer0.<domain>, orderer1.<domain>, ...
+	Users    int    // User1@<domain>, User2@<domain>, ...
+	// NewKey generates the private keys of the organization. It defaults to ECDSA P-256.
+	NewKey func() (crypto.Signer, error)
+}
+
+// Org is the generated crypto material of an organization.
+type Org struct {
+	Domain   string
+	MSPID    string
+	CA       *CA
+	TLSCA    *CA
+	Peers    []*Identity
+	Orderers []*Identity
+	Admin    *Identity
+	Users    []*Identity
+}
+
+// CA is a self signed certificate authority.
+type CA struct {
+	Name string
+	Cert []byte // PEM encoded certificate
+	Key  []byte // PEM encoded PKCS8 private key
+
+	cert   *x509.Certificate
+	signer crypto.Signer
+}
+
+// Identity is an enrolled node or user with a signing certificate and a TLS certificate.
+type Identity struct {
+	Name    string // e.g. peer0.org1.example.com or User1@org1.example.com
+	MSPID   string
+	OU      string // the node OU: client, peer, admin or orderer
+	Cert    []byte // PEM encoded signing certificate
+	Key     []byte // PEM encoded PKCS8 private key
+	TLSCert []byte // PEM encoded TLS certificate, valid for the name, localhost, 127.0.0.1 and ::1
+	TLSKey  []byte // PEM encoded PKCS8 TLS private key
+
+	signer crypto.Signer
+}
+
+// NewOrg generates the CAs and identities of an organization.
+func NewOrg(spec OrgSpec) (*Org, error) {
+	if spec.Domain == "" || spec.MSPID == "" {
+		return nil, fmt.Errorf("domain and MSP ID are required")
+	}
+	newKey := spec.NewKey
+	if newKey == nil {
+		newKey = newECDSAKey
+	}
+
+	org := &Org{Domain: spec.Domain, MSPID: spec.MSPID}
+	var err error
+	if org.CA, err = newCA("ca."+spec.Domain, spec.Domain, newKey); err != nil {
+		return nil, fmt.Errorf("ca: %w", err)
+	}
+	if org.TLSCA, err = newCA("tlsca."+spec.Domain, spec.Domain, newKey); err != nil {
+		return nil, fmt.Errorf("tls ca: %w", err)
+	}
+
+	enroll := func(name, ou string) (*Identity, error) {
+		id, err := org.enroll(name, ou, newKey)
+		if err != nil {
+			return nil, fmt.Errorf("enroll %s: %w", name, err)
+		}
+		return id, nil
+	}
+	for i := range spec.Peers {
+		id, err := enroll(fmt.Sprintf("peer%d.%s", i, spec.Domain), PeerOU)
+		if err != nil {
+			return nil, err
+		}
+		org.Peers = append(org.Peers, id)
+	}
+	for i := range spec.Orderers {
+		id, err := enroll(fmt.Sprintf("orderer%d.%s", i, spec.Domain), OrdererOU)
+		if err != nil {
+			return nil, err
+		}
+		org.Orderers = append(org.Orderers, id)
+	}
+	if org.Admin, err = enroll("Admin@"+spec.Domain, AdminOU); err != nil {
+		return nil, err
+	}
+	for i := range spec.Users {
+		id, err := enroll(fmt.Sprintf("User%d@%s", i+1, spec.Domain), ClientOU)
+		if err != nil {
+			return nil, err
+		}
+		org.Users = append(org.Users, id)
+	}
+	return org, nil
+}
+
+// enroll issues a signing certificate with the node OU and a TLS certificate.
+func (o *Org) enroll(name, ou string, newKey func() (crypto.Signer, error)) (*Identity, error) {
+	id := &Identity{Name: name, MSPID: o.MSPID, OU: ou}
+	var err error
+	if id.signer, err = newKey(); err != nil {
+		return nil, err
+	}
+	if id.Key, err = encodeKey(id.signer); err != nil {
+		return nil, err
+	}
+	id.Cert, err = o.CA.issue(&x509.Certificate{
+		Subject:  o.subject(name, ou),
+		KeyUsage: x509.KeyUsageDigitalSignature,
+	}, id.signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	tlsKey, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	if id.TLSKey, err = encodeKey(tlsKey); err != nil {
+		return nil, err
+	}
+	id.TLSCert, err = o.TLSCA.issue(&x509.Certificate{
+		Subject:     o.subject(name, ""),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:    []string{name, strings.SplitN(name, ".", 2)[0], "localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}, tlsKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+func (o *Org) subject(name, ou string) pkix.Name {
+	s := pkix.Name{
+		Country:    []string{"US"},
+		Province:   []string{"California"},
+		Locality:   []string{"San Francisco"},
+		CommonName: name,
+	}
+	if ou != "" {
+		s.OrganizationalUnit = []string{ou}
+	}
+	return s
+}
+
+// Identities returns all identities of the organization.
+func (o *Org) Identities() []*Identity {
+	ids := append([]*Identity{}, o.Peers...)
+	ids = append(ids, o.Orderers...)
+	ids = append(ids, o.Admin)
+	return append(ids, o.Users...)
+}
+
+// MSPConfig returns the MSP definition of the organization, as it appears in the channel configuration.
+func (o *Org) MSPConfig() *msp.FabricMSPConfig {
+	ou := func(name string) *msp.FabricOUIdentifier {
+		return &msp.FabricOUIdentifier{OrganizationalUnitIdentifier: name, Certificate: o.CA.Cert}
+	}
+	return &msp.FabricMSPConfig{
+		Name:         o.MSPID,
+		RootCerts:    [][]byte{o.CA.Cert},
+		TlsRootCerts: [][]byte{o.TLSCA.Cert},
+		CryptoConfig: &msp.FabricCryptoConfig{
+			SignatureHashFamily:            "SHA2",
+			IdentityIdentifierHashFunction: "SHA256",
+		},
+		FabricNodeOus: &msp.FabricNodeOUs{
+			Enable:              true,
+			ClientOuIdentifier:  ou(ClientOU),
+			PeerOuIdentifier:    ou(PeerOU),
+			AdminOuIdentifier:   ou(AdminOU),
+			OrdererOuIdentifier: ou(OrdererOU),
+		},
+	}
+}
+
+// Signer returns a signer for the identity.
+func (id *Identity) Signer() (*fabrictx.KeySigner, error) {
+	return fabrictx.NewSigner(id.MSPID, id.Cert, id.signer)
+}
+
+// TLSCertificate returns the TLS certificate and key of the identity, for a gRPC server or mutual TLS client.
+func (id *Identity) TLSCertificate() (tls.Certificate, error) {
+	return tls.X509KeyPair(id.TLSCert, id.TLSKey)
+}
+
+func newCA(name, org string, newKey func() (crypto.Signer, error)) (*CA, error) {
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	ski, err := subjectKeyID(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		Subject: pkix.Name{
+			Country:      []string{"US"},
+			Province:     []string{"California"},
+			Locality:     []string{"San Francisco"},
+			Organization: []string{org},
+			CommonName:   name,
+		},
+		KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment |
+			x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          ski,
+	}
+	ca := &CA{Name: name, signer: key}
+	if ca.Key, err = encodeKey(key); err != nil {
+		return nil, err
+	}
+	if err := setValidity(tmpl); err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate: %w", err)
+	}
+	if ca.cert, err = x509.ParseCertificate(der); err != nil {
+		return nil, err
+	}
+	ca.Cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return ca, nil
+}
+
+// issue signs a certificate for the public key, based on the template.
+func (ca *CA) issue(tmpl *x509.Certificate, pub crypto.PublicKey) ([]byte, error) {
+	if err := setValidity(tmpl); err != nil {
+		return nil, err
+	}
+	tmpl.AuthorityKeyId = ca.cert.SubjectKeyId
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, pub, ca.signer)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// setValidity sets a random serial number and the validity period, with some slack for clock skew.
+func setValidity(tmpl *x509.Certificate) error {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = time.Now().Add(-5 * time.Minute).UTC()
+	tmpl.NotAfter = tmpl.NotBefore.Add(validity)
+	return nil
+}
+
+func subjectKeyID(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(der)
+	return h[:], nil
+}
+
+func newECDSAKey() (crypto.Signer, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+func encodeKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshal private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// writeFiles writes the files (relative path to contents) under dir, creating directories as needed.
+func writeFiles(dir string, files map[string][]byte) error {
+	for name, b := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(p, b, 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package cryptogen_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arner/hacky-fabric/cryptogen"
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+)
+
+func TestWriteNetwork(t *testing.T) {
+	org1, err := cryptogen.NewOrg(cryptogen.OrgSpec{Domain: "org1.example.com", MSPID: "Org1MSP", Peers: 2, Users: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orderer, err := cryptogen.NewOrg(cryptogen.OrgSpec{Domain: "example.com", MSPID: "OrdererMSP", Orderers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := cryptogen.WriteNetwork(dir, org1, orderer); err != nil {
+		t.Fatal(err)
+	}
+	orgDir := filepath.Join(dir, "peerOrganizations", "org1.example.com")
+
+	verifier, err := fabrictx.MSPVerifierFromDir(filepath.Join(orgDir, "msp"), "Org1MSP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		dir  string
+		role msp.MSPRole_MSPRoleType
+	}{
+		{filepath.Join("users", "User1@org1.example.com"), msp.MSPRole_CLIENT},
+		{filepath.Join("users", "Admin@org1.example.com"), msp.MSPRole_ADMIN},
+		{filepath.Join("peers", "peer1.org1.example.com"), msp.MSPRole_PEER},
+	} {
+		signer, err := fabrictx.SignerFromMSP(filepath.Join(orgDir, c.dir, "msp"), "Org1MSP")
+		if err != nil {
+			t.Fatalf("%s: %v", c.dir, err)
+		}
+		sig, err := signer.Sign([]byte("msg"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := signer.Verify([]byte("msg"), sig); err != nil {
+			t.Error(err)
+		}
+		id, err := signer.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := verifier.Validate(id, time.Time{})
+		if err != nil {
+			t.Fatalf("%s: %v", c.dir, err)
+		}
+		if err := verifier.HasRole(cert, c.role); err != nil {
+			t.Errorf("%s: %v", c.dir, err)
+		}
+	}
+
+	// the orderer has its own MSP
+	signer, err := fabrictx.SignerFromMSP(filepath.Join(dir, "ordererOrganizations", "example.com", "orderers", "orderer0.example.com", "msp"), "OrdererMSP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := signer.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Validate(id, time.Time{}); err == nil {
+		t.Error("expected the orderer not to be a member of Org1MSP")
+	}
+}
+
+func TestTLSCertificate(t *testing.T) {
+	org, err := cryptogen.NewOrg(cryptogen.OrgSpec{Domain: "org1.example.com", MSPID: "Org1MSP", Peers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(org.TLSCA.Cert) {
+		t.Fatal("failed to append TLS CA")
+	}
+	cert, err := org.Peers[0].TLSCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"peer0.org1.example.com", "localhost", "127.0.0.1", "::1"} {
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: host}); err != nil {
+			t.Errorf("%s: %v", host, err)
+		}
+	}
+}
+
+func TestMSPConfig(t *testing.T) {
+	org, err := cryptogen.NewOrg(cryptogen.OrgSpec{
+		Domain: "org2.example.com",
+		MSPID:  "Org2MSP",
+		Peers:  1,
+		Users:  2,
+		NewKey: func() (crypto.Signer, error) {
+			_, k, err := ed25519.GenerateKey(rand.Reader)
+			return k, err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(org.Identities()); n != 4 {
+		t.Errorf("expected 4 identities, got %d", n)
+	}
+	verifier, err := fabrictx.NewMSPVerifier(org.MSPConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range org.Identities() {
+		signer, err := id.Signer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		serialized, err := signer.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := verifier.Validate(serialized, time.Time{}); err != nil {
+			t.Errorf("%s: %v", id.Name, err)
+		}
+		sig, err := signer.Sign([]byte("msg"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fabrictx.VerifySignature(id.Cert, sig, []byte("msg")); err != nil {
+			t.Errorf("%s: %v", id.Name, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package cryptogen
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// nodeOUConfig is the config.yaml of every generated MSP directory. The OU certificates are relative to the MSP directory.
+const nodeOUConfig = `NodeOUs:
+  Enable: true
+  ClientOUIdentifier:
+    Certificate: cacerts/%[1]s
+    OrganizationalUnitIdentifier: client
+  PeerOUIdentifier:
+    Certificate: cacerts/%[1]s
+    OrganizationalUnitIdentifier: peer
+  AdminOUIdentifier:
+    Certificate: cacerts/%[1]s
+    OrganizationalUnitIdentifier: admin
+  OrdererOUIdentifier:
+    Certificate: cacerts/%[1]s
+    OrganizationalUnitIdentifier: orderer
+`
+
+// WriteNetwork writes the organizations in the layout of the fabric-samples test network: organizations with peers
+// in dir/peerOrganizations/<domain> and the others in dir/ordererOrganizations/<domain>.
+func WriteNetwork(dir string, orgs ...*Org) error {
+	for _, o := range orgs {
+		kind := "ordererOrganizations"
+		if len(o.Peers) > 0 {
+			kind = "peerOrganizations"
+		}
+		if err := o.Write(filepath.Join(dir, kind, o.Domain)); err != nil {
+			return fmt.Errorf("write %s: %w", o.Domain, err)
+		}
+	}
+	return nil
+}
+
+// Write writes the crypto material of the organization to dir, in the layout of cryptogen:
+//
+//	ca/                        CA certificate and key
+//	tlsca/                     TLS CA certificate and key
+//	msp/                       MSP definition of the organization
+//	peers/<name>/{msp,tls}     peers
+//	orderers/<name>/{msp,tls}  orderers
+//	users/<name>/{msp,tls}     admin and users
+func (o *Org) Write(dir string) error {
+	caFile := o.CA.Name + "-cert.pem"
+	tlsCAFile := o.TLSCA.Name + "-cert.pem"
+	files := map[string][]byte{
+		filepath.Join("ca", caFile):                   o.CA.Cert,
+		filepath.Join("ca", "priv_sk"):                o.CA.Key,
+		filepath.Join("tlsca", tlsCAFile):             o.TLSCA.Cert,
+		filepath.Join("tlsca", "priv_sk"):             o.TLSCA.Key,
+		filepath.Join("msp", "cacerts", caFile):       o.CA.Cert,
+		filepath.Join("msp", "tlscacerts", tlsCAFile): o.TLSCA.Cert,
+		filepath.Join("msp", "config.yaml"):           []byte(fmt.Sprintf(nodeOUConfig, caFile)),
+	}
+
+	add := func(kind string, id *Identity, server bool) {
+		base := filepath.Join(kind, id.Name)
+		files[filepath.Join(base, "msp", "cacerts", caFile)] = o.CA.Cert
+		files[filepath.Join(base, "msp", "tlscacerts", tlsCAFile)] = o.TLSCA.Cert
+		files[filepath.Join(base, "msp", "config.yaml")] = []byte(fmt.Sprintf(nodeOUConfig, caFile))
+		files[filepath.Join(base, "msp", "keystore", "priv_sk")] = id.Key
+		files[filepath.Join(base, "msp", "signcerts", id.Name+"-cert.pem")] = id.Cert
+
+		prefix := "client"
+		if server {
+			prefix = "server"
+		}
+		files[filepath.Join(base, "tls", "ca.crt")] = o.TLSCA.Cert
+		files[filepath.Join(base, "tls", prefix+".crt")] = id.TLSCert
+		files[filepath.Join(base, "tls", prefix+".key")] = id.TLSKey
+	}
+	for _, id := range o.Peers {
+		add("peers", id, true)
+	}
+	for _, id := range o.Orderers {
+		add("orderers", id, true)
+	}
+	add("users", o.Admin, false)
+	for _, id := range o.Users {
+		add("users", id, false)
+	}
+	return writeFiles(dir, files)
+}
@@ -0,0 +1,325 @@
+package fabrictx
+
+import (
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// Block is the human readable form of a block, with all transactions and metadata decoded.
+type Block struct {
+	Header   BlockHeader     `json:"header"`
+	Data     []BlockEnvelope `json:"data"`
+	Metadata BlockMetadata   `json:"metadata"`
+}
+
+func (b Block) String() string {
+	out, _ := json.MarshalIndent(b, "", "  ")
+	return string(out)
+}
+
+type BlockHeader struct {
+	Number       uint64 `json:"number"`
+	PreviousHash []byte `json:"previous_hash"`
+	DataHash     []byte `json:"data_hash"`
+}
+
+// BlockMetadata holds the decoded metadata entries of a block. TransactionsFilter contains the
+// validation code of every transaction, which is only set on blocks that were delivered by a peer.
+type BlockMetadata struct {
+	Signatures         []MetadataSignature `json:"signatures"`
+	LastConfig         uint64              `json:"last_config"`
+	ConsenterMetadata  []byte              `json:"consenter_metadata,omitempty"`
+	TransactionsFilter []string            `json:"transactions_filter,omitempty"`
+	CommitHash         []byte              `json:"commit_hash,omitempty"`
+}
+
+// MetadataSignature is the signature of an orderer over the block. Raft orderers identify themselves with
+// a signature header, BFT orderers with an identifier header that refers to the consenter ID.
+type MetadataSignature struct {
+	SignatureHeader  *common.SignatureHeader  `json:"signature_header,omitempty"`
+	IdentifierHeader *common.IdentifierHeader `json:"identifier_header,omitempty"`
+	Signature        []byte                   `json:"signature"`
+}
+
+// BlockEnvelope is a transaction of any type. The payload data is decoded according to the header type.
+type BlockEnvelope struct {
+	Payload   BlockPayload `json:"payload"`
+	Signature []byte       `json:"signature"`
+}
+
+// BlockPayload has exactly one of its data fields set: Transaction for ENDORSER_TRANSACTION, Config for CONFIG,
+// ConfigUpdate for CONFIG_UPDATE, and Raw for all other header types.
+type BlockPayload struct {
+	Header       Header                `json:"header"`
+	Transaction  *Data                 `json:"transaction,omitempty"`
+	Config       *ConfigEnvelope       `json:"config,omitempty"`
+	ConfigUpdate *ConfigUpdateEnvelope `json:"config_update,omitempty"`
+	Raw          []byte                `json:"raw,omitempty"`
+}
+
+// BlockToStruct decodes a block, including the transactions of all header types and the metadata.
+func BlockToStruct(b *common.Block) (Block, error) {
+	out := Block{}
+	if b.Header == nil || b.Data == nil {
+		return out, fmt.Errorf("block header or data missing")
+	}
+	out.Header = BlockHeader{
+		Number:       b.Header.Number,
+		PreviousHash: b.Header.PreviousHash,
+		DataHash:     b.Header.DataHash,
+	}
+
+	for i, envBytes := range b.Data.Data {
+		env := &common.Envelope{}
+		if err := proto.Unmarshal(envBytes, env); err != nil {
+			return out, fmt.Errorf("envelope %d: %w", i, err)
+		}
+		e, err := parseEnvelope(env)
+		if err != nil {
+			return out, fmt.Errorf("envelope %d: %w", i, err)
+		}
+		out.Data = append(out.Data, e)
+	}
+
+	md, err := parseBlockMetadata(b.Metadata)
+	if err != nil {
+		return out, err
+	}
+	out.Metadata = md
+	return out, nil
+}
+
+// parseEnvelope decodes the payload of an envelope according to its header type.
+func parseEnvelope(env *common.Envelope) (BlockEnvelope, error) {
+	out := BlockEnvelope{Signature: env.Signature}
+	hdr, pl, err := parsePayload(env)
+	if err != nil {
+		return out, err
+	}
+	out.Payload.Header = hdr
+
+	switch common.HeaderType(hdr.ChannelHeader.Type) {
+	case common.HeaderType_ENDORSER_TRANSACTION:
+		tx := &peer.Transaction{}
+		if err := proto.Unmarshal(pl.Data, tx); err != nil {
+			return out, fmt.Errorf("transaction: %w", err)
+		}
+		data := &Data{}
+		for _, act := range tx.Actions {
+			action, err := parseAction(act)
+			if err != nil {
+				return out, err
+			}
+			data.Actions = append(data.Actions, action)
+		}
+		out.Payload.Transaction = data
+	case common.HeaderType_CONFIG:
+		ce := &common.ConfigEnvelope{}
+		if err := proto.Unmarshal(pl.Data, ce); err != nil {
+			return out, fmt.Errorf("config envelope: %w", err)
+		}
+		conf, err := parseConfigEnvelope(ce)
+		if err != nil {
+			return out, err
+		}
+		out.Payload.Config = &conf
+	case common.HeaderType_CONFIG_UPDATE:
+		cue := &common.ConfigUpdateEnvelope{}
+		if err := proto.Unmarshal(pl.Data, cue); err != nil {
+			return out, fmt.Errorf("config update envelope: %w", err)
+		}
+		update, err := parseConfigUpdateEnvelope(cue)
+		if err != nil {
+			return out, err
+		}
+		out.Payload.ConfigUpdate = &update
+	default:
+		out.Payload.Raw = pl.Data
+	}
+	return out, nil
+}
+
+// parsePayload decodes the payload of an envelope and its headers, but not the data.
+func parsePayload(env *common.Envelope) (Header, *common.Payload, error) {
+	h := Header{}
+	pl := &common.Payload{}
+	if err := proto.Unmarshal(env.Payload, pl); err != nil {
+		return h, nil, fmt.Errorf("payload: %w", err)
+	}
+	if pl.Header == nil {
+		return h, nil, fmt.Errorf("payload header missing")
+	}
+
+	chdr := &common.ChannelHeader{}
+	if err := proto.Unmarshal(pl.Header.ChannelHeader, chdr); err != nil {
+		return h, nil, fmt.Errorf("channel header: %w", err)
+	}
+
+	shdr := &common.SignatureHeader{}
+	if err := proto.Unmarshal(pl.Header.SignatureHeader, shdr); err != nil {
+		return h, nil, fmt.Errorf("signature header: %w", err)
+	}
+	h.ChannelHeader = chdr
+	h.SignatureHeader = shdr
+
+	return h, pl, nil
+}
+
+func parseBlockMetadata(md *common.BlockMetadata) (BlockMetadata, error) {
+	out := BlockMetadata{}
+	if md == nil {
+		return out, nil
+	}
+	entry := func(i common.BlockMetadataIndex) []byte {
+		if len(md.Metadata) <= int(i) {
+			return nil
+		}
+		return md.Metadata[i]
+	}
+
+	if b := entry(common.BlockMetadataIndex_SIGNATURES); len(b) > 0 {
+		sigs := &common.Metadata{}
+		if err := proto.Unmarshal(b, sigs); err != nil {
+			return out, fmt.Errorf("signatures metadata: %w", err)
+		}
+		for _, s := range sigs.Signatures {
+			sig := MetadataSignature{Signature: s.Signature}
+			if len(s.SignatureHeader) > 0 {
+				sig.SignatureHeader = &common.SignatureHeader{}
+				if err := proto.Unmarshal(s.SignatureHeader, sig.SignatureHeader); err != nil {
+					return out, fmt.Errorf("metadata signature header: %w", err)
+				}
+			}
+			if len(s.IdentifierHeader) > 0 {
+				sig.IdentifierHeader = &common.IdentifierHeader{}
+				if err := proto.Unmarshal(s.IdentifierHeader, sig.IdentifierHeader); err != nil {
+					return out, fmt.Errorf("metadata identifier header: %w", err)
+				}
+			}
+			out.Signatures = append(out.Signatures, sig)
+		}
+		// since Fabric 2.0 the last config index is part of the signed metadata
+		obm := &common.OrdererBlockMetadata{}
+		if err := proto.Unmarshal(sigs.Value, obm); err != nil {
+			return out, fmt.Errorf("orderer block metadata: %w", err)
+		}
+		if obm.LastConfig != nil {
+			out.LastConfig = obm.LastConfig.Index
+		}
+		out.ConsenterMetadata = obm.ConsenterMetadata
+	}
+
+	if b := entry(common.BlockMetadataIndex_LAST_CONFIG); len(b) > 0 && out.LastConfig == 0 {
+		m := &common.Metadata{}
+		if err := proto.Unmarshal(b, m); err != nil {
+			return out, fmt.Errorf("last config metadata: %w", err)
+		}
+		lc := &common.LastConfig{}
+		if err := proto.Unmarshal(m.Value, lc); err != nil {
+			return out, fmt.Errorf("last config: %w", err)
+		}
+		out.LastConfig = lc.Index
+	}
+
+	for _, code := range entry(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		out.TransactionsFilter = append(out.TransactionsFilter, peer.TxValidationCode(code).String())
+	}
+
+	if b := entry(common.BlockMetadataIndex_COMMIT_HASH); len(b) > 0 {
+		m := &common.Metadata{}
+		if err := proto.Unmarshal(b, m); err != nil {
+			return out, fmt.Errorf("commit hash metadata: %w", err)
+		}
+		out.CommitHash = m.Value
+	}
+	return out, nil
+}
+
+// NewBlock returns a block with the envelopes as data, the header hashes filled in and empty metadata entries,
+// like an orderer cuts it. The previous hash is the BlockHeaderHash of the previous block (nil for the genesis block).
+func NewBlock(number uint64, previousHash []byte, envs ...*common.Envelope) (*common.Block, error) {
+	data := &common.BlockData{}
+	for i, env := range envs {
+		b, err := proto.Marshal(env)
+		if err != nil {
+			return nil, fmt.Errorf("envelope %d: %w", i, err)
+		}
+		data.Data = append(data.Data, b)
+	}
+	return &common.Block{
+		Header: &common.BlockHeader{
+			Number:       number,
+			PreviousHash: previousHash,
+			DataHash:     BlockDataHash(data),
+		},
+		Data:     data,
+		Metadata: &common.BlockMetadata{Metadata: make([][]byte, len(common.BlockMetadataIndex_name))},
+	}, nil
+}
+
+// SignBlock adds the signature of an orderer and the index of the last config block to the SIGNATURES metadata.
+func SignBlock(b *common.Block, signer Signer, lastConfig uint64) error {
+	creator, err := signer.Serialize()
+	if err != nil {
+		return err
+	}
+	value := mustMarshal(&common.OrdererBlockMetadata{LastConfig: &common.LastConfig{Index: lastConfig}})
+	sigHdr := mustMarshal(&common.SignatureHeader{Creator: creator, Nonce: mustNonce()})
+
+	msg := append(append(append([]byte{}, value...), sigHdr...), BlockHeaderBytes(b.Header)...)
+	sig, err := signer.Sign(msg)
+	if err != nil {
+		return fmt.Errorf("sign block: %w", err)
+	}
+	if b.Metadata == nil {
+		b.Metadata = &common.BlockMetadata{}
+	}
+	for len(b.Metadata.Metadata) < len(common.BlockMetadataIndex_name) {
+		b.Metadata.Metadata = append(b.Metadata.Metadata, nil)
+	}
+	b.Metadata.Metadata[common.BlockMetadataIndex_SIGNATURES] = mustMarshal(&common.Metadata{
+		Value:      value,
+		Signatures: []*common.MetadataSignature{{SignatureHeader: sigHdr, Signature: sig}},
+	})
+	return nil
+}
+
+// BlockHeaderBytes returns the ASN.1 encoding of a block header, which is what orderers sign and what the hash chain is built on.
+func BlockHeaderBytes(h *common.BlockHeader) []byte {
+	b, err := asn1.Marshal(struct {
+		Number       *big.Int
+		PreviousHash []byte
+		DataHash     []byte
+	}{
+		Number:       new(big.Int).SetUint64(h.Number),
+		PreviousHash: h.PreviousHash,
+		DataHash:     h.DataHash,
+	})
+	if err != nil {
+		// only happens for types that can't be encoded
+		panic(err)
+	}
+	return b
+}
+
+// BlockHeaderHash returns the hash of a block header, which is the PreviousHash of the next block.
+func BlockHeaderHash(h *common.BlockHeader) []byte {
+	sum := sha256.Sum256(BlockHeaderBytes(h))
+	return sum[:]
+}
+
+// BlockDataHash returns the hash over the envelopes of a block.
+func BlockDataHash(d *common.BlockData) []byte {
+	h := sha256.New()
+	for _, b := range d.Data {
+		h.Write(b)
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,266 @@
+package fabrictx
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"github.com/hyperledger/fabric-protos-go-apiv2/orderer"
+	"github.com/hyperledger/fabric-protos-go-apiv2/orderer/etcdraft"
+	"github.com/hyperledger/fabric-protos-go-apiv2/orderer/smartbft"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// ConfigEnvelope is the content of a CONFIG transaction: the full channel config, and the
+// CONFIG_UPDATE transaction that led to it (absent in a genesis block).
+type ConfigEnvelope struct {
+	Config     Config         `json:"config"`
+	LastUpdate *BlockEnvelope `json:"last_update,omitempty"`
+}
+
+type Config struct {
+	Sequence     uint64      `json:"sequence"`
+	ChannelGroup ConfigGroup `json:"channel_group"`
+}
+
+// ConfigGroup is a node in the config tree, like /Channel/Application/Org1MSP.
+type ConfigGroup struct {
+	Version   uint64                  `json:"version"`
+	ModPolicy string                  `json:"mod_policy"`
+	Groups    map[string]ConfigGroup  `json:"groups"`
+	Values    map[string]ConfigValue  `json:"values"`
+	Policies  map[string]ConfigPolicy `json:"policies"`
+}
+
+// ConfigValue holds the decoded value if the key is known (for example *orderer.BatchSize for BatchSize),
+// or the raw bytes if not.
+type ConfigValue struct {
+	Version   uint64 `json:"version"`
+	ModPolicy string `json:"mod_policy"`
+	Value     any    `json:"value"`
+}
+
+// ConfigPolicy holds a *common.SignaturePolicyEnvelope or a *common.ImplicitMetaPolicy, depending on the type.
+type ConfigPolicy struct {
+	Version   uint64 `json:"version"`
+	ModPolicy string `json:"mod_policy"`
+	Type      string `json:"type"`
+	Value     any    `json:"value"`
+}
+
+// MSPValue is the decoded MSP value of an organization.
+type MSPValue struct {
+	Type   int32                `json:"type"`
+	Config *msp.FabricMSPConfig `json:"config,omitempty"`
+	Raw    []byte               `json:"raw,omitempty"`
+}
+
+// ConsensusTypeValue is the decoded ConsensusType value of the orderer group. Metadata is
+// an *etcdraft.ConfigMetadata for etcdraft or a *smartbft.Options for BFT.
+type ConsensusTypeValue struct {
+	Type     string `json:"type"`
+	Metadata any    `json:"metadata"`
+	State    string `json:"state"`
+}
+
+// ConfigUpdateEnvelope is the content of a CONFIG_UPDATE transaction.
+type ConfigUpdateEnvelope struct {
+	ConfigUpdate ConfigUpdate      `json:"config_update"`
+	Signatures   []ConfigSignature `json:"signatures"`
+}
+
+// ConfigUpdate contains the versions of the config that an update depends on (ReadSet) and the
+// modified config elements (WriteSet).
+type ConfigUpdate struct {
+	ChannelID string      `json:"channel_id"`
+	ReadSet   ConfigGroup `json:"read_set"`
+	WriteSet  ConfigGroup `json:"write_set"`
+}
+
+type ConfigSignature struct {
+	SignatureHeader *common.SignatureHeader `json:"signature_header"`
+	Signature       []byte                  `json:"signature"`
+}
+
+func parseConfigEnvelope(ce *common.ConfigEnvelope) (ConfigEnvelope, error) {
+	out := ConfigEnvelope{}
+	if ce.Config != nil {
+		group, err := ParseConfigGroup(ce.Config.ChannelGroup)
+		if err != nil {
+			return out, err
+		}
+		out.Config = Config{Sequence: ce.Config.Sequence, ChannelGroup: group}
+	}
+	if ce.LastUpdate != nil {
+		lu, err := parseEnvelope(ce.LastUpdate)
+		if err != nil {
+			return out, fmt.Errorf("last update: %w", err)
+		}
+		out.LastUpdate = &lu
+	}
+	return out, nil
+}
+
+func parseConfigUpdateEnvelope(cue *common.ConfigUpdateEnvelope) (ConfigUpdateEnvelope, error) {
+	out := ConfigUpdateEnvelope{}
+	cu := &common.ConfigUpdate{}
+	if err := proto.Unmarshal(cue.ConfigUpdate, cu); err != nil {
+		return out, fmt.Errorf("config update: %w", err)
+	}
+	readSet, err := ParseConfigGroup(cu.ReadSet)
+	if err != nil {
+		return out, fmt.Errorf("read set: %w", err)
+	}
+	writeSet, err := ParseConfigGroup(cu.WriteSet)
+	if err != nil {
+		return out, fmt.Errorf("write set: %w", err)
+	}
+	out.ConfigUpdate = ConfigUpdate{ChannelID: cu.ChannelId, ReadSet: readSet, WriteSet: writeSet}
+
+	for _, sig := range cue.Signatures {
+		shdr := &common.SignatureHeader{}
+		if err := proto.Unmarshal(sig.SignatureHeader, shdr); err != nil {
+			return out, fmt.Errorf("config signature header: %w", err)
+		}
+		out.Signatures = append(out.Signatures, ConfigSignature{SignatureHeader: shdr, Signature: sig.Signature})
+	}
+	return out, nil
+}
+
+// ParseConfigGroup decodes a config (sub)tree, including its values and policies.
+func ParseConfigGroup(g *common.ConfigGroup) (ConfigGroup, error) {
+	out := ConfigGroup{
+		Groups:   map[string]ConfigGroup{},
+		Values:   map[string]ConfigValue{},
+		Policies: map[string]ConfigPolicy{},
+	}
+	if g == nil {
+		return out, nil
+	}
+	out.Version = g.Version
+	out.ModPolicy = g.ModPolicy
+
+	for name, sub := range g.Groups {
+		group, err := ParseConfigGroup(sub)
+		if err != nil {
+			return out, fmt.Errorf("%s: %w", name, err)
+		}
+		out.Groups[name] = group
+	}
+	for key, v := range g.Values {
+		val, err := parseConfigValue(key, v.Value)
+		if err != nil {
+			return out, fmt.Errorf("value %s: %w", key, err)
+		}
+		out.Values[key] = ConfigValue{Version: v.Version, ModPolicy: v.ModPolicy, Value: val}
+	}
+	for name, p := range g.Policies {
+		pol := ConfigPolicy{Version: p.Version, ModPolicy: p.ModPolicy}
+		if p.Policy != nil {
+			var err error
+			pol.Type = common.Policy_PolicyType(p.Policy.Type).String()
+			if pol.Value, err = parsePolicy(p.Policy); err != nil {
+				return out, fmt.Errorf("policy %s: %w", name, err)
+			}
+		}
+		out.Policies[name] = pol
+	}
+	return out, nil
+}
+
+// parseConfigValue decodes the value of a config key. The keys are unique across the config tree,
+// so the key is enough to know the type. Unknown keys are returned as raw bytes.
+func parseConfigValue(key string, b []byte) (any, error) {
+	var m proto.Message
+	switch key {
+	case "HashingAlgorithm":
+		m = &common.HashingAlgorithm{}
+	case "BlockDataHashingStructure":
+		m = &common.BlockDataHashingStructure{}
+	case "OrdererAddresses", "Endpoints":
+		m = &common.OrdererAddresses{}
+	case "Consortium":
+		m = &common.Consortium{}
+	case "Capabilities":
+		m = &common.Capabilities{}
+	case "Orderers":
+		m = &common.Orderers{}
+	case "BatchSize":
+		m = &orderer.BatchSize{}
+	case "BatchTimeout":
+		m = &orderer.BatchTimeout{}
+	case "ChannelRestrictions":
+		m = &orderer.ChannelRestrictions{}
+	case "AnchorPeers":
+		m = &peer.AnchorPeers{}
+	case "ACLs":
+		m = &peer.ACLs{}
+	case "MSP":
+		return parseMSPValue(b)
+	case "ConsensusType":
+		return parseConsensusType(b)
+	default:
+		return b, nil
+	}
+	if err := proto.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func parseMSPValue(b []byte) (MSPValue, error) {
+	conf := &msp.MSPConfig{}
+	if err := proto.Unmarshal(b, conf); err != nil {
+		return MSPValue{}, err
+	}
+	out := MSPValue{Type: conf.Type}
+	// 0 is the default (x509 based) Fabric MSP, idemix and others are left undecoded.
+	if conf.Type != 0 {
+		out.Raw = conf.Config
+		return out, nil
+	}
+	out.Config = &msp.FabricMSPConfig{}
+	if err := proto.Unmarshal(conf.Config, out.Config); err != nil {
+		return MSPValue{}, fmt.Errorf("fabric msp config: %w", err)
+	}
+	return out, nil
+}
+
+func parseConsensusType(b []byte) (ConsensusTypeValue, error) {
+	ct := &orderer.ConsensusType{}
+	if err := proto.Unmarshal(b, ct); err != nil {
+		return ConsensusTypeValue{}, err
+	}
+	out := ConsensusTypeValue{Type: ct.Type, Metadata: ct.Metadata, State: ct.State.String()}
+	var md proto.Message
+	switch ct.Type {
+	case "etcdraft":
+		md = &etcdraft.ConfigMetadata{}
+	case "BFT":
+		md = &smartbft.Options{}
+	default:
+		return out, nil
+	}
+	if err := proto.Unmarshal(ct.Metadata, md); err != nil {
+		return out, fmt.Errorf("%s metadata: %w", ct.Type, err)
+	}
+	out.Metadata = md
+	return out, nil
+}
+
+func parsePolicy(p *common.Policy) (any, error) {
+	var m proto.Message
+	switch common.Policy_PolicyType(p.Type) {
+	case common.Policy_SIGNATURE:
+		m = &common.SignaturePolicyEnvelope{}
+	case common.Policy_IMPLICIT_META:
+		m = &common.ImplicitMetaPolicy{}
+	default:
+		return p.Value, nil
+	}
+	if err := proto.Unmarshal(p.Value, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
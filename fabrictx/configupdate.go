@@ -0,0 +1,216 @@
+package fabrictx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"google.golang.org/protobuf/proto"
+)
+
+// ConfigFromBlock returns the channel config in a config block, for instance to modify it and compute an update.
+func ConfigFromBlock(b *common.Block) (*common.Config, error) {
+	if b.Data == nil || len(b.Data.Data) != 1 {
+		return nil, errors.New("not a config block")
+	}
+	env := &common.Envelope{}
+	if err := proto.Unmarshal(b.Data.Data[0], env); err != nil {
+		return nil, fmt.Errorf("envelope: %w", err)
+	}
+	hdr, pl, err := parsePayload(env)
+	if err != nil {
+		return nil, err
+	}
+	if common.HeaderType(hdr.ChannelHeader.Type) != common.HeaderType_CONFIG {
+		return nil, fmt.Errorf("not a config block: header type %d", hdr.ChannelHeader.Type)
+	}
+	ce := &common.ConfigEnvelope{}
+	if err := proto.Unmarshal(pl.Data, ce); err != nil {
+		return nil, fmt.Errorf("config envelope: %w", err)
+	}
+	if ce.Config == nil {
+		return nil, errors.New("config missing")
+	}
+	return ce.Config, nil
+}
+
+// ComputeUpdate returns the config update that changes the original config into the updated one, like
+// 'configtxlator compute_update'. The read set contains the versions of the elements that the update depends on,
+// the write set the modified elements with incremented versions.
+func ComputeUpdate(channel string, original, updated *common.Config) (*common.ConfigUpdate, error) {
+	if original.ChannelGroup == nil {
+		return nil, errors.New("no channel group in original config")
+	}
+	if updated.ChannelGroup == nil {
+		return nil, errors.New("no channel group in updated config")
+	}
+	readSet, writeSet, changed := computeGroupUpdate(original.ChannelGroup, updated.ChannelGroup)
+	if !changed {
+		return nil, errors.New("no differences detected between original and updated config")
+	}
+	return &common.ConfigUpdate{
+		ChannelId: channel,
+		ReadSet:   readSet,
+		WriteSet:  writeSet,
+	}, nil
+}
+
+// NewConfigUpdateTx creates a CONFIG_UPDATE transaction that can be broadcast to the orderer. The update is signed
+// by all signers (typically admins of the organizations that the mod policies require), and the envelope by the submitter.
+func NewConfigUpdateTx(channel string, submitter Signer, update *common.ConfigUpdate, signers []Signer) (*common.Envelope, error) {
+	updateBytes, err := proto.Marshal(update)
+	if err != nil {
+		return nil, fmt.Errorf("marshal config update: %w", err)
+	}
+	cue := &common.ConfigUpdateEnvelope{ConfigUpdate: updateBytes}
+	for _, s := range signers {
+		sig, err := SignConfigUpdate(s, updateBytes)
+		if err != nil {
+			return nil, err
+		}
+		cue.Signatures = append(cue.Signatures, sig)
+	}
+
+	creator, err := submitter.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	hdr, _ := header(channel, creator, nil, common.HeaderType_CONFIG_UPDATE)
+	pl := mustMarshal(&common.Payload{
+		Header: hdr,
+		Data:   mustMarshal(cue),
+	})
+	sig, err := submitter.Sign(pl)
+	if err != nil {
+		return nil, fmt.Errorf("sign payload: %w", err)
+	}
+	return &common.Envelope{
+		Payload:   pl,
+		Signature: sig,
+	}, nil
+}
+
+// SignConfigUpdate returns the signature of the signer over a serialized config update. Signatures can be
+// collected separately and added to the ConfigUpdateEnvelope.
+func SignConfigUpdate(signer Signer, update []byte) (*common.ConfigSignature, error) {
+	creator, err := signer.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	sigHeader := mustMarshal(&common.SignatureHeader{Creator: creator, Nonce: mustNonce()})
+	sig, err := signer.Sign(append(append([]byte{}, sigHeader...), update...))
+	if err != nil {
+		return nil, fmt.Errorf("sign config update: %w", err)
+	}
+	return &common.ConfigSignature{SignatureHeader: sigHeader, Signature: sig}, nil
+}
+
+// computeGroupUpdate compares two versions of a config group. An element that is modified gets its version
+// incremented in the write set. A group whose members (keys) change is modified itself, and then all its
+// unchanged members are added to the read and write sets with their current version.
+func computeGroupUpdate(original, updated *common.ConfigGroup) (*common.ConfigGroup, *common.ConfigGroup, bool) {
+	readPolicies, writePolicies, samePolicies, policiesChanged := computePoliciesUpdate(original.Policies, updated.Policies)
+	readValues, writeValues, sameValues, valuesChanged := computeValuesUpdate(original.Values, updated.Values)
+	readGroups, writeGroups, sameGroups, groupsChanged := computeGroupsUpdate(original.Groups, updated.Groups)
+
+	if !policiesChanged && !valuesChanged && !groupsChanged && original.ModPolicy == updated.ModPolicy {
+		if len(writePolicies) == 0 && len(writeValues) == 0 && len(readGroups) == 0 && len(writeGroups) == 0 {
+			return &common.ConfigGroup{Version: original.Version}, &common.ConfigGroup{Version: original.Version}, false
+		}
+		// only (nested) elements changed, the group itself stays at the same version
+		return &common.ConfigGroup{Version: original.Version, Policies: readPolicies, Values: readValues, Groups: readGroups},
+			&common.ConfigGroup{Version: original.Version, Policies: writePolicies, Values: writeValues, Groups: writeGroups}, true
+	}
+
+	for k, p := range samePolicies {
+		readPolicies[k] = p
+		writePolicies[k] = p
+	}
+	for k, v := range sameValues {
+		readValues[k] = v
+		writeValues[k] = v
+	}
+	for k, g := range sameGroups {
+		readGroups[k] = g
+		writeGroups[k] = g
+	}
+	return &common.ConfigGroup{Version: original.Version, Policies: readPolicies, Values: readValues, Groups: readGroups},
+		&common.ConfigGroup{Version: original.Version + 1, ModPolicy: updated.ModPolicy, Policies: writePolicies, Values: writeValues, Groups: writeGroups}, true
+}
+
+func computePoliciesUpdate(original, updated map[string]*common.ConfigPolicy) (read, write, same map[string]*common.ConfigPolicy, membersChanged bool) {
+	read, write, same = map[string]*common.ConfigPolicy{}, map[string]*common.ConfigPolicy{}, map[string]*common.ConfigPolicy{}
+	for name, o := range original {
+		u, ok := updated[name]
+		if !ok {
+			membersChanged = true
+			continue
+		}
+		if o.ModPolicy == u.ModPolicy && proto.Equal(o.Policy, u.Policy) {
+			same[name] = &common.ConfigPolicy{Version: o.Version}
+			continue
+		}
+		write[name] = &common.ConfigPolicy{Version: o.Version + 1, ModPolicy: u.ModPolicy, Policy: u.Policy}
+	}
+	for name, u := range updated {
+		if _, ok := original[name]; ok {
+			continue
+		}
+		membersChanged = true
+		write[name] = &common.ConfigPolicy{Version: 0, ModPolicy: u.ModPolicy, Policy: u.Policy}
+	}
+	return read, write, same, membersChanged
+}
+
+func computeValuesUpdate(original, updated map[string]*common.ConfigValue) (read, write, same map[string]*common.ConfigValue, membersChanged bool) {
+	read, write, same = map[string]*common.ConfigValue{}, map[string]*common.ConfigValue{}, map[string]*common.ConfigValue{}
+	for key, o := range original {
+		u, ok := updated[key]
+		if !ok {
+			membersChanged = true
+			continue
+		}
+		if o.ModPolicy == u.ModPolicy && bytes.Equal(o.Value, u.Value) {
+			same[key] = &common.ConfigValue{Version: o.Version}
+			continue
+		}
+		write[key] = &common.ConfigValue{Version: o.Version + 1, ModPolicy: u.ModPolicy, Value: u.Value}
+	}
+	for key, u := range updated {
+		if _, ok := original[key]; ok {
+			continue
+		}
+		membersChanged = true
+		write[key] = &common.ConfigValue{Version: 0, ModPolicy: u.ModPolicy, Value: u.Value}
+	}
+	return read, write, same, membersChanged
+}
+
+func computeGroupsUpdate(original, updated map[string]*common.ConfigGroup) (read, write, same map[string]*common.ConfigGroup, membersChanged bool) {
+	read, write, same = map[string]*common.ConfigGroup{}, map[string]*common.ConfigGroup{}, map[string]*common.ConfigGroup{}
+	for name, o := range original {
+		u, ok := updated[name]
+		if !ok {
+			membersChanged = true
+			continue
+		}
+		r, w, changed := computeGroupUpdate(o, u)
+		if !changed {
+			same[name] = r
+			continue
+		}
+		read[name] = r
+		write[name] = w
+	}
+	for name, u := range updated {
+		if _, ok := original[name]; ok {
+			continue
+		}
+		membersChanged = true
+		// a new group is written completely, at version 0
+		_, w, _ := computeGroupUpdate(&common.ConfigGroup{}, u)
+		write[name] = &common.ConfigGroup{Version: 0, ModPolicy: u.ModPolicy, Policies: w.Policies, Values: w.Values, Groups: w.Groups}
+	}
+	return read, write, same, membersChanged
+}
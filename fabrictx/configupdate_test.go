@@ -0,0 +1,110 @@
+package fabrictx_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"github.com/hyperledger/fabric-protos-go-apiv2/orderer"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestConfigUpdate(t *testing.T) {
+	b, err := os.ReadFile("./fixtures/channel.block")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &common.Block{}
+	if err = proto.Unmarshal(b, block); err != nil {
+		t.Fatal(err)
+	}
+	original, err := fabrictx.ConfigFromBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fabrictx.ComputeUpdate("mychannel", original, original); err == nil {
+		t.Error("expected an error for an update without changes")
+	}
+
+	// change the batch size
+	updated := proto.Clone(original).(*common.Config)
+	ord := updated.ChannelGroup.Groups["Orderer"]
+	batchSize := &orderer.BatchSize{}
+	if err := proto.Unmarshal(ord.Values["BatchSize"].Value, batchSize); err != nil {
+		t.Fatal(err)
+	}
+	batchSize.MaxMessageCount++
+	ord.Values["BatchSize"].Value, _ = proto.Marshal(batchSize)
+
+	update, err := fabrictx.ComputeUpdate("mychannel", original, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origOrd := original.ChannelGroup.Groups["Orderer"]
+	w := update.WriteSet.Groups["Orderer"]
+	if w == nil || len(w.Values) != 1 || w.Values["BatchSize"].Version != origOrd.Values["BatchSize"].Version+1 {
+		t.Fatalf("unexpected write set %v", update.WriteSet)
+	}
+	if w.Version != origOrd.Version || len(update.WriteSet.Groups) != 1 {
+		t.Errorf("expected only the batch size to be modified, got %v", update.WriteSet)
+	}
+	if r := update.ReadSet.Groups["Orderer"]; r == nil || r.Version != origOrd.Version {
+		t.Errorf("unexpected read set %v", update.ReadSet)
+	}
+
+	// sign by two admins and wrap in an envelope
+	submitter, signers := getTestUsers(t)
+	env, err := fabrictx.NewConfigUpdateTx("mychannel", submitter, update, signers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := fabrictx.BlockToStruct(&common.Block{
+		Header: &common.BlockHeader{},
+		Data:   &common.BlockData{Data: [][]byte{mustMarshal(t, env)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := parsed.Data[0].Payload
+	if pl.ConfigUpdate == nil || pl.ConfigUpdate.ConfigUpdate.ChannelID != "mychannel" {
+		t.Fatalf("expected a config update, got %+v", pl)
+	}
+
+	cue := &common.ConfigUpdateEnvelope{}
+	pld := &common.Payload{}
+	if err := proto.Unmarshal(env.Payload, pld); err != nil {
+		t.Fatal(err)
+	}
+	if err := proto.Unmarshal(pld.Data, cue); err != nil {
+		t.Fatal(err)
+	}
+	if len(cue.Signatures) != len(signers) {
+		t.Fatalf("expected %d signatures, got %d", len(signers), len(cue.Signatures))
+	}
+	for _, sig := range cue.Signatures {
+		shdr := &common.SignatureHeader{}
+		if err := proto.Unmarshal(sig.SignatureHeader, shdr); err != nil {
+			t.Fatal(err)
+		}
+		id := &msp.SerializedIdentity{}
+		if err := proto.Unmarshal(shdr.Creator, id); err != nil {
+			t.Fatal(err)
+		}
+		msg := append(append([]byte{}, sig.SignatureHeader...), cue.ConfigUpdate...)
+		if err := fabrictx.VerifySignature(id.IdBytes, sig.Signature, msg); err != nil {
+			t.Errorf("invalid signature of %s: %v", id.Mspid, err)
+		}
+	}
+}
+
+func mustMarshal(t *testing.T, m proto.Message) []byte {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
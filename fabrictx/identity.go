@@ -1,7 +1,9 @@
 package fabrictx
 
 import (
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/x509"
@@ -16,74 +18,124 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
-type Signer struct {
-	priv_sk  *ecdsa.PrivateKey
+// Signer is an identity that can sign transactions, proposals and config updates.
+type Signer interface {
+	// Sign returns the signature over the message, in the format that Fabric expects.
+	Sign(msg []byte) ([]byte, error)
+	// Serialize returns the msp.SerializedIdentity of the signer.
+	Serialize() ([]byte, error)
+	MSPID() string
+}
+
+// KeySigner signs with a crypto.Signer, which can be a private key in memory or a key in an HSM or KMS.
+type KeySigner struct {
+	key      crypto.Signer
 	signcert []byte
 	mspID    string
 }
 
-func SignerFromMSP(dir, mspID string) (Signer, error) {
-	keyFiles, err := filepath.Glob(filepath.Join(dir, "keystore", "*_sk"))
-	if err != nil || len(keyFiles) == 0 {
-		return Signer{}, fmt.Errorf("no private key found: %w", err)
-	}
-	privBytes, err := os.ReadFile(keyFiles[0])
+// NewSigner returns a signer for the key and the PEM encoded certificate. The public key of the
+// certificate must match the key.
+func NewSigner(mspID string, certPEM []byte, key crypto.Signer) (*KeySigner, error) {
+	cert, err := ParseCertificate(certPEM)
 	if err != nil {
-		return Signer{}, err
+		return nil, err
 	}
-	pk, err := parsePrivateKey(privBytes)
-	if err != nil {
-		return Signer{}, err
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return nil, errors.New("private key does not match the certificate")
 	}
+	return &KeySigner{
+		key:      key,
+		signcert: certPEM,
+		mspID:    mspID,
+	}, nil
+}
 
+// SignerFromMSP loads the signer from an MSP directory as generated by cryptogen or the Fabric CA client:
+// the first private key in keystore and the first certificate in signcerts.
+func SignerFromMSP(dir, mspID string) (*KeySigner, error) {
+	keyFiles, err := filepath.Glob(filepath.Join(dir, "keystore", "*_sk"))
+	if err != nil || len(keyFiles) == 0 {
+		return nil, fmt.Errorf("no private key found: %w", err)
+	}
 	certFiles, err := filepath.Glob(filepath.Join(dir, "signcerts", "*.pem"))
 	if err != nil || len(certFiles) == 0 {
-		return Signer{}, fmt.Errorf("no signcert found: %w", err)
+		return nil, fmt.Errorf("no signcert found: %w", err)
 	}
-	certPEM, err := os.ReadFile(certFiles[0])
+	return SignerFromKeyFile(mspID, certFiles[0], keyFiles[0])
+}
+
+// SignerFromKeyFile loads a signer from a PEM encoded certificate and a PKCS8 (ECDSA or Ed25519) or SEC1
+// (EC PRIVATE KEY) private key.
+func SignerFromKeyFile(mspID, certFile, keyFile string) (*KeySigner, error) {
+	return SignerFromEncryptedKeyFile(mspID, certFile, keyFile, nil)
+}
+
+// SignerFromEncryptedKeyFile loads a signer like SignerFromKeyFile, with a private key that is encrypted
+// with a password (a PEM block with a Proc-Type: 4,ENCRYPTED header).
+func SignerFromEncryptedKeyFile(mspID, certFile, keyFile string, password []byte) (*KeySigner, error) {
+	privBytes, err := os.ReadFile(keyFile)
 	if err != nil {
-		return Signer{}, err
+		return nil, err
 	}
-
-	return Signer{
-		priv_sk:  pk,
-		signcert: certPEM,
-		mspID:    mspID,
-	}, nil
+	pk, err := parsePrivateKey(privBytes, password)
+	if err != nil {
+		return nil, err
+	}
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(mspID, certPEM, pk)
 }
 
-func (s Signer) Sign(msg []byte) ([]byte, error) {
-	return sign(s.priv_sk, msg)
+func (s *KeySigner) Sign(msg []byte) ([]byte, error) {
+	return sign(s.key, msg)
 }
-func (s Signer) Verify(msg []byte, sig []byte) error {
+
+func (s *KeySigner) Verify(msg []byte, sig []byte) error {
 	return VerifySignature(s.signcert, sig, msg)
 }
 
-func (s Signer) Serialize() ([]byte, error) {
+func (s *KeySigner) Serialize() ([]byte, error) {
 	return proto.Marshal(&msp.SerializedIdentity{Mspid: s.mspID, IdBytes: s.signcert})
 }
 
-func VerifySignature(certPEM, signature, message []byte) error {
-	block, _ := pem.Decode(certPEM)
-	if block == nil || block.Type != "CERTIFICATE" {
-		return fmt.Errorf("failed to decode PEM certificate")
-	}
-	cert, err := x509.ParseCertificate(block.Bytes)
-	if err != nil {
-		return fmt.Errorf("parse certificate: %w", err)
-	}
-	pubKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
-	if !ok {
-		return fmt.Errorf("certificate is not ECDSA")
-	}
+func (s *KeySigner) MSPID() string {
+	return s.mspID
+}
+
+// Certificate returns the PEM encoded certificate of the signer.
+func (s *KeySigner) Certificate() []byte {
+	return s.signcert
+}
 
-	digest := sha256.Sum256(message)
-	ok, err = verifyECDSA(pubKey, signature, digest[:])
+// VerifySignature verifies a signature of the owner of the PEM encoded certificate, with the same hash selection
+// as the MSP of a peer: ECDSA signatures (any curve) are over the SHA-256 digest of the message, and Ed25519 signatures
+// over the message itself.
+func VerifySignature(certPEM, signature, message []byte) error {
+	cert, err := ParseCertificate(certPEM)
 	if err != nil {
-		return fmt.Errorf("signature verification failed: %w", err)
-	}
-	if !ok {
-		return errors.New("invalid signature")
+		return err
+	}
+
+	switch pubKey := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		ok, err := verifyECDSA(pubKey, signature, digest[:])
+		if err != nil {
+			return fmt.Errorf("signature verification failed: %w", err)
+		}
+		if !ok {
+			return errors.New("invalid signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pubKey, message, signature) {
+			return errors.New("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", cert.PublicKey)
 	}
 	return nil
 }
@@ -106,34 +158,87 @@ func verifyECDSA(k *ecdsa.PublicKey, signature, digest []byte) (bool, error) {
 	return ecdsa.Verify(k, digest, r, s), nil
 }
 
-func sign(k *ecdsa.PrivateKey, message []byte) ([]byte, error) {
-	digest := sha256.Sum256(message)
-	r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
-	if err != nil {
-		return nil, err
+// sign signs the message like the MSP of a peer does: ECDSA keys sign the SHA-256 digest, also on curves other
+// than P-256, because Fabric derives the hash from the hash family (SHA2) and not from the key. Keys outside of
+// our control (HSM, KMS) don't necessarily return a low S value, so ECDSA signatures are normalized, like Fabric
+// requires. Ed25519 keys sign the message itself.
+func sign(k crypto.Signer, message []byte) ([]byte, error) {
+	switch pub := k.Public().(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		sig, err := k.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		r, s, err := utils.UnmarshalECDSASignature(sig)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal signature: %w", err)
+		}
+		s, err = utils.ToLowS(pub, s)
+		if err != nil {
+			return nil, err
+		}
+		return utils.MarshalECDSASignature(r, s)
+	case ed25519.PublicKey:
+		return k.Sign(rand.Reader, message, crypto.Hash(0))
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", pub)
 	}
-
-	s, err = utils.ToLowS(&k.PublicKey, s)
-	if err != nil {
-		return nil, err
-	}
-
-	return utils.MarshalECDSASignature(r, s)
 }
 
-func parsePrivateKey(privPEM []byte) (*ecdsa.PrivateKey, error) {
+// parsePrivateKey parses a PKCS8 (ECDSA or Ed25519) or SEC1 private key, which is decrypted first if it is encrypted.
+func parsePrivateKey(privPEM, password []byte) (crypto.Signer, error) {
 	block, _ := pem.Decode(privPEM)
 	if block == nil {
 		return nil, fmt.Errorf("failed to decode PEM private key")
 	}
 
-	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
-	if err != nil {
-		return nil, fmt.Errorf("parse pkcs8 private key: %w", err)
+	der := block.Bytes
+	//nolint:staticcheck // legacy PEM encryption is insecure, but it is what Fabric tooling produces.
+	if x509.IsEncryptedPEMBlock(block) {
+		if len(password) == 0 {
+			return nil, errors.New("private key is encrypted, but no password was given")
+		}
+		var err error
+		//nolint:staticcheck
+		if der, err = x509.DecryptPEMBlock(block, password); err != nil {
+			return nil, fmt.Errorf("decrypt private key: %w", err)
+		}
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("parse sec1 private key: %w", err)
+		}
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("parse pkcs8 private key: %w", err)
+		}
+	}
+	switch pk := key.(type) {
+	case *ecdsa.PrivateKey:
+		return pk, nil
+	case ed25519.PrivateKey:
+		return pk, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
 	}
-	pk, ok := key.(*ecdsa.PrivateKey)
-	if !ok {
-		return nil, fmt.Errorf("not an ECDSA private key")
+}
+
+// ParseCertificate decodes a PEM encoded X.509 certificate, such as the IdBytes of a serialized identity.
+func ParseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("failed to decode PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
 	}
-	return pk, nil
+	return cert, nil
 }
@@ -0,0 +1,271 @@
+package fabrictx_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+)
+
+const (
+	userCert = "fixtures/user/signcerts/User1@org1.example.com-cert.pem"
+	userKey  = "fixtures/user/keystore/priv_sk"
+)
+
+func TestSignerBackends(t *testing.T) {
+	certPEM, err := os.ReadFile(userCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := readKey(t, userKey)
+	_, endorsers := getTestUsers(t)
+	dir := t.TempDir()
+
+	// SEC1 encoded key
+	sec1, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1File := filepath.Join(dir, "sec1.pem")
+	writePEM(t, sec1File, &pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1})
+
+	// encrypted key
+	//nolint:staticcheck
+	encrypted, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", sec1, []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedFile := filepath.Join(dir, "encrypted.pem")
+	writePEM(t, encryptedFile, encrypted)
+	if _, err := fabrictx.SignerFromEncryptedKeyFile("Org1MSP", userCert, encryptedFile, nil); err == nil {
+		t.Error("expected an error without password")
+	}
+	if _, err := fabrictx.SignerFromEncryptedKeyFile("Org1MSP", userCert, encryptedFile, []byte("wrong")); err == nil {
+		t.Error("expected an error with the wrong password")
+	}
+
+	signers := map[string]func() (fabrictx.Signer, error){
+		"msp":       func() (fabrictx.Signer, error) { return fabrictx.SignerFromMSP("fixtures/user", "Org1MSP") },
+		"in memory": func() (fabrictx.Signer, error) { return fabrictx.NewSigner("Org1MSP", certPEM, key) },
+		"sec1":      func() (fabrictx.Signer, error) { return fabrictx.SignerFromKeyFile("Org1MSP", userCert, sec1File) },
+		"encrypted": func() (fabrictx.Signer, error) {
+			return fabrictx.SignerFromEncryptedKeyFile("Org1MSP", userCert, encryptedFile, []byte("secret"))
+		},
+		"callback": func() (fabrictx.Signer, error) {
+			s, err := fabrictx.NewSigner("Org1MSP", certPEM, key)
+			if err != nil {
+				return nil, err
+			}
+			return fabrictx.NewFuncSigner("Org1MSP", certPEM, s.Sign)
+		},
+		"remote": func() (fabrictx.Signer, error) {
+			// a remote signer doesn't necessarily return low S signatures
+			return fabrictx.NewRemoteSigner("Org1MSP", certPEM, func(digest []byte) ([]byte, error) {
+				return ecdsa.SignASN1(rand.Reader, key, digest)
+			})
+		},
+	}
+
+	for name, newSigner := range signers {
+		t.Run(name, func(t *testing.T) {
+			s, err := newSigner()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.MSPID() != "Org1MSP" {
+				t.Errorf("unexpected MSP ID %s", s.MSPID())
+			}
+			for range 10 {
+				msg := []byte("hello")
+				sig, err := s.Sign(msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := fabrictx.VerifySignature(certPEM, sig, msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rw := &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "k", Value: []byte("v")}}}
+			tx, _, err := fabrictx.NewEndorserTransaction("mychannel", "basic", s, endorsers, rw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := validateEnvelope("fixtures/user", "Org1MSP", "mychannel", tx); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSignerKeyMismatch(t *testing.T) {
+	certPEM, err := os.ReadFile(userCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(readKey(t, userKey).Curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fabrictx.NewSigner("Org1MSP", certPEM, other); err == nil {
+		t.Error("expected an error for a key that doesn't match the certificate")
+	}
+}
+
+func TestSignatureAlgorithms(t *testing.T) {
+	keys := map[string]func() (crypto.Signer, error){
+		"P-256":   func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) },
+		"P-384":   func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P384(), rand.Reader) },
+		"Ed25519": func() (crypto.Signer, error) { _, k, err := ed25519.GenerateKey(rand.Reader); return k, err },
+	}
+	for name, newKey := range keys {
+		t.Run(name, func(t *testing.T) {
+			dir := generateMSP(t, newKey)
+			s, err := fabrictx.SignerFromMSP(dir, "TestMSP")
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := []byte("hello")
+			sig, err := s.Sign(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := fabrictx.VerifySignature(s.Certificate(), sig, msg); err != nil {
+				t.Fatal(err)
+			}
+			if err := fabrictx.VerifySignature(s.Certificate(), sig, []byte("other")); err == nil {
+				t.Error("expected an invalid signature for another message")
+			}
+
+			// like the MSP of a peer, ECDSA signs the SHA-256 digest regardless of the curve
+			block, _ := pem.Decode(s.Certificate())
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pub, ok := cert.PublicKey.(*ecdsa.PublicKey); ok {
+				digest := sha256.Sum256(msg)
+				if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+					t.Error("expected a signature over the SHA-256 digest")
+				}
+				if err := fabrictx.VerifySignature(s.Certificate(), highS(t, pub, sig), msg); err == nil {
+					t.Error("expected a high S signature to be rejected")
+				}
+			}
+
+			// endorse a transaction
+			rw := &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "k", Value: []byte("v")}}}
+			tx, _, err := fabrictx.NewEndorserTransaction("mychannel", "basic", s, []fabrictx.Signer{s}, rw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := fabrictx.EndorserTxToStruct(tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			act := parsed.Payload.Data.Actions[0]
+			if err := act.Endorsements[0].Verify(act.ProposalResponsePayloadB); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// generateMSP writes an MSP directory with a CA and a signing identity that use keys of the same type.
+func generateMSP(t *testing.T, newKey func() (crypto.Signer, error)) string {
+	caKey, err := newKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := newKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca.example.com", Organization: []string{"example.com"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "user@example.com", OrganizationalUnit: []string{"client"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caTmpl, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for _, d := range []string{"cacerts", "signcerts", "keystore"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePEM(t, filepath.Join(dir, "cacerts", "ca.pem"), &pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	writePEM(t, filepath.Join(dir, "signcerts", "cert.pem"), &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	writePEM(t, filepath.Join(dir, "keystore", "priv_sk"), &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})
+	return dir
+}
+
+// highS returns the equivalent signature with S = N - S.
+func highS(t *testing.T, pub *ecdsa.PublicKey, sig []byte) []byte {
+	var rs struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+		t.Fatal(err)
+	}
+	rs.S.Sub(pub.Curve.Params().N, rs.S)
+	b, err := asn1.Marshal(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func readKey(t *testing.T, file string) *ecdsa.PrivateKey {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(b)
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key.(*ecdsa.PrivateKey)
+}
+
+func writePEM(t *testing.T, file string, block *pem.Block) {
+	if err := os.WriteFile(file, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
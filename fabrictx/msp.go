@@ -0,0 +1,395 @@
+package fabrictx
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// MSPVerifier validates identities against the definition of an x509 based MSP, like the MSP of a peer does:
+// the certificate must chain to a root CA of the MSP, be valid at the given time, not be revoked by one of
+// the CRLs and, if NodeOUs are enabled, have exactly one of the node OUs.
+type MSPVerifier struct {
+	id            string
+	roots         *x509.CertPool
+	intermediates *x509.CertPool
+	admins        []*x509.Certificate
+	crls          []*x509.RevocationList
+	ous           []ouIdentifier
+	nodeOUs       map[msp.MSPRole_MSPRoleType]ouIdentifier
+}
+
+// ouIdentifier is an organizational unit, optionally restricted to certificates issued by a specific CA.
+type ouIdentifier struct {
+	name string
+	ca   *x509.Certificate
+}
+
+// NewMSPVerifier returns a verifier for an MSP definition, as found in the channel config or in an MSP directory
+// (see MSPConfigFromDir).
+func NewMSPVerifier(conf *msp.FabricMSPConfig) (*MSPVerifier, error) {
+	v := &MSPVerifier{
+		id:            conf.Name,
+		roots:         x509.NewCertPool(),
+		intermediates: x509.NewCertPool(),
+	}
+	if len(conf.RootCerts) == 0 {
+		return nil, fmt.Errorf("msp %s: no root certificates", conf.Name)
+	}
+	for _, b := range conf.RootCerts {
+		cert, err := ParseCertificate(b)
+		if err != nil {
+			return nil, fmt.Errorf("msp %s: root cert: %w", conf.Name, err)
+		}
+		v.roots.AddCert(cert)
+	}
+	for _, b := range conf.IntermediateCerts {
+		cert, err := ParseCertificate(b)
+		if err != nil {
+			return nil, fmt.Errorf("msp %s: intermediate cert: %w", conf.Name, err)
+		}
+		v.intermediates.AddCert(cert)
+	}
+	for _, b := range conf.Admins {
+		cert, err := ParseCertificate(b)
+		if err != nil {
+			return nil, fmt.Errorf("msp %s: admin cert: %w", conf.Name, err)
+		}
+		v.admins = append(v.admins, cert)
+	}
+	for _, b := range conf.RevocationList {
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return nil, fmt.Errorf("msp %s: failed to decode PEM crl", conf.Name)
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("msp %s: crl: %w", conf.Name, err)
+		}
+		v.crls = append(v.crls, crl)
+	}
+	for _, ou := range conf.OrganizationalUnitIdentifiers {
+		id, err := newOUIdentifier(ou)
+		if err != nil {
+			return nil, fmt.Errorf("msp %s: %w", conf.Name, err)
+		}
+		v.ous = append(v.ous, id)
+	}
+	if n := conf.FabricNodeOus; n != nil && n.Enable {
+		v.nodeOUs = map[msp.MSPRole_MSPRoleType]ouIdentifier{}
+		for role, ou := range map[msp.MSPRole_MSPRoleType]*msp.FabricOUIdentifier{
+			msp.MSPRole_CLIENT:  n.ClientOuIdentifier,
+			msp.MSPRole_PEER:    n.PeerOuIdentifier,
+			msp.MSPRole_ADMIN:   n.AdminOuIdentifier,
+			msp.MSPRole_ORDERER: n.OrdererOuIdentifier,
+		} {
+			if ou == nil {
+				continue
+			}
+			id, err := newOUIdentifier(ou)
+			if err != nil {
+				return nil, fmt.Errorf("msp %s: node OU: %w", conf.Name, err)
+			}
+			v.nodeOUs[role] = id
+		}
+	}
+	return v, nil
+}
+
+func newOUIdentifier(ou *msp.FabricOUIdentifier) (ouIdentifier, error) {
+	id := ouIdentifier{name: ou.OrganizationalUnitIdentifier}
+	if len(ou.Certificate) > 0 {
+		cert, err := ParseCertificate(ou.Certificate)
+		if err != nil {
+			return id, fmt.Errorf("certificate of OU %s: %w", ou.OrganizationalUnitIdentifier, err)
+		}
+		id.ca = cert
+	}
+	return id, nil
+}
+
+// MSPVerifierFromDir returns a verifier for an MSP directory.
+func MSPVerifierFromDir(dir, mspID string) (*MSPVerifier, error) {
+	conf, err := MSPConfigFromDir(dir, mspID)
+	if err != nil {
+		return nil, err
+	}
+	return NewMSPVerifier(conf)
+}
+
+// ID returns the MSP ID.
+func (v *MSPVerifier) ID() string {
+	return v.id
+}
+
+// Validate checks that a serialized identity belongs to the MSP and is valid at the given time (now if zero).
+// It returns the certificate of the identity.
+func (v *MSPVerifier) Validate(serialized []byte, at time.Time) (*x509.Certificate, error) {
+	id := &msp.SerializedIdentity{}
+	if err := proto.Unmarshal(serialized, id); err != nil {
+		return nil, fmt.Errorf("serialized identity: %w", err)
+	}
+	if id.Mspid != v.id {
+		return nil, fmt.Errorf("identity of %s is not a member of %s", id.Mspid, v.id)
+	}
+	cert, err := ParseCertificate(id.IdBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.ValidateCert(cert, at); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// ValidateCert checks that the certificate chains to a root CA of the MSP, is valid at the given time (now if zero),
+// is not revoked and has the organizational units that the MSP requires.
+func (v *MSPVerifier) ValidateCert(cert *x509.Certificate, at time.Time) error {
+	if at.IsZero() {
+		at = time.Now()
+	}
+	if cert.IsCA {
+		return errors.New("identity is a CA certificate")
+	}
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: v.intermediates,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("msp %s: %w", v.id, err)
+	}
+	chain := chains[0]
+	if len(chain) < 2 {
+		return fmt.Errorf("msp %s: identity is a root certificate", v.id)
+	}
+
+	if err := v.checkRevoked(chain); err != nil {
+		return err
+	}
+	if len(v.ous) > 0 && !slices.ContainsFunc(v.ous, func(ou ouIdentifier) bool { return ou.matches(cert, chain) }) {
+		return fmt.Errorf("msp %s: identity does not have one of the required organizational units", v.id)
+	}
+	if v.nodeOUs != nil {
+		matched := 0
+		for _, ou := range v.nodeOUs {
+			if ou.matches(cert, chain) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("msp %s: identity must have exactly one node OU, found %d", v.id, matched)
+		}
+	}
+	return nil
+}
+
+// checkRevoked checks every certificate in the chain, except the root, against the CRLs that are issued by its CA,
+// so that revoking an intermediate CA revokes the identities that it issued.
+func (v *MSPVerifier) checkRevoked(chain []*x509.Certificate) error {
+	for i, cert := range chain[:len(chain)-1] {
+		issuer := chain[i+1]
+		for _, crl := range v.crls {
+			if crl.CheckSignatureFrom(issuer) != nil {
+				continue
+			}
+			for _, rc := range crl.RevokedCertificateEntries {
+				if rc.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return fmt.Errorf("msp %s: certificate %s has been revoked", v.id, cert.SerialNumber)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (ou ouIdentifier) matches(cert *x509.Certificate, chain []*x509.Certificate) bool {
+	if !slices.Contains(cert.Subject.OrganizationalUnit, ou.name) {
+		return false
+	}
+	return ou.ca == nil || slices.ContainsFunc(chain[1:], func(c *x509.Certificate) bool { return c.Equal(ou.ca) })
+}
+
+// HasRole checks whether a valid certificate has a role in the MSP. Members are all valid identities. Admins are
+// the identities in the admin certificates of the MSP, or with the admin OU. The other roles require NodeOUs.
+func (v *MSPVerifier) HasRole(cert *x509.Certificate, role msp.MSPRole_MSPRoleType) error {
+	switch role {
+	case msp.MSPRole_MEMBER:
+		return nil
+	case msp.MSPRole_ADMIN:
+		if slices.ContainsFunc(v.admins, cert.Equal) {
+			return nil
+		}
+	}
+	if v.nodeOUs == nil {
+		return fmt.Errorf("msp %s: NodeOUs are not enabled, can't tell apart identities with role %s", v.id, role)
+	}
+	ou, ok := v.nodeOUs[role]
+	if !ok || !slices.Contains(cert.Subject.OrganizationalUnit, ou.name) {
+		return fmt.Errorf("identity of %s does not have role %s", v.id, role)
+	}
+	return nil
+}
+
+// SatisfiesPrincipal checks whether a serialized identity is valid at the given time (now if zero) and
+// satisfies the principal of a policy.
+func (v *MSPVerifier) SatisfiesPrincipal(serialized []byte, principal *msp.MSPPrincipal, at time.Time) error {
+	switch principal.PrincipalClassification {
+	case msp.MSPPrincipal_ROLE:
+		role := &msp.MSPRole{}
+		if err := proto.Unmarshal(principal.Principal, role); err != nil {
+			return fmt.Errorf("msp role: %w", err)
+		}
+		if role.MspIdentifier != v.id {
+			return fmt.Errorf("principal of %s can't be satisfied by msp %s", role.MspIdentifier, v.id)
+		}
+		cert, err := v.Validate(serialized, at)
+		if err != nil {
+			return err
+		}
+		return v.HasRole(cert, role.Role)
+	case msp.MSPPrincipal_ORGANIZATION_UNIT:
+		unit := &msp.OrganizationUnit{}
+		if err := proto.Unmarshal(principal.Principal, unit); err != nil {
+			return fmt.Errorf("organization unit: %w", err)
+		}
+		if unit.MspIdentifier != v.id {
+			return fmt.Errorf("principal of %s can't be satisfied by msp %s", unit.MspIdentifier, v.id)
+		}
+		cert, err := v.Validate(serialized, at)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(cert.Subject.OrganizationalUnit, unit.OrganizationalUnitIdentifier) {
+			return fmt.Errorf("identity is not part of %s.%s", unit.MspIdentifier, unit.OrganizationalUnitIdentifier)
+		}
+		return nil
+	case msp.MSPPrincipal_IDENTITY:
+		if !bytes.Equal(principal.Principal, serialized) {
+			return errors.New("identity does not match")
+		}
+		_, err := v.Validate(serialized, at)
+		return err
+	}
+	return fmt.Errorf("unsupported principal classification %s", principal.PrincipalClassification)
+}
+
+// mspDirConfig is the config.yaml of an MSP directory.
+type mspDirConfig struct {
+	OrganizationalUnitIdentifiers []ouDirConfig `yaml:"OrganizationalUnitIdentifiers"`
+	NodeOUs                       *struct {
+		Enable              bool         `yaml:"Enable"`
+		ClientOUIdentifier  *ouDirConfig `yaml:"ClientOUIdentifier"`
+		PeerOUIdentifier    *ouDirConfig `yaml:"PeerOUIdentifier"`
+		AdminOUIdentifier   *ouDirConfig `yaml:"AdminOUIdentifier"`
+		OrdererOUIdentifier *ouDirConfig `yaml:"OrdererOUIdentifier"`
+	} `yaml:"NodeOUs"`
+}
+
+type ouDirConfig struct {
+	Certificate                  string `yaml:"Certificate"`
+	OrganizationalUnitIdentifier string `yaml:"OrganizationalUnitIdentifier"`
+}
+
+// MSPConfigFromDir reads the MSP definition from an MSP directory as generated by cryptogen or the Fabric CA client:
+// cacerts, intermediatecerts, admincerts, crls, tlscacerts, tlsintermediatecerts and the NodeOUs in config.yaml.
+func MSPConfigFromDir(dir, mspID string) (*msp.FabricMSPConfig, error) {
+	conf := &msp.FabricMSPConfig{Name: mspID}
+	var err error
+	for _, f := range []struct {
+		dir    string
+		target *[][]byte
+	}{
+		{"cacerts", &conf.RootCerts},
+		{"intermediatecerts", &conf.IntermediateCerts},
+		{"admincerts", &conf.Admins},
+		{"crls", &conf.RevocationList},
+		{"tlscacerts", &conf.TlsRootCerts},
+		{"tlsintermediatecerts", &conf.TlsIntermediateCerts},
+	} {
+		if *f.target, err = readPEMDir(filepath.Join(dir, f.dir)); err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "config.yaml"))
+	if errors.Is(err, os.ErrNotExist) {
+		return conf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dc := &mspDirConfig{}
+	if err := yaml.Unmarshal(b, dc); err != nil {
+		return nil, fmt.Errorf("msp config.yaml: %w", err)
+	}
+	ou := func(c *ouDirConfig) (*msp.FabricOUIdentifier, error) {
+		if c == nil {
+			return nil, nil
+		}
+		id := &msp.FabricOUIdentifier{OrganizationalUnitIdentifier: c.OrganizationalUnitIdentifier}
+		if c.Certificate != "" {
+			if id.Certificate, err = os.ReadFile(filepath.Join(dir, c.Certificate)); err != nil {
+				return nil, err
+			}
+		}
+		return id, nil
+	}
+	for _, c := range dc.OrganizationalUnitIdentifiers {
+		id, err := ou(&c)
+		if err != nil {
+			return nil, err
+		}
+		conf.OrganizationalUnitIdentifiers = append(conf.OrganizationalUnitIdentifiers, id)
+	}
+	if n := dc.NodeOUs; n != nil {
+		conf.FabricNodeOus = &msp.FabricNodeOUs{Enable: n.Enable}
+		if conf.FabricNodeOus.ClientOuIdentifier, err = ou(n.ClientOUIdentifier); err != nil {
+			return nil, err
+		}
+		if conf.FabricNodeOus.PeerOuIdentifier, err = ou(n.PeerOUIdentifier); err != nil {
+			return nil, err
+		}
+		if conf.FabricNodeOus.AdminOuIdentifier, err = ou(n.AdminOUIdentifier); err != nil {
+			return nil, err
+		}
+		if conf.FabricNodeOus.OrdererOuIdentifier, err = ou(n.OrdererOUIdentifier); err != nil {
+			return nil, err
+		}
+	}
+	return conf, nil
+}
+
+// readPEMDir returns the contents of all files in a directory, or nothing if it doesn't exist.
+func readPEMDir(dir string) ([][]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out [][]byte
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
@@ -0,0 +1,226 @@
+package fabrictx_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMSPVerifierFromDir(t *testing.T) {
+	org1, err := fabrictx.MSPVerifierFromDir("fixtures/user", "Org1MSP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	submitter, endorsers := getTestUsers(t)
+
+	user, err := submitter.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := org1.Validate(user, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := org1.HasRole(cert, msp.MSPRole_CLIENT); err != nil {
+		t.Error(err)
+	}
+	if err := org1.HasRole(cert, msp.MSPRole_PEER); err == nil {
+		t.Error("expected a client not to be a peer")
+	}
+	if err := org1.HasRole(cert, msp.MSPRole_ADMIN); err == nil {
+		t.Error("expected a client not to be an admin")
+	}
+	if _, err := org1.Validate(user, cert.NotAfter.Add(time.Hour)); err == nil {
+		t.Error("expected an expired certificate to be invalid")
+	}
+
+	peer, err := endorsers[0].Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := org1.SatisfiesPrincipal(peer, rolePrincipal(t, "Org1MSP", msp.MSPRole_PEER), time.Time{}); err != nil {
+		t.Error(err)
+	}
+	if err := org1.SatisfiesPrincipal(user, rolePrincipal(t, "Org1MSP", msp.MSPRole_PEER), time.Time{}); err == nil {
+		t.Error("expected a client not to satisfy the peer role")
+	}
+
+	// an identity of another org, and a certificate of another org with the MSP ID of Org1MSP
+	org2, err := endorsers[1].Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := org1.Validate(org2, time.Time{}); err == nil {
+		t.Error("expected an identity of Org2MSP to be invalid")
+	}
+	id := &msp.SerializedIdentity{}
+	if err := proto.Unmarshal(org2, id); err != nil {
+		t.Fatal(err)
+	}
+	id.Mspid = "Org1MSP"
+	if _, err := org1.Validate(mustMarshal(t, id), time.Time{}); err == nil {
+		t.Error("expected a certificate of another CA to be invalid")
+	}
+}
+
+func TestMSPVerifierRevocation(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca.example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(serial int64, ous ...string) []byte {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "user", OrganizationalUnit: ous},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mustMarshal(t, &msp.SerializedIdentity{
+			Mspid:   "TestMSP",
+			IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		})
+	}
+	valid, revoked, twoRoles := issue(2, "client"), issue(3, "client"), issue(4, "client", "admin")
+
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: big.NewInt(3), RevocationTime: time.Now()}},
+	}, ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	v, err := fabrictx.NewMSPVerifier(&msp.FabricMSPConfig{
+		Name:           "TestMSP",
+		RootCerts:      [][]byte{caPEM},
+		RevocationList: [][]byte{pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl})},
+		FabricNodeOus: &msp.FabricNodeOUs{
+			Enable:             true,
+			ClientOuIdentifier: &msp.FabricOUIdentifier{OrganizationalUnitIdentifier: "client", Certificate: caPEM},
+			AdminOuIdentifier:  &msp.FabricOUIdentifier{OrganizationalUnitIdentifier: "admin", Certificate: caPEM},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := v.Validate(valid, time.Time{}); err != nil {
+		t.Error(err)
+	}
+	if _, err := v.Validate(revoked, time.Time{}); err == nil {
+		t.Error("expected a revoked certificate to be invalid")
+	}
+	if _, err := v.Validate(twoRoles, time.Time{}); err == nil {
+		t.Error("expected a certificate with two node OUs to be invalid")
+	}
+}
+
+func TestMSPVerifierRevokedIntermediate(t *testing.T) {
+	// newCert issues a certificate, or a self-signed one if parent is nil
+	newCert := func(serial int64, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: fmt.Sprintf("cert%d.example.com", serial)},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			BasicConstraintsValid: isCA,
+			IsCA:                  isCA,
+		}
+		if isCA {
+			tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		}
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+	certPEM := func(cert *x509.Certificate) []byte {
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+
+	root, rootKey := newCert(1, true, nil, nil)
+	intermediate, intermediateKey := newCert(2, true, root, rootKey)
+	revokedIntermediate, revokedKey := newCert(3, true, root, rootKey)
+	user, _ := newCert(10, false, intermediate, intermediateKey)
+	revokedUser, _ := newCert(11, false, revokedIntermediate, revokedKey)
+
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: big.NewInt(3), RevocationTime: time.Now()}},
+	}, root, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := fabrictx.NewMSPVerifier(&msp.FabricMSPConfig{
+		Name:              "TestMSP",
+		RootCerts:         [][]byte{certPEM(root)},
+		IntermediateCerts: [][]byte{certPEM(intermediate), certPEM(revokedIntermediate)},
+		RevocationList:    [][]byte{pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl})},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.ValidateCert(user, time.Time{}); err != nil {
+		t.Error(err)
+	}
+	if err := v.ValidateCert(revokedUser, time.Time{}); err == nil {
+		t.Error("expected a certificate of a revoked intermediate CA to be invalid")
+	}
+}
+
+func rolePrincipal(t *testing.T, mspID string, role msp.MSPRole_MSPRoleType) *msp.MSPPrincipal {
+	return &msp.MSPPrincipal{
+		PrincipalClassification: msp.MSPPrincipal_ROLE,
+		Principal:               mustMarshal(t, &msp.MSPRole{MspIdentifier: mspID, Role: role}),
+	}
+}
@@ -49,7 +49,8 @@ type Endorsement struct {
 	Signature []byte                  `json:"signature"`
 }
 
-// Verify verifies the validity of the signature over the payload. It does not know whether the endorser is part of the policy or whether the policy has been met.
+// Verify verifies the validity of the signature over the payload. It does not know whether the endorser is part of the policy or whether the policy has been met,
+// see the policy package for that.
 func (e Endorsement) Verify(proposalResponsePayload []byte) error {
 	// The message to be verified is the concatenation of the ProposalResponsePayload and the serialized endorser identity
 	if err := VerifySignature(e.Endorser.IdBytes, e.Signature, append(proposalResponsePayload, e.EndorserB...)); err != nil {
@@ -75,9 +76,10 @@ type Extension struct {
 }
 
 type NsRwset struct {
-	Namespace string           `json:"namespace"`
-	Rwset     *kvrwset.KVRWSet `json:"rwset"`
-	TxID      string           `json:"-"`
+	Namespace   string                  `json:"namespace"`
+	Rwset       *kvrwset.KVRWSet        `json:"rwset"`
+	Collections []CollectionHashedRwset `json:"collections,omitempty"`
+	TxID        string                  `json:"-"`
 }
 
 func EndorserTxToStruct(env *common.Envelope) (Envelope, error) {
@@ -109,29 +111,15 @@ func EndorserTxToStruct(env *common.Envelope) (Envelope, error) {
 }
 
 func parseHeader(env *common.Envelope) (Header, *peer.Transaction, error) {
-	h := Header{}
-	pl := &common.Payload{}
-	if err := proto.Unmarshal(env.Payload, pl); err != nil {
-		return h, nil, fmt.Errorf("payload: %w", err)
-	}
-
-	chdr := &common.ChannelHeader{}
-	if err := proto.Unmarshal(pl.Header.ChannelHeader, chdr); err != nil {
-		return h, nil, fmt.Errorf("channel header: %w", err)
-	}
-
-	shdr := &common.SignatureHeader{}
-	if err := proto.Unmarshal(pl.Header.SignatureHeader, shdr); err != nil {
-		return h, nil, fmt.Errorf("signature header: %w", err)
+	h, pl, err := parsePayload(env)
+	if err != nil {
+		return h, nil, err
 	}
 
 	tx := &peer.Transaction{}
 	if err := proto.Unmarshal(pl.Data, tx); err != nil {
 		return h, nil, fmt.Errorf("transaction: %w", err)
 	}
-	h.ChannelHeader = chdr
-	h.SignatureHeader = shdr
-
 	return h, tx, nil
 }
 
@@ -178,9 +166,14 @@ func parseAction(act *peer.TransactionAction) (Action, error) {
 		if err := proto.Unmarshal(ns.Rwset, kvs); err != nil {
 			return a, fmt.Errorf("kvrwset: %w", err)
 		}
+		colls, err := parseCollections(ns)
+		if err != nil {
+			return a, err
+		}
 		nsList = append(nsList, NsRwset{
-			Namespace: ns.Namespace,
-			Rwset:     kvs,
+			Namespace:   ns.Namespace,
+			Rwset:       kvs,
+			Collections: colls,
 		})
 	}
 
@@ -215,55 +208,113 @@ func parseAction(act *peer.TransactionAction) (Action, error) {
 	}, nil
 }
 
+// ChannelHeader returns the channel header of an envelope, which holds among others the header type and transaction ID.
+func ChannelHeader(env *common.Envelope) (*common.ChannelHeader, error) {
+	pl := &common.Payload{}
+	if err := proto.Unmarshal(env.Payload, pl); err != nil {
+		return nil, fmt.Errorf("payload: %w", err)
+	}
+	if pl.Header == nil {
+		return nil, fmt.Errorf("payload header missing")
+	}
+	chdr := &common.ChannelHeader{}
+	if err := proto.Unmarshal(pl.Header.ChannelHeader, chdr); err != nil {
+		return nil, fmt.Errorf("channel header: %w", err)
+	}
+	return chdr, nil
+}
+
 // RWSets retrieves the resulting reads and writes from a transaction.
 func RWSets(env *common.Envelope) ([]NsRwset, error) {
 	out := []NsRwset{}
+	txID, actions, err := chaincodeActions(env)
+	if err != nil {
+		return out, err
+	}
+
+	for _, ccAct := range actions {
+		txRWSet := &rwset.TxReadWriteSet{}
+		if err := proto.Unmarshal(ccAct.Results, txRWSet); err != nil {
+			return out, fmt.Errorf("rwset: %w", err)
+		}
+
+		for _, ns := range txRWSet.NsRwset {
+			kvs := &kvrwset.KVRWSet{}
+			if err := proto.Unmarshal(ns.Rwset, kvs); err != nil {
+				return out, fmt.Errorf("kvrwset: %w", err)
+			}
+			colls, err := parseCollections(ns)
+			if err != nil {
+				return out, err
+			}
+			out = append(out, NsRwset{
+				Namespace:   ns.Namespace,
+				Rwset:       kvs,
+				Collections: colls,
+				TxID:        txID,
+			})
+		}
+	}
+	return out, nil
+}
+
+// ChaincodeEvent returns the event that the chaincode set in a transaction, or nil if there is none.
+// Like in Fabric, there is at most one event per transaction.
+func ChaincodeEvent(env *common.Envelope) (*peer.ChaincodeEvent, error) {
+	_, actions, err := chaincodeActions(env)
+	if err != nil {
+		return nil, err
+	}
+	for _, ccAct := range actions {
+		event := &peer.ChaincodeEvent{}
+		if err := proto.Unmarshal(ccAct.Events, event); err != nil {
+			return nil, fmt.Errorf("events: %w", err)
+		}
+		if event.EventName != "" {
+			return event, nil
+		}
+	}
+	return nil, nil
+}
+
+// chaincodeActions returns the transaction ID and the endorsed chaincode actions of an endorser transaction.
+func chaincodeActions(env *common.Envelope) (string, []*peer.ChaincodeAction, error) {
 	pl := &common.Payload{}
 	if err := proto.Unmarshal(env.Payload, pl); err != nil {
-		return out, fmt.Errorf("payload: %w", err)
+		return "", nil, fmt.Errorf("payload: %w", err)
+	}
+	if pl.Header == nil {
+		return "", nil, fmt.Errorf("payload header missing")
 	}
 	chdr := &common.ChannelHeader{}
 	if err := proto.Unmarshal(pl.Header.ChannelHeader, chdr); err != nil {
-		return out, fmt.Errorf("channel header: %w", err)
+		return "", nil, fmt.Errorf("channel header: %w", err)
 	}
-	txID := chdr.TxId
 
 	tx := &peer.Transaction{}
 	if err := proto.Unmarshal(pl.Data, tx); err != nil {
-		return out, fmt.Errorf("transaction: %w", err)
+		return "", nil, fmt.Errorf("transaction: %w", err)
 	}
 
+	var actions []*peer.ChaincodeAction
 	for _, act := range tx.Actions {
 		cap := &peer.ChaincodeActionPayload{}
 		if err := proto.Unmarshal(act.Payload, cap); err != nil {
-			return out, fmt.Errorf("chaincode action payload: %w", err)
+			return "", nil, fmt.Errorf("chaincode action payload: %w", err)
+		}
+		if cap.Action == nil {
+			return "", nil, fmt.Errorf("chaincode endorsed action missing")
 		}
 		prp := &peer.ProposalResponsePayload{}
 		if err := proto.Unmarshal(cap.Action.ProposalResponsePayload, prp); err != nil {
-			return out, fmt.Errorf("proposal response payload: %w", err)
+			return "", nil, fmt.Errorf("proposal response payload: %w", err)
 		}
 
 		ccAct := &peer.ChaincodeAction{}
 		if err := proto.Unmarshal(prp.Extension, ccAct); err != nil {
-			return out, fmt.Errorf("chaincode action: %w", err)
-		}
-
-		txRWSet := &rwset.TxReadWriteSet{}
-		if err := proto.Unmarshal(ccAct.Results, txRWSet); err != nil {
-			return out, fmt.Errorf("rwset: %w", err)
-		}
-
-		for _, ns := range txRWSet.NsRwset {
-			kvs := &kvrwset.KVRWSet{}
-			if err := proto.Unmarshal(ns.Rwset, kvs); err != nil {
-				return out, fmt.Errorf("kvrwset: %w", err)
-			}
-			out = append(out, NsRwset{
-				Namespace: ns.Namespace,
-				Rwset:     kvs,
-				TxID:      txID,
-			})
+			return "", nil, fmt.Errorf("chaincode action: %w", err)
 		}
+		actions = append(actions, ccAct)
 	}
-	return out, nil
+	return chdr.TxId, actions, nil
 }
@@ -30,3 +30,101 @@ func TestParseEndorsed(t *testing.T) {
 	}
 	t.Log(e.String())
 }
+
+func TestBlockToStruct(t *testing.T) {
+	for _, f := range []string{"genesis.block", "channel.block", "endorsed.block"} {
+		t.Run(f, func(t *testing.T) {
+			b, err := os.ReadFile("./fixtures/" + f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			block := &common.Block{}
+			if err = proto.Unmarshal(b, block); err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := fabrictx.BlockToStruct(block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parsed.Data) != len(block.Data.Data) {
+				t.Fatalf("expected %d envelopes, got %d", len(block.Data.Data), len(parsed.Data))
+			}
+
+			pl := parsed.Data[0].Payload
+			switch common.HeaderType(pl.Header.ChannelHeader.Type) {
+			case common.HeaderType_CONFIG:
+				if pl.Config == nil {
+					t.Fatal("expected config to be decoded")
+				}
+				channel := pl.Config.Config.ChannelGroup
+				if _, ok := channel.Groups["Orderer"]; !ok {
+					t.Errorf("expected an orderer group, got %v", channel.Groups)
+				}
+				if _, ok := channel.Values["HashingAlgorithm"].Value.(*common.HashingAlgorithm); !ok {
+					t.Errorf("expected HashingAlgorithm to be decoded, got %T", channel.Values["HashingAlgorithm"].Value)
+				}
+				if parsed.Metadata.LastConfig != parsed.Header.Number {
+					t.Errorf("expected a config block to point to itself, got %d", parsed.Metadata.LastConfig)
+				}
+			case common.HeaderType_ENDORSER_TRANSACTION:
+				if pl.Transaction == nil || len(pl.Transaction.Actions) == 0 {
+					t.Fatal("expected transaction to be decoded")
+				}
+			default:
+				t.Fatalf("unexpected header type %d", pl.Header.ChannelHeader.Type)
+			}
+			t.Log(parsed.String())
+		})
+	}
+}
+
+func TestNewBlock(t *testing.T) {
+	b, err := os.ReadFile("./fixtures/endorsed.block")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := &common.Block{}
+	if err = proto.Unmarshal(b, orig); err != nil {
+		t.Fatal(err)
+	}
+	var envs []*common.Envelope
+	for _, d := range orig.Data.Data {
+		env := &common.Envelope{}
+		if err := proto.Unmarshal(d, env); err != nil {
+			t.Fatal(err)
+		}
+		envs = append(envs, env)
+	}
+
+	block, err := fabrictx.NewBlock(orig.Header.Number, orig.Header.PreviousHash, envs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(block.Header, orig.Header) {
+		t.Fatalf("expected header %v, got %v", orig.Header, block.Header)
+	}
+
+	submitter, err := fabrictx.SignerFromMSP("fixtures/user", "Org1MSP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fabrictx.SignBlock(block, submitter, 3); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := fabrictx.BlockToStruct(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Metadata.LastConfig != 3 || len(parsed.Metadata.Signatures) != 1 {
+		t.Fatalf("unexpected metadata %+v", parsed.Metadata)
+	}
+	md := &common.Metadata{}
+	if err := proto.Unmarshal(block.Metadata.Metadata[common.BlockMetadataIndex_SIGNATURES], md); err != nil {
+		t.Fatal(err)
+	}
+	sig := md.Signatures[0]
+	msg := append(append(append([]byte{}, md.Value...), sig.SignatureHeader...), fabrictx.BlockHeaderBytes(block.Header)...)
+	if err := submitter.Verify(msg, sig.Signature); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,142 @@
+package fabrictx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"google.golang.org/protobuf/proto"
+)
+
+// CollectionRwset is the read/write set of a private data collection. Like in Fabric, only the writes are part
+// of the private read/write set; the reads only end up hashed in the transaction.
+type CollectionRwset struct {
+	Collection string
+	Rwset      *kvrwset.KVRWSet
+}
+
+// CollectionHashedRwset is the part of a private data collection read/write set that ends up in the block:
+// the hashes of the keys and values, and the hash of the private read/write set itself.
+type CollectionHashedRwset struct {
+	Collection   string               `json:"collection"`
+	Rwset        *kvrwset.HashedRWSet `json:"rwset"`
+	PvtRwsetHash []byte               `json:"pvt_rwset_hash"`
+}
+
+// NewEndorserTxWithPrivateData creates a transaction like NewEndorserTransaction, with the hashed read/write
+// sets of the collections in the namespace of the chaincode. It also returns the private read/write set,
+// which must reach the peers of the collections before the transaction is committed, for instance through
+// endorsement. Peers that don't have it will only store the hashes.
+func NewEndorserTxWithPrivateData(channel, chaincode string, submitter Signer, endorsers []Signer, rwSet *kvrwset.KVRWSet, collections []CollectionRwset) (*common.Envelope, string, *rwset.TxPvtReadWriteSet, error) {
+	if rwSet == nil {
+		rwSet = &kvrwset.KVRWSet{}
+	}
+	hashed, pvt := PrivateRwsets(chaincode, collections)
+	nsRwset := []*rwset.NsReadWriteSet{
+		{
+			Namespace:             chaincode,
+			Rwset:                 mustMarshal(rwSet),
+			CollectionHashedRwset: hashed,
+		},
+	}
+	env, txID, err := NewEndorserTxWithNsRwSet(channel, chaincode, "1.0", submitter, endorsers, nsRwset)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return env, txID, pvt, nil
+}
+
+// PrivateRwsets returns the hashed read/write sets of the collections (for the public read/write set) and the
+// matching private read/write set. Collections are sorted by name, like the peer does.
+func PrivateRwsets(namespace string, collections []CollectionRwset) ([]*rwset.CollectionHashedReadWriteSet, *rwset.TxPvtReadWriteSet) {
+	collections = slices.Clone(collections)
+	slices.SortFunc(collections, func(a, b CollectionRwset) int { return strings.Compare(a.Collection, b.Collection) })
+
+	hashed := make([]*rwset.CollectionHashedReadWriteSet, len(collections))
+	nsPvt := &rwset.NsPvtReadWriteSet{Namespace: namespace}
+	for i, c := range collections {
+		pvtBytes := mustMarshal(&kvrwset.KVRWSet{Writes: c.Rwset.GetWrites()})
+		hashed[i] = &rwset.CollectionHashedReadWriteSet{
+			CollectionName: c.Collection,
+			HashedRwset:    mustMarshal(HashRwset(c.Rwset)),
+			PvtRwsetHash:   Hash(pvtBytes),
+		}
+		nsPvt.CollectionPvtRwset = append(nsPvt.CollectionPvtRwset, &rwset.CollectionPvtReadWriteSet{
+			CollectionName: c.Collection,
+			Rwset:          pvtBytes,
+		})
+	}
+	return hashed, &rwset.TxPvtReadWriteSet{
+		DataModel:  rwset.TxReadWriteSet_KV,
+		NsPvtRwset: []*rwset.NsPvtReadWriteSet{nsPvt},
+	}
+}
+
+// HashRwset returns the hashed version of a private read/write set: the keys and values are replaced by their
+// SHA-256 hash. The value hash of a delete is empty.
+func HashRwset(rw *kvrwset.KVRWSet) *kvrwset.HashedRWSet {
+	h := &kvrwset.HashedRWSet{}
+	for _, r := range rw.GetReads() {
+		h.HashedReads = append(h.HashedReads, &kvrwset.KVReadHash{KeyHash: Hash([]byte(r.Key)), Version: r.Version})
+	}
+	for _, w := range rw.GetWrites() {
+		hw := &kvrwset.KVWriteHash{KeyHash: Hash([]byte(w.Key)), IsDelete: w.IsDelete}
+		if !w.IsDelete {
+			hw.ValueHash = Hash(w.Value)
+		}
+		h.HashedWrites = append(h.HashedWrites, hw)
+	}
+	return h
+}
+
+// Hash is the hash function that Fabric uses for private data.
+func Hash(b []byte) []byte {
+	h := sha256.Sum256(b)
+	return h[:]
+}
+
+// PrivateWrites returns the private read/write set of a collection from the private data of a transaction,
+// after checking it against the hash in the hashed read/write set. It returns nil if the private data
+// of the collection is not available.
+func PrivateWrites(pvt *rwset.TxPvtReadWriteSet, namespace string, coll CollectionHashedRwset) (*kvrwset.KVRWSet, error) {
+	for _, ns := range pvt.GetNsPvtRwset() {
+		if ns.Namespace != namespace {
+			continue
+		}
+		for _, c := range ns.CollectionPvtRwset {
+			if c.CollectionName != coll.Collection {
+				continue
+			}
+			if !bytes.Equal(Hash(c.Rwset), coll.PvtRwsetHash) {
+				return nil, fmt.Errorf("private data of %s/%s does not match the hash in the transaction", namespace, coll.Collection)
+			}
+			kvs := &kvrwset.KVRWSet{}
+			if err := proto.Unmarshal(c.Rwset, kvs); err != nil {
+				return nil, fmt.Errorf("private kvrwset: %w", err)
+			}
+			return kvs, nil
+		}
+	}
+	return nil, nil
+}
+
+func parseCollections(ns *rwset.NsReadWriteSet) ([]CollectionHashedRwset, error) {
+	var out []CollectionHashedRwset
+	for _, c := range ns.CollectionHashedRwset {
+		h := &kvrwset.HashedRWSet{}
+		if err := proto.Unmarshal(c.HashedRwset, h); err != nil {
+			return nil, fmt.Errorf("hashed rwset of %s: %w", c.CollectionName, err)
+		}
+		out = append(out, CollectionHashedRwset{
+			Collection:   c.CollectionName,
+			Rwset:        h,
+			PvtRwsetHash: c.PvtRwsetHash,
+		})
+	}
+	return out, nil
+}
@@ -60,6 +60,23 @@ func NewProposal(submitter Signer, channel, chaincode string, args [][]byte) (*p
 	}, nil
 }
 
+// ProposalHeader returns the channel header of a signed proposal, which holds among others the channel and transaction ID.
+func ProposalHeader(prop *peer.SignedProposal) (*common.ChannelHeader, error) {
+	p := &peer.Proposal{}
+	if err := proto.Unmarshal(prop.ProposalBytes, p); err != nil {
+		return nil, fmt.Errorf("proposal: %w", err)
+	}
+	hdr := &common.Header{}
+	if err := proto.Unmarshal(p.Header, hdr); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	chdr := &common.ChannelHeader{}
+	if err := proto.Unmarshal(hdr.ChannelHeader, chdr); err != nil {
+		return nil, fmt.Errorf("channel header: %w", err)
+	}
+	return chdr, nil
+}
+
 // NewDeliverSeekInfo returns a signed envelope that can be used to subscribe to a peer
 func NewDeliverSeekInfo(submitter Signer, channel string, startBlock uint64) (*common.Envelope, error) {
 	signer, err := submitter.Serialize()
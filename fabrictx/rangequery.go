@@ -0,0 +1,149 @@
+package fabrictx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+)
+
+// DefaultMaxDegree is the peer's default for the number of range query results above which the reads are
+// summarized in a Merkle tree instead of being included in the read set.
+const DefaultMaxDegree = 50
+
+// NewRangeQueryInfo records a range query in the read set like a peer does, so that peers can detect phantom reads.
+// If the chaincode stopped iterating before the end, endKey is the last key that was read and exhausted is false.
+// The reads are included as is, unless there are more than maxDegree, in which case only a Merkle summary is included.
+func NewRangeQueryInfo(startKey, endKey string, exhausted bool, reads []*kvrwset.KVRead, maxDegree uint32) (*kvrwset.RangeQueryInfo, error) {
+	info := &kvrwset.RangeQueryInfo{
+		StartKey:     startKey,
+		EndKey:       endKey,
+		ItrExhausted: exhausted,
+	}
+	raw, summary, err := summarizeReads(reads, maxDegree)
+	if err != nil {
+		return nil, err
+	}
+	if summary != nil {
+		info.ReadsInfo = &kvrwset.RangeQueryInfo_ReadsMerkleHashes{ReadsMerkleHashes: summary}
+	} else {
+		info.ReadsInfo = &kvrwset.RangeQueryInfo_RawReads{RawReads: &kvrwset.QueryReads{KvReads: raw}}
+	}
+	return info, nil
+}
+
+// SameRangeQueryResults returns whether the results of a range query match the reads that were recorded in the read set.
+// Merkle summaries are recomputed with the same degree.
+func SameRangeQueryResults(info *kvrwset.RangeQueryInfo, results []*kvrwset.KVRead) (bool, error) {
+	switch r := info.ReadsInfo.(type) {
+	case *kvrwset.RangeQueryInfo_RawReads:
+		recorded := r.RawReads.GetKvReads()
+		return slices.EqualFunc(recorded, results, func(a, b *kvrwset.KVRead) bool {
+			return a.Key == b.Key && a.Version.GetBlockNum() == b.Version.GetBlockNum() && a.Version.GetTxNum() == b.Version.GetTxNum() && (a.Version == nil) == (b.Version == nil)
+		}), nil
+	case *kvrwset.RangeQueryInfo_ReadsMerkleHashes:
+		recorded := r.ReadsMerkleHashes
+		_, summary, err := summarizeReads(results, recorded.MaxDegree)
+		if err != nil {
+			return false, err
+		}
+		if summary == nil {
+			// not enough results anymore to need a summary
+			return false, nil
+		}
+		return summary.MaxLevel == recorded.MaxLevel && slices.EqualFunc(summary.MaxLevelHashes, recorded.MaxLevelHashes, bytes.Equal), nil
+	case nil:
+		return len(results) == 0, nil
+	}
+	return false, fmt.Errorf("unknown range query reads type %T", info.ReadsInfo)
+}
+
+// summarizeReads follows the RangeQueryResultsHelper of the peer: as soon as there are more than maxDegree
+// pending reads, they are hashed into a leaf of the Merkle tree. If that never happens, the raw reads are returned.
+func summarizeReads(reads []*kvrwset.KVRead, maxDegree uint32) ([]*kvrwset.KVRead, *kvrwset.QueryReadsMerkleSummary, error) {
+	if maxDegree < 2 {
+		return nil, nil, fmt.Errorf("maxDegree [%d] should not be less than 2 in the merkle tree", maxDegree)
+	}
+	mt := &merkleTree{tree: make(map[uint32][][]byte), maxLevel: 1, maxDegree: maxDegree}
+	var pending []*kvrwset.KVRead
+	for _, r := range reads {
+		pending = append(pending, r)
+		if uint32(len(pending)) > maxDegree {
+			mt.update(hashReads(pending))
+			pending = nil
+		}
+	}
+	if mt.isEmpty() {
+		return pending, nil, nil
+	}
+	if len(pending) != 0 {
+		mt.update(hashReads(pending))
+	}
+	mt.done()
+	return nil, &kvrwset.QueryReadsMerkleSummary{
+		MaxDegree:      mt.maxDegree,
+		MaxLevel:       mt.maxLevel,
+		MaxLevelHashes: mt.tree[mt.maxLevel],
+	}, nil
+}
+
+func hashReads(reads []*kvrwset.KVRead) []byte {
+	h := sha256.Sum256(mustMarshal(&kvrwset.QueryReads{KvReads: reads}))
+	return h[:]
+}
+
+// merkleTree only keeps the hashes that are not combined yet per level, starting at the leaves (level 1).
+type merkleTree struct {
+	tree      map[uint32][][]byte
+	maxLevel  uint32
+	maxDegree uint32
+}
+
+func (m *merkleTree) update(leaf []byte) {
+	m.tree[1] = append(m.tree[1], leaf)
+	level := uint32(1)
+	for uint32(len(m.tree[level])) > m.maxDegree {
+		combined := combineHashes(m.tree[level])
+		delete(m.tree, level)
+		level++
+		m.tree[level] = append(m.tree[level], combined)
+		if level > m.maxLevel {
+			m.maxLevel = level
+		}
+	}
+}
+
+func (m *merkleTree) done() {
+	var h []byte
+	for level := uint32(1); level < m.maxLevel; level++ {
+		hashes := m.tree[level]
+		switch len(hashes) {
+		case 0:
+			continue
+		case 1:
+			h = hashes[0]
+		default:
+			h = combineHashes(hashes)
+		}
+		delete(m.tree, level)
+		m.tree[level+1] = append(m.tree[level+1], h)
+	}
+
+	final := m.tree[m.maxLevel]
+	if uint32(len(final)) > m.maxDegree {
+		delete(m.tree, m.maxLevel)
+		m.maxLevel++
+		m.tree[m.maxLevel] = [][]byte{combineHashes(final)}
+	}
+}
+
+func (m *merkleTree) isEmpty() bool {
+	return m.maxLevel == 1 && len(m.tree[1]) == 0
+}
+
+func combineHashes(hashes [][]byte) []byte {
+	h := sha256.Sum256(bytes.Join(hashes, nil))
+	return h[:]
+}
@@ -0,0 +1,66 @@
+package fabrictx_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/arner/hacky-fabric/fabrictx"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestRangeQueryInfo compares the range query info with the one the peer would create.
+func TestRangeQueryInfo(t *testing.T) {
+	// the peer hashes the reads with SHA-256
+	hash := func(data []byte) ([]byte, error) {
+		sum := sha256.Sum256(data)
+		return sum[:], nil
+	}
+
+	for _, tc := range []struct{ reads, maxDegree int }{{0, 2}, {2, 2}, {3, 2}, {10, 2}, {50, 50}, {51, 50}, {3000, 50}} {
+		t.Run(fmt.Sprintf("%d reads, degree %d", tc.reads, tc.maxDegree), func(t *testing.T) {
+			reads := make([]*kvrwset.KVRead, tc.reads)
+			helper, err := rwsetutil.NewRangeQueryResultsHelper(true, uint32(tc.maxDegree), hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range reads {
+				reads[i] = &kvrwset.KVRead{Key: fmt.Sprintf("key%05d", i), Version: &kvrwset.Version{BlockNum: uint64(i), TxNum: 1}}
+				if err := helper.AddResult(reads[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			raw, summary, err := helper.Done()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := &kvrwset.RangeQueryInfo{StartKey: "key", EndKey: "kez", ItrExhausted: true}
+			if summary != nil {
+				expected.ReadsInfo = &kvrwset.RangeQueryInfo_ReadsMerkleHashes{ReadsMerkleHashes: summary}
+			} else {
+				expected.ReadsInfo = &kvrwset.RangeQueryInfo_RawReads{RawReads: &kvrwset.QueryReads{KvReads: raw}}
+			}
+
+			info, err := fabrictx.NewRangeQueryInfo("key", "kez", true, reads, uint32(tc.maxDegree))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(info, expected) {
+				t.Fatalf("expected %v, got %v", expected, info)
+			}
+
+			same, err := fabrictx.SameRangeQueryResults(info, reads)
+			if err != nil || !same {
+				t.Errorf("expected the same results (err: %v)", err)
+			}
+			if tc.reads > 0 {
+				same, err = fabrictx.SameRangeQueryResults(info, reads[1:])
+				if err != nil || same {
+					t.Errorf("expected different results with a missing read (err: %v)", err)
+				}
+			}
+		})
+	}
+}
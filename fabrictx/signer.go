@@ -0,0 +1,69 @@
+package fabrictx
+
+import (
+	"crypto"
+	"io"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"google.golang.org/protobuf/proto"
+)
+
+// SignFunc signs a message in the format that Fabric expects.
+type SignFunc func(msg []byte) ([]byte, error)
+
+// FuncSigner delegates signing to an external callback, which is responsible for hashing the message
+// and for returning a valid (low S) signature. Use NewRemoteSigner if the external party signs digests.
+type FuncSigner struct {
+	sign     SignFunc
+	signcert []byte
+	mspID    string
+}
+
+// NewFuncSigner returns a signer for the PEM encoded certificate that signs with the callback.
+func NewFuncSigner(mspID string, certPEM []byte, sign SignFunc) (*FuncSigner, error) {
+	if _, err := ParseCertificate(certPEM); err != nil {
+		return nil, err
+	}
+	return &FuncSigner{sign: sign, signcert: certPEM, mspID: mspID}, nil
+}
+
+func (s *FuncSigner) Sign(msg []byte) ([]byte, error) {
+	return s.sign(msg)
+}
+
+func (s *FuncSigner) Serialize() ([]byte, error) {
+	return proto.Marshal(&msp.SerializedIdentity{Mspid: s.mspID, IdBytes: s.signcert})
+}
+
+func (s *FuncSigner) MSPID() string {
+	return s.mspID
+}
+
+// DigestSignFunc signs a SHA-256 digest with a key that is kept elsewhere, for instance in a KMS or a remote
+// signing service, and returns an ASN.1 DER encoded ECDSA signature. For Ed25519 keys, it gets the message
+// instead of a digest and returns the raw signature.
+type DigestSignFunc func(digest []byte) ([]byte, error)
+
+// NewRemoteSigner returns a signer that hashes messages locally and lets the remote party sign the digest.
+// The public key is taken from the certificate, and the signatures are normalized to low S.
+func NewRemoteSigner(mspID string, certPEM []byte, sign DigestSignFunc) (*KeySigner, error) {
+	cert, err := ParseCertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(mspID, certPEM, remoteKey{pub: cert.PublicKey, sign: sign})
+}
+
+// remoteKey adapts a DigestSignFunc to a crypto.Signer.
+type remoteKey struct {
+	pub  crypto.PublicKey
+	sign DigestSignFunc
+}
+
+func (k remoteKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+func (k remoteKey) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	return k.sign(digest)
+}
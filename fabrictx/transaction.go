@@ -25,6 +25,12 @@ func NewEndorserTransaction(channel, chaincode string, submitter Signer, endorse
 }
 
 func NewEndorserTxWithNsRwSet(channel, chaincode, version string, submitter Signer, endorsers []Signer, nsRWSet []*rwset.NsReadWriteSet) (*common.Envelope, string, error) {
+	return NewEndorserTxWithEvent(channel, chaincode, version, submitter, endorsers, nsRWSet, nil)
+}
+
+// NewEndorserTxWithEvent creates a transaction like NewEndorserTxWithNsRwSet, with a chaincode event in the
+// chaincode action. The chaincode ID and transaction ID of the event are set like the peer does. event can be nil.
+func NewEndorserTxWithEvent(channel, chaincode, version string, submitter Signer, endorsers []Signer, nsRWSet []*rwset.NsReadWriteSet, event *peer.ChaincodeEvent) (*common.Envelope, string, error) {
 	// headers
 	ccID := &peer.ChaincodeID{Name: chaincode, Version: version}
 	creator, err := submitter.Serialize()
@@ -50,6 +56,16 @@ func NewEndorserTxWithNsRwSet(channel, chaincode, version string, submitter Sign
 		return nil, "", err
 	}
 
+	events := []byte{} // empty
+	if event != nil {
+		events = mustMarshal(&peer.ChaincodeEvent{
+			ChaincodeId: chaincode,
+			TxId:        txID,
+			EventName:   event.EventName,
+			Payload:     event.Payload,
+		})
+	}
+
 	// proposal response payload
 	proposalResponsePayload := mustMarshal(&peer.ProposalResponsePayload{
 		ProposalHash: pHash,
@@ -58,7 +74,7 @@ func NewEndorserTxWithNsRwSet(channel, chaincode, version string, submitter Sign
 			Results: mustMarshal(&rwset.TxReadWriteSet{
 				NsRwset: nsRWSet,
 			}),
-			Events:   []byte{}, // empty
+			Events:   events,
 			Response: &peer.Response{Status: 200, Message: "OK"},
 		}),
 	})
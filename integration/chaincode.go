@@ -2,10 +2,13 @@ package integration
 
 import (
 	"crypto/x509"
+	"errors"
 
+	"github.com/arner/hacky-fabric/fabrictx"
 	"github.com/arner/hacky-fabric/storage"
 	"github.com/hyperledger/fabric-chaincode-go/v2/pkg/cid"
 	"github.com/hyperledger/fabric-chaincode-go/v2/shim"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/queryresult"
 	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
 	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -43,12 +46,49 @@ func (t TransactionContext) Rwset() *kvrwset.KVRWSet {
 	return Rwset(t.Stub.Result())
 }
 
+func (t TransactionContext) PrivateRwsets() []fabrictx.CollectionRwset {
+	return PrivateRwsets(t.Stub.Result())
+}
+
 func Rwset(res storage.ReadWriteSet) *kvrwset.KVRWSet {
 	rws := &kvrwset.KVRWSet{
-		Reads:  make([]*kvrwset.KVRead, len(res.Reads)),
-		Writes: make([]*kvrwset.KVWrite, len(res.Writes)),
+		Reads:  kvReads(res.Reads),
+		Writes: kvWrites(res.Writes),
+	}
+	for _, q := range res.RangeQueries {
+		info, err := fabrictx.NewRangeQueryInfo(q.StartKey, q.EndKey, q.ItrExhausted, kvReads(q.Reads), fabrictx.DefaultMaxDegree)
+		if err != nil {
+			// only happens with an invalid max degree
+			panic(err)
+		}
+		rws.RangeQueriesInfo = append(rws.RangeQueriesInfo, info)
 	}
-	for i, r := range res.Reads {
+	return rws
+}
+
+// Event returns the chaincode event that was set during the simulation, or nil.
+func Event(res storage.ReadWriteSet) *peer.ChaincodeEvent {
+	if res.Event == nil {
+		return nil
+	}
+	return &peer.ChaincodeEvent{ChaincodeId: res.Event.Namespace, EventName: res.Event.Name, Payload: res.Event.Payload}
+}
+
+// PrivateRwsets returns the read/write sets of the private data collections, see fabrictx.NewEndorserTxWithPrivateData.
+func PrivateRwsets(res storage.ReadWriteSet) []fabrictx.CollectionRwset {
+	out := make([]fabrictx.CollectionRwset, len(res.Collections))
+	for i, c := range res.Collections {
+		out[i] = fabrictx.CollectionRwset{
+			Collection: c.Collection,
+			Rwset:      &kvrwset.KVRWSet{Reads: kvReads(c.Reads), Writes: kvWrites(c.Writes)},
+		}
+	}
+	return out
+}
+
+func kvReads(reads []storage.KVRead) []*kvrwset.KVRead {
+	out := make([]*kvrwset.KVRead, len(reads))
+	for i, r := range reads {
 		read := &kvrwset.KVRead{Key: r.Key}
 		if r.Version != nil {
 			read.Version = &kvrwset.Version{
@@ -56,16 +96,21 @@ func Rwset(res storage.ReadWriteSet) *kvrwset.KVRWSet {
 				TxNum:    uint64(r.Version.TxNum),
 			}
 		}
-		rws.Reads[i] = read
+		out[i] = read
 	}
-	for i, r := range res.Writes {
-		rws.Writes[i] = &kvrwset.KVWrite{
-			Key:      r.Key,
-			IsDelete: r.IsDelete,
-			Value:    r.Value,
+	return out
+}
+
+func kvWrites(writes []storage.KVWrite) []*kvrwset.KVWrite {
+	out := make([]*kvrwset.KVWrite, len(writes))
+	for i, w := range writes {
+		out[i] = &kvrwset.KVWrite{
+			Key:      w.Key,
+			IsDelete: w.IsDelete,
+			Value:    w.Value,
 		}
 	}
-	return rws
+	return out
 }
 
 type FabricStub struct {
@@ -73,6 +118,63 @@ type FabricStub struct {
 	UnimplementedStub
 }
 
+// GetStateByRange implements shim.ChaincodeStubInterface.
+func (s *FabricStub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	it, err := s.SimulationStore.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	return &stateIterator{RangeIterator: it, namespace: s.Namespace()}, nil
+}
+
+// GetStateByRangeWithPagination implements shim.ChaincodeStubInterface.
+func (s *FabricStub) GetStateByRangeWithPagination(startKey string, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	it, err := s.SimulationStore.GetStateByRangeWithPagination(startKey, endKey, pageSize, bookmark)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &stateIterator{RangeIterator: it, namespace: s.Namespace()}, queryMetadata(it), nil
+}
+
+// GetStateByPartialCompositeKey implements shim.ChaincodeStubInterface.
+func (s *FabricStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	it, err := s.SimulationStore.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return &stateIterator{RangeIterator: it, namespace: s.Namespace()}, nil
+}
+
+// GetStateByPartialCompositeKeyWithPagination implements shim.ChaincodeStubInterface.
+func (s *FabricStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	it, err := s.SimulationStore.GetStateByPartialCompositeKeyWithPagination(objectType, keys, pageSize, bookmark)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &stateIterator{RangeIterator: it, namespace: s.Namespace()}, queryMetadata(it), nil
+}
+
+func queryMetadata(it *storage.RangeIterator) *peer.QueryResponseMetadata {
+	return &peer.QueryResponseMetadata{FetchedRecordsCount: it.FetchedRecords(), Bookmark: it.Bookmark()}
+}
+
+// stateIterator implements shim.StateQueryIteratorInterface.
+type stateIterator struct {
+	*storage.RangeIterator
+	namespace string
+}
+
+func (it *stateIterator) Next() (*queryresult.KV, error) {
+	rec, err := it.RangeIterator.Next()
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, errors.New("no more results")
+	}
+	return &queryresult.KV{Namespace: it.namespace, Key: rec.Key, Value: rec.Value}, nil
+}
+
 // GetClientIdentity implements contractapi.TransactionContextInterface.
 func (t TransactionContext) GetClientIdentity() cid.ClientIdentity {
 	return t.ClientIdentity
@@ -199,22 +301,9 @@ func (s UnimplementedStub) GetMultipleStates(keys ...string) ([][]byte, error) {
 	panic("unimplemented")
 }
 
-// GetStateByRange implements shim.ChaincodeStubInterface.
-func (s UnimplementedStub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
-	panic("unimplemented")
-}
-
-// GetStateByRangeWithPagination implements shim.ChaincodeStubInterface.
-func (s UnimplementedStub) GetStateByRangeWithPagination(startKey string, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
-	panic("unimplemented")
-}
-
 // Events
 
-// SetEvent implements shim.ChaincodeStubInterface.
-func (s UnimplementedStub) SetEvent(name string, payload []byte) error {
-	panic("unimplemented")
-}
+// SetEvent is implemented by storage.SimulationStore.
 
 // Rich queries (db specific)
 
@@ -229,34 +318,16 @@ func (s UnimplementedStub) GetQueryResultWithPagination(query string, pageSize i
 }
 
 // Composite keys
-// (!) Fabric uses non-utf8 characters which is not supported by Postgres.
+// The storage package escapes the 0x00 separators, because Postgres doesn't support them in text.
 
 // CreateCompositeKey implements shim.ChaincodeStubInterface.
 func (s UnimplementedStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
-	return shim.CreateCompositeKey(objectType, attributes)
+	return storage.CreateCompositeKey(objectType, attributes)
 }
 
-// SplitCompositeKey source: github.com/hyperledger/fabric-chaincode-go/shim/shim.go
+// SplitCompositeKey implements shim.ChaincodeStubInterface.
 func (s UnimplementedStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
-	componentIndex := 1
-	components := []string{}
-	for i := 1; i < len(compositeKey); i++ {
-		if compositeKey[i] == 0 { // U+0000
-			components = append(components, compositeKey[componentIndex:i])
-			componentIndex = i + 1
-		}
-	}
-	return components[0], components[1:], nil
-}
-
-// GetStateByPartialCompositeKey implements shim.ChaincodeStubInterface.
-func (s UnimplementedStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
-	panic("unimplemented")
-}
-
-// GetStateByPartialCompositeKeyWithPagination implements shim.ChaincodeStubInterface.
-func (s UnimplementedStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
-	panic("unimplemented")
+	return storage.SplitCompositeKey(compositeKey)
 }
 
 // GetAllStatesCompositeKeyWithPagination implements shim.ChaincodeStubInterface.
@@ -290,20 +361,7 @@ func (s UnimplementedStub) FinishWriteBatch() error {
 
 // --------- Private data ----------
 
-// GetPrivateData implements shim.ChaincodeStubInterface.
-func (s UnimplementedStub) GetPrivateData(collection string, key string) ([]byte, error) {
-	panic("unimplemented")
-}
-
-// PutPrivateData implements shim.ChaincodeStubInterface.
-func (s UnimplementedStub) PutPrivateData(collection string, key string, value []byte) error {
-	panic("unimplemented")
-}
-
-// DelPrivateData implements shim.ChaincodeStubInterface.
-func (s UnimplementedStub) DelPrivateData(collection string, key string) error {
-	panic("unimplemented")
-}
+// GetPrivateData, GetPrivateDataHash, PutPrivateData and DelPrivateData are implemented by storage.SimulationStore.
 
 // PurgePrivateData implements shim.ChaincodeStubInterface.
 func (s UnimplementedStub) PurgePrivateData(collection string, key string) error {
@@ -330,11 +388,6 @@ func (s UnimplementedStub) GetPrivateDataQueryResult(collection string, query st
 	panic("unimplemented")
 }
 
-// GetPrivateDataHash implements shim.ChaincodeStubInterface.
-func (s UnimplementedStub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
-	panic("unimplemented")
-}
-
 // GetPrivateDataValidationParameter implements shim.ChaincodeStubInterface.
 func (s UnimplementedStub) GetPrivateDataValidationParameter(collection string, key string) ([]byte, error) {
 	panic("unimplemented")
@@ -4,7 +4,6 @@ import (
 	"crypto/rand"
 	"encoding/json"
 	"testing"
-	"time"
 
 	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
 	_ "modernc.org/sqlite"
@@ -43,11 +42,13 @@ func TestChaincode(t *testing.T) {
 		t.Fatal(err)
 	}
 	rws = txc.Rwset()
-	_, err = c.EndorseAndSubmit(Channel, Namespace, rws)
+	id, err := c.EndorseAndSubmit(Channel, Namespace, rws)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	if st := waitForTx(t, c, id); !st.Valid() {
+		t.Fatalf("create asset: %s", st)
 	}
-	time.Sleep(2200 * time.Millisecond) // wait till committed
 
 	// tx: read asset
 	txc = newTx(t, executor)
@@ -70,7 +71,35 @@ func TestChaincode(t *testing.T) {
 		t.Errorf("%+v != %+v (json err: %s)", asset, expected, err)
 	}
 
-	// TODO: range query
+	// tx: range query
+	txc = newTx(t, executor)
+	assets, err := cc.GetAllAssets(txc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err = c.Query("mychannel", "basic", "GetAllAssets", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var peerAssets []*chaincode.Asset
+	if err := json.Unmarshal(a.Response.Payload, &peerAssets); err != nil {
+		t.Fatal(err)
+	}
+	if len(assets) != len(peerAssets) {
+		t.Errorf("expected %d assets, got %d", len(peerAssets), len(assets))
+	}
+	// the peer validates the range query for phantom reads
+	rws = txc.Rwset()
+	if len(rws.RangeQueriesInfo) != 1 {
+		t.Fatalf("expected a range query in the read set, got %d", len(rws.RangeQueriesInfo))
+	}
+	id, err = c.EndorseAndSubmit(Channel, Namespace, rws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := waitForTx(t, c, id); !st.Valid() {
+		t.Errorf("range query: %s", st)
+	}
 }
 
 func newTx(t *testing.T, ex *ChaincodeExecutor) *TransactionContext {
@@ -11,6 +11,7 @@ import (
 	"github.com/arner/hacky-fabric/fabrictx"
 	"github.com/arner/hacky-fabric/storage"
 
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset"
 	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
 	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
 	"google.golang.org/protobuf/proto"
@@ -19,6 +20,7 @@ import (
 type Client struct {
 	Peer      *comm.Peer
 	Orderer   *comm.Orderer
+	Gateway   *comm.Gateway
 	Committer *committer.Committer
 	DB        *storage.VersionedDB
 	Submitter fabrictx.Signer
@@ -26,7 +28,7 @@ type Client struct {
 }
 
 // NewClientForFabricSamples returns a client for integration testing with access to a peer, orderer and local committer.
-// It follows the directory structure of a fabric samples test network.
+// It follows the directory structure of a fabric samples test network. The committer follows the channel of the db.
 func NewClientForFabricSamples(ctx context.Context, samplesDir string, db *storage.VersionedDB, logger committer.Logger) (*Client, error) {
 	org1 := path.Join(samplesDir, "test-network", "organizations", "peerOrganizations", "org1.example.com")
 	org2 := path.Join(samplesDir, "test-network", "organizations", "peerOrganizations", "org2.example.com")
@@ -42,6 +44,12 @@ func NewClientForFabricSamples(ctx context.Context, samplesDir string, db *stora
 		return nil, err
 	}
 
+	// gateway (on the same peer)
+	gw, err := comm.NewGateway("peer0.org1.example.com:7051", peerTLS)
+	if err != nil {
+		return nil, err
+	}
+
 	// orderer
 	pem, err := os.ReadFile(path.Join(ordererOrg, "tlsca", "tlsca.example.com-cert.pem"))
 	if err != nil {
@@ -67,7 +75,7 @@ func NewClientForFabricSamples(ctx context.Context, samplesDir string, db *stora
 	}
 
 	// committer
-	committer, err := committer.NewCommitter(ctx, db, "mychannel", peer, submitter, logger)
+	committer, err := committer.NewCommitter(ctx, db, db.Channel(), peer, submitter, logger)
 	if err != nil {
 		return nil, err
 	}
@@ -75,6 +83,7 @@ func NewClientForFabricSamples(ctx context.Context, samplesDir string, db *stora
 	return &Client{
 		Peer:      peer,
 		Orderer:   orderer,
+		Gateway:   gw,
 		DB:        db,
 		Committer: committer,
 		Submitter: submitter,
@@ -117,11 +126,46 @@ func (c Client) EndorseAndSubmit(channel, namespace string, rw *kvrwset.KVRWSet)
 	return id, nil
 }
 
+// EndorseAndSubmitResult creates a transaction out of the result of a simulation, including the hashes of the
+// private data and the chaincode event, endorses it with the configured endorser keys and submits it.
+// The private data itself is not distributed to the peers.
+func (c Client) EndorseAndSubmitResult(channel, namespace string, res storage.ReadWriteSet) (string, error) {
+	rws, err := proto.Marshal(Rwset(res))
+	if err != nil {
+		return "", err
+	}
+	hashed, _ := fabrictx.PrivateRwsets(namespace, PrivateRwsets(res))
+	nsRwset := []*rwset.NsReadWriteSet{{
+		Namespace:             namespace,
+		Rwset:                 rws,
+		CollectionHashedRwset: hashed,
+	}}
+	tx, id, err := fabrictx.NewEndorserTxWithEvent(channel, namespace, "1.0", c.Submitter, c.Endorsers, nsRwset, Event(res))
+	if err != nil {
+		return "", err
+	}
+	if err := c.Orderer.Broadcast(tx); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// SubmitAndWait creates and endorses a transaction like EndorseAndSubmit, but submits it through the gateway
+// and waits until it is committed.
+func (c Client) SubmitAndWait(ctx context.Context, channel, namespace string, rw *kvrwset.KVRWSet) (string, peer.TxValidationCode, error) {
+	tx, _, err := fabrictx.NewEndorserTransaction(channel, namespace, c.Submitter, c.Endorsers, rw)
+	if err != nil {
+		return "", 0, err
+	}
+	return c.Gateway.SubmitAndWait(ctx, c.Submitter, tx)
+}
+
 func (c Client) Close() error {
 	c.Committer.Stop()
 
 	return errors.Join(
 		c.Orderer.Close(),
+		c.Gateway.Close(),
 		c.Peer.Close(),
 	)
 }
@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/arner/hacky-fabric/committer"
 	"github.com/arner/hacky-fabric/storage"
 	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
 	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
@@ -146,8 +147,8 @@ func TestClientTransactions(t *testing.T) {
 	ids := []string{}
 	for _, tc := range tests {
 		t.Run("submit_"+tc.name, func(t *testing.T) {
-			if tc.waitForPropagation {
-				time.Sleep(2200 * time.Millisecond)
+			if tc.waitForPropagation && len(ids) > 0 {
+				waitForTx(t, c, ids[len(ids)-1])
 			}
 			id, err := c.EndorseAndSubmit(Channel, Namespace, tc.rw)
 			if err != nil {
@@ -158,7 +159,7 @@ func TestClientTransactions(t *testing.T) {
 
 	}
 	// wait until last transaction is propagated and processed, and validate their status
-	time.Sleep(2200 * time.Millisecond)
+	waitForTx(t, c, ids[len(ids)-1])
 	for i, tc := range tests {
 		t.Run("validate_"+tc.name, func(t *testing.T) {
 			validate(t, c, ids[i], tc.expected)
@@ -227,6 +228,20 @@ func validate(t *testing.T, c *Client, id string, expectedState peer.TxValidatio
 	if info.ValidationCode != int32(expectedState) {
 		t.Errorf("expected tx %s to be %s, got validation code %s", id, peer.TxValidationCode_name[int32(expectedState)], peer.TxValidationCode_name[info.ValidationCode])
 	}
+	if st := waitForTx(t, c, id); st.Code != expectedState {
+		t.Errorf("expected committer to report tx %s as %s, got %s", id, expectedState, st.Code)
+	}
+}
+
+// waitForTx blocks until the committer has processed the transaction.
+func waitForTx(t *testing.T, c *Client, id string) committer.TxStatus {
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+	st, err := c.Committer.WaitForTx(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
 }
 
 func checkHistory(t *testing.T, db *storage.VersionedDB, key string, expectedLen int) {
@@ -264,3 +279,35 @@ func checkValue(t *testing.T, db *storage.VersionedDB, key string, expectedVal [
 		t.Errorf("key %s: %s != %s", key, string(k.Value), string(expectedVal))
 	}
 }
+
+// TestGatewaySubmit requires Fabric to be running (see readme)
+func TestGatewaySubmit(t *testing.T) {
+	c := createAndStartClient(t)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+
+	key := rand.Text()
+	_, code, err := c.SubmitAndWait(ctx, Channel, Namespace, &kvrwset.KVRWSet{
+		Writes: []*kvrwset.KVWrite{{Key: key, Value: []byte(`hello gateway`)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != peer.TxValidationCode_VALID {
+		t.Errorf("expected VALID, got %s", code)
+	}
+
+	// reading the key as if it doesn't exist is a conflict now
+	_, code, err = c.SubmitAndWait(ctx, Channel, Namespace, &kvrwset.KVRWSet{
+		Reads:  []*kvrwset.KVRead{{Key: key}},
+		Writes: []*kvrwset.KVWrite{{Key: key, Value: []byte(`should fail`)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != peer.TxValidationCode_MVCC_READ_CONFLICT {
+		t.Errorf("expected MVCC_READ_CONFLICT, got %s", code)
+	}
+}
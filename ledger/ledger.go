@@ -0,0 +1,378 @@
+// Package ledger is a single node Fabric network in a process. It orders transactions into blocks, validates
+// them like a peer (creator signature, endorsement policy, MVCC) and commits them to a storage.VersionedDB,
+// which makes it a fast and deterministic environment for chaincode logic and clients.
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arner/hacky-fabric/comm"
+	"github.com/arner/hacky-fabric/committer"
+	"github.com/arner/hacky-fabric/config"
+	"github.com/arner/hacky-fabric/fabrictx"
+	"github.com/arner/hacky-fabric/policy"
+	"github.com/arner/hacky-fabric/storage"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+// Ledger orders, validates and commits the transactions of a single channel.
+type Ledger struct {
+	channel      string
+	db           *storage.VersionedDB
+	committer    *committer.Committer
+	signer       fabrictx.Signer
+	log          committer.Logger
+	ctx          context.Context
+	msps         map[string]*fabrictx.MSPVerifier
+	policies     map[string]*policy.Policy
+	batchSize    int
+	batchTimeout time.Duration
+
+	cutMu sync.Mutex          // held while a block is validated and committed, without blocking readers and Submit
+	txIDs map[string]struct{} // of the committed transactions, guarded by cutMu
+
+	mu       sync.Mutex
+	blocks   []*peer.BlockAndPrivateData
+	pending  []pendingTx // until the block with the transactions is committed
+	timer    *time.Timer
+	newBlock chan struct{} // closed and replaced when a block is added
+	closed   chan struct{}
+}
+
+type pendingTx struct {
+	env  *common.Envelope
+	txID string
+	pvt  *rwset.TxPvtReadWriteSet
+}
+
+type Option func(*Ledger)
+
+// WithEndorsementPolicy sets the endorsement policy of a chaincode. Chaincodes without a policy require
+// endorsements of a majority of the application organizations (MAJORITY Endorsement). Principals are matched
+// against the MSPs of the channel.
+func WithEndorsementPolicy(namespace string, p *policy.Policy) Option {
+	return func(l *Ledger) {
+		l.policies[namespace] = p
+	}
+}
+
+// WithBatchSize sets the maximum number of transactions in a block and the time after which a block is cut with
+// fewer transactions. The default is the BatchSize and BatchTimeout of the channel config.
+func WithBatchSize(maxMessageCount int, timeout time.Duration) Option {
+	return func(l *Ledger) {
+		l.batchSize = maxMessageCount
+		l.batchTimeout = timeout
+	}
+}
+
+// New returns a ledger for the channel of the genesis block (see config.GenesisBlock), which commits to an empty
+// database. The orderer signs the blocks.
+func New(ctx context.Context, db *storage.VersionedDB, genesis *common.Block, orderer fabrictx.Signer, logger committer.Logger, opts ...Option) (*Ledger, error) {
+	last, err := db.LastProcessedBlock()
+	if err != nil {
+		return nil, err
+	}
+	if last > 0 {
+		return nil, fmt.Errorf("database is not empty: it has blocks up to %d", last)
+	}
+	conf, err := config.FromBlock(genesis)
+	if err != nil {
+		return nil, fmt.Errorf("genesis block: %w", err)
+	}
+	if conf.Application == nil || len(conf.Application.Organizations) == 0 {
+		return nil, errors.New("genesis block has no application organizations")
+	}
+	msps, err := conf.MSPVerifiers()
+	if err != nil {
+		return nil, err
+	}
+	defaultPolicy, err := majorityPolicy(conf.Application.Organizations)
+	if err != nil {
+		return nil, err
+	}
+	c, err := committer.NewCommitter(ctx, db, conf.ChannelID, nil, nil, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Ledger{
+		channel:   conf.ChannelID,
+		db:        db,
+		committer: c,
+		signer:    orderer,
+		log:       logger,
+		ctx:       ctx,
+		msps:      msps,
+		policies:  map[string]*policy.Policy{"": defaultPolicy},
+		batchSize: 10,
+		txIDs:     map[string]struct{}{},
+		newBlock:  make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+	if conf.Orderer != nil {
+		if n := conf.Orderer.BatchSize.MaxMessageCount; n > 0 {
+			l.batchSize = int(n)
+		}
+		l.batchTimeout, _ = time.ParseDuration(conf.Orderer.BatchTimeout)
+	}
+	if l.batchTimeout == 0 {
+		l.batchTimeout = 2 * time.Second
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	// the policies are evaluated against the MSPs of the channel, without changing the policies of the options
+	for ns, p := range l.policies {
+		if p.Matcher == nil {
+			l.policies[ns] = &policy.Policy{Envelope: p.Envelope, Matcher: policy.MSPMatcher{MSPs: msps}}
+		}
+	}
+
+	// the genesis block is not committed to the database, like the committer does
+	l.blocks = append(l.blocks, &peer.BlockAndPrivateData{Block: genesis})
+	return l, nil
+}
+
+// majorityPolicy returns the signature policy that is equivalent to MAJORITY Endorsement with the sample
+// configtx.yaml: peers of more than half of the organizations.
+func majorityPolicy(orgs map[string]config.Organization) (*policy.Policy, error) {
+	var principals []string
+	for _, name := range slices.Sorted(maps.Keys(orgs)) {
+		principals = append(principals, fmt.Sprintf("'%s.peer'", orgs[name].MSP.ID))
+	}
+	return policy.Parse(fmt.Sprintf("OutOf(%d, %s)", len(principals)/2+1, strings.Join(principals, ", ")))
+}
+
+// Committer returns the committer of the ledger, to wait for transactions or follow chaincode events.
+func (l *Ledger) Committer() *committer.Committer {
+	return l.committer
+}
+
+// Submit adds an endorsed transaction (from fabrictx.NewEndorserTransaction) to the next block.
+func (l *Ledger) Submit(env *common.Envelope) error {
+	return l.SubmitWithPrivateData(env, nil)
+}
+
+// SubmitWithPrivateData adds an endorsed transaction with private data (from fabrictx.NewEndorserTxWithPrivateData)
+// to the next block. The private data is committed if the transaction is valid.
+func (l *Ledger) SubmitWithPrivateData(env *common.Envelope, pvt *rwset.TxPvtReadWriteSet) error {
+	chdr, err := fabrictx.ChannelHeader(env)
+	if err != nil {
+		return err
+	}
+	if chdr.ChannelId != l.channel {
+		return fmt.Errorf("transaction for channel %s, expected %s", chdr.ChannelId, l.channel)
+	}
+	if common.HeaderType(chdr.Type) != common.HeaderType_ENDORSER_TRANSACTION {
+		return fmt.Errorf("unsupported transaction type %s", common.HeaderType(chdr.Type))
+	}
+
+	l.mu.Lock()
+	select {
+	case <-l.closed:
+		l.mu.Unlock()
+		return errors.New("ledger closed")
+	default:
+	}
+	l.pending = append(l.pending, pendingTx{env: env, txID: chdr.TxId, pvt: pvt})
+	full := len(l.pending) >= l.batchSize
+	if !full {
+		l.scheduleLocked()
+	}
+	l.mu.Unlock()
+
+	if full {
+		return l.Cut()
+	}
+	return nil
+}
+
+// scheduleLocked starts the batch timer if there are pending transactions. The timer retries a block that
+// failed to commit.
+func (l *Ledger) scheduleLocked() {
+	if l.timer != nil || len(l.pending) == 0 {
+		return
+	}
+	select {
+	case <-l.closed:
+		return
+	default:
+	}
+	l.timer = time.AfterFunc(l.batchTimeout, func() {
+		if err := l.Cut(); err != nil {
+			l.log.Printf("cut block: %s", err)
+		}
+	})
+}
+
+// SubmitAndWait submits a transaction, cuts the block and returns the status of the transaction.
+func (l *Ledger) SubmitAndWait(ctx context.Context, env *common.Envelope) (committer.TxStatus, error) {
+	chdr, err := fabrictx.ChannelHeader(env)
+	if err != nil {
+		return committer.TxStatus{}, err
+	}
+	if err := l.Submit(env); err != nil {
+		return committer.TxStatus{}, err
+	}
+	if err := l.Cut(); err != nil {
+		return committer.TxStatus{}, err
+	}
+	return l.committer.WaitForTx(ctx, chdr.TxId)
+}
+
+// Cut creates a block with the pending transactions, if there are any, and commits it. If the block can't be
+// committed, the transactions stay pending and are retried with the next block. Cut fails after Close.
+func (l *Ledger) Cut() error {
+	l.cutMu.Lock()
+	defer l.cutMu.Unlock()
+
+	l.mu.Lock()
+	select {
+	case <-l.closed:
+		l.mu.Unlock()
+		return errors.New("ledger closed")
+	default:
+	}
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	pending := l.pending[:min(len(l.pending), l.batchSize)]
+	prev := l.blocks[len(l.blocks)-1].Block
+	l.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	bpd, inBlock, err := l.commit(prev, pending)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		l.scheduleLocked()
+		return err
+	}
+	for id := range inBlock {
+		l.txIDs[id] = struct{}{}
+	}
+	l.pending = l.pending[len(pending):]
+	l.blocks = append(l.blocks, bpd)
+	close(l.newBlock)
+	l.newBlock = make(chan struct{})
+	l.scheduleLocked()
+	return nil
+}
+
+// commit creates the block after prev with the transactions, validates it and commits it to the database. It
+// returns the block and the IDs of the transactions in it. Only Cut calls it, so the transaction IDs don't change.
+func (l *Ledger) commit(prev *common.Block, pending []pendingTx) (*peer.BlockAndPrivateData, map[string]struct{}, error) {
+	envs := make([]*common.Envelope, len(pending))
+	for i, p := range pending {
+		envs[i] = p.env
+	}
+	block, err := fabrictx.NewBlock(prev.Header.Number+1, fabrictx.BlockHeaderHash(prev.Header), envs...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// signatures and policies first, then MVCC for the transactions that are still valid
+	filter := make([]byte, len(pending))
+	inBlock := map[string]struct{}{}
+	for i, p := range pending {
+		filter[i] = byte(l.validateTx(p, inBlock))
+	}
+	block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] = filter
+	codes, err := committer.ValidateBlock(l.db, block, l.log)
+	if err != nil {
+		return nil, nil, fmt.Errorf("validate block %d: %w", block.Header.Number, err)
+	}
+	bpd := &peer.BlockAndPrivateData{Block: block, PrivateDataMap: map[uint64]*rwset.TxPvtReadWriteSet{}}
+	for i, code := range codes {
+		filter[i] = byte(code)
+		if code == peer.TxValidationCode_VALID && pending[i].pvt != nil {
+			bpd.PrivateDataMap[uint64(i)] = pending[i].pvt
+		}
+	}
+	if err := fabrictx.SignBlock(block, l.signer, 0); err != nil {
+		return nil, nil, err
+	}
+
+	if err := l.committer.ProcessBlock(&peer.DeliverResponse_BlockAndPrivateData{BlockAndPrivateData: bpd}); err != nil {
+		return nil, nil, fmt.Errorf("commit block %d: %w", block.Header.Number, err)
+	}
+	return bpd, inBlock, nil
+}
+
+// Height returns the number of blocks, including the genesis block.
+func (l *Ledger) Height() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return uint64(len(l.blocks))
+}
+
+// Block returns a committed block, with the TRANSACTIONS_FILTER set.
+func (l *Ledger) Block(num uint64) (*common.Block, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if num >= uint64(len(l.blocks)) {
+		return nil, false
+	}
+	return l.blocks[num].Block, true
+}
+
+// SubscribeBlocks invokes the handler for every block from the starting block, including the blocks that are
+// committed later, like comm.Peer.SubscribeBlocks. It returns when the handler returns an error, or nil when the
+// ledger is closed or its context is done.
+func (l *Ledger) SubscribeBlocks(startBlock uint64, handle comm.BlockHandler) error {
+	for num := startBlock; ; num++ {
+		l.mu.Lock()
+		for num >= uint64(len(l.blocks)) {
+			next := l.newBlock
+			l.mu.Unlock()
+			select {
+			case <-next:
+			case <-l.closed:
+				return nil
+			case <-l.ctx.Done():
+				return nil
+			}
+			l.mu.Lock()
+		}
+		b := l.blocks[num]
+		l.mu.Unlock()
+
+		if err := handle(&peer.DeliverResponse_BlockAndPrivateData{BlockAndPrivateData: b}); err != nil {
+			return fmt.Errorf("handler: %w", err)
+		}
+	}
+}
+
+// Close stops cutting blocks and ends the subscriptions. It waits until a block that is being cut is committed.
+// Pending transactions are dropped.
+func (l *Ledger) Close() {
+	l.cutMu.Lock()
+	defer l.cutMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-l.closed:
+		return
+	default:
+	}
+	close(l.closed)
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	l.committer.Stop()
+}
@@ -0,0 +1,332 @@
+package ledger_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/arner/hacky-fabric/config"
+	"github.com/arner/hacky-fabric/cryptogen"
+	"github.com/arner/hacky-fabric/fabrictx"
+	"github.com/arner/hacky-fabric/integration"
+	"github.com/arner/hacky-fabric/ledger"
+	"github.com/arner/hacky-fabric/policy"
+	"github.com/arner/hacky-fabric/storage"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+	_ "modernc.org/sqlite"
+)
+
+type network struct {
+	ledger    *ledger.Ledger
+	db        *storage.VersionedDB
+	sqlDB     *sql.DB
+	user      fabrictx.Signer
+	endorsers []fabrictx.Signer
+	outsider  fabrictx.Signer
+}
+
+func newNetwork(t *testing.T, opts ...ledger.Option) *network {
+	var orgs []*cryptogen.Org
+	for _, spec := range []cryptogen.OrgSpec{
+		{Domain: "org1.example.com", MSPID: "Org1MSP", Peers: 1, Users: 1},
+		{Domain: "org2.example.com", MSPID: "Org2MSP", Peers: 1},
+		{Domain: "org3.example.com", MSPID: "Org3MSP", Peers: 1}, // not a member of the channel
+		{Domain: "example.com", MSPID: "OrdererMSP", Orderers: 1},
+	} {
+		org, err := cryptogen.NewOrg(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		orgs = append(orgs, org)
+	}
+	signer := func(id *cryptogen.Identity) fabrictx.Signer {
+		s, err := id.Signer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	genesis, err := config.GenesisBlock("mychannel", config.Genesis{
+		OrdererOrgs:     []*msp.FabricMSPConfig{orgs[3].MSPConfig()},
+		ApplicationOrgs: []*msp.FabricMSPConfig{orgs[0].MSPConfig(), orgs[1].MSPConfig()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	db := storage.New("mychannel", sqlDB)
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	l, err := ledger.New(ctx, db, genesis, signer(orgs[3].Orderers[0]), log.New(io.Discard, "", 0), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(l.Close)
+
+	return &network{
+		ledger:    l,
+		db:        db,
+		sqlDB:     sqlDB,
+		user:      signer(orgs[0].Users[0]),
+		endorsers: []fabrictx.Signer{signer(orgs[0].Peers[0]), signer(orgs[1].Peers[0])},
+		outsider:  signer(orgs[2].Peers[0]),
+	}
+}
+
+func TestLedger(t *testing.T) {
+	n := newNetwork(t)
+	ctx := context.Background()
+	executor := integration.NewChaincodeExecutor("basic", n.db)
+	cc := &chaincode.SmartContract{}
+
+	txc, err := executor.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.InitLedger(txc); err != nil {
+		t.Fatal(err)
+	}
+	env, _, err := fabrictx.NewEndorserTransaction("mychannel", "basic", n.user, n.endorsers, txc.Rwset())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := n.ledger.SubmitAndWait(ctx, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Valid() || st.BlockNum != 1 {
+		t.Fatalf("unexpected status %s", st)
+	}
+	if _, err := n.ledger.SubmitAndWait(ctx, env); err != nil {
+		t.Fatal(err)
+	} else if b, _ := n.ledger.Block(2); b.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER][0] != byte(peer.TxValidationCode_DUPLICATE_TXID) {
+		t.Error("expected a duplicate transaction to be invalid")
+	}
+
+	// two transactions in the same block that both update asset1: the second one has a stale read
+	var envs []*common.Envelope
+	for _, owner := range []string{"alice", "bob"} {
+		txc, err := executor.NewTransaction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cc.TransferAsset(txc, "asset1", owner); err != nil {
+			t.Fatal(err)
+		}
+		env, _, err := fabrictx.NewEndorserTransaction("mychannel", "basic", n.user, n.endorsers, txc.Rwset())
+		if err != nil {
+			t.Fatal(err)
+		}
+		envs = append(envs, env)
+	}
+	// endorsed by only one member of the channel, or by a peer of another organization
+	for _, endorsers := range [][]fabrictx.Signer{n.endorsers[:1], {n.endorsers[0], n.outsider}} {
+		env, _, err := fabrictx.NewEndorserTransaction("mychannel", "basic", n.user, endorsers, writeSet("k", "v"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		envs = append(envs, env)
+	}
+	// submitted by an identity that is not a member of the channel
+	env, _, err = fabrictx.NewEndorserTransaction("mychannel", "basic", n.outsider, n.endorsers, writeSet("k", "v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	envs = append(envs, env)
+
+	for _, env := range envs {
+		if err := n.ledger.Submit(env); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.ledger.Cut(); err != nil {
+		t.Fatal(err)
+	}
+	block, ok := n.ledger.Block(3)
+	if !ok {
+		t.Fatal("expected block 3")
+	}
+	expected := []peer.TxValidationCode{
+		peer.TxValidationCode_VALID,
+		peer.TxValidationCode_MVCC_READ_CONFLICT,
+		peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE,
+		peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE,
+		peer.TxValidationCode_BAD_CREATOR_SIGNATURE,
+	}
+	for i, code := range block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] {
+		if peer.TxValidationCode(code) != expected[i] {
+			t.Errorf("tx %d: expected %s, got %s", i, expected[i], peer.TxValidationCode(code))
+		}
+	}
+	if !bytes.Equal(block.Header.PreviousHash, fabrictx.BlockHeaderHash(mustBlock(t, n.ledger, 2).Header)) {
+		t.Error("expected the block to refer to the hash of the previous block")
+	}
+
+	txc, err = executor.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	asset, err := cc.ReadAsset(txc, "asset1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asset.Owner != "alice" {
+		t.Errorf("expected the first transfer to be committed, got owner %s", asset.Owner)
+	}
+}
+
+func TestEndorsementPolicy(t *testing.T) {
+	org1Only, err := policy.Parse("OR('Org1MSP.peer')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := newNetwork(t, ledger.WithEndorsementPolicy("basic", org1Only), ledger.WithBatchSize(1, time.Second))
+
+	env, txID, err := fabrictx.NewEndorserTransaction("mychannel", "basic", n.user, n.endorsers[:1], writeSet("k", "v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the batch size is 1, so the block is cut immediately
+	if err := n.ledger.Submit(env); err != nil {
+		t.Fatal(err)
+	}
+	st, err := n.ledger.Committer().WaitForTx(context.Background(), txID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Valid() {
+		t.Errorf("unexpected status %s", st)
+	}
+
+	var blocks []uint64
+	errDone := errors.New("done")
+	err = n.ledger.SubscribeBlocks(0, func(b *peer.DeliverResponse_BlockAndPrivateData) error {
+		blocks = append(blocks, b.BlockAndPrivateData.Block.Header.Number)
+		if len(blocks) == 2 {
+			return errDone
+		}
+		return nil
+	})
+	if !errors.Is(err, errDone) || len(blocks) != 2 || blocks[1] != 1 {
+		t.Errorf("unexpected blocks %v (%v)", blocks, err)
+	}
+}
+
+func TestCommitFailure(t *testing.T) {
+	n := newNetwork(t, ledger.WithBatchSize(10, time.Hour))
+	env, txID, err := fabrictx.NewEndorserTransaction("mychannel", "basic", n.user, n.endorsers, writeSet("k", "v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.ledger.Submit(env); err != nil {
+		t.Fatal(err)
+	}
+
+	// the transaction statuses can't be stored
+	if _, err := n.sqlDB.Exec("ALTER TABLE transactions_mychannel RENAME TO broken"); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.ledger.Cut(); err == nil {
+		t.Fatal("expected the commit to fail")
+	}
+	if h := n.ledger.Height(); h != 1 {
+		t.Errorf("expected no new block, got height %d", h)
+	}
+
+	// the transaction is still pending, and is committed with the next block
+	if _, err := n.sqlDB.Exec("ALTER TABLE broken RENAME TO transactions_mychannel"); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.ledger.Cut(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	st, err := n.ledger.Committer().WaitForTx(ctx, txID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Valid() || st.BlockNum != 1 {
+		t.Errorf("unexpected status %s", st)
+	}
+}
+
+func TestCloseDuringCut(t *testing.T) {
+	n := newNetwork(t, ledger.WithBatchSize(10, time.Hour))
+	env, _, err := fabrictx.NewEndorserTransaction("mychannel", "basic", n.user, n.endorsers, writeSet("k", "v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.ledger.Submit(env); err != nil {
+		t.Fatal(err)
+	}
+
+	// the block waits for the only connection to the database
+	tx, err := n.sqlDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cut := make(chan error, 1)
+	go func() { cut <- n.ledger.Cut() }()
+	time.Sleep(100 * time.Millisecond)
+	closed := make(chan struct{})
+	go func() {
+		n.ledger.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned while a block was being cut")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-cut; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close didn't return after the block was cut")
+	}
+	if h := n.ledger.Height(); h != 2 {
+		t.Errorf("expected height 2, got %d", h)
+	}
+	if err := n.ledger.Cut(); err == nil {
+		t.Error("expected an error cutting a block after Close")
+	}
+}
+
+func writeSet(key, value string) *kvrwset.KVRWSet {
+	return &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: key, Value: []byte(value)}}}
+}
+
+func mustBlock(t *testing.T, l *ledger.Ledger, num uint64) *common.Block {
+	b, ok := l.Block(num)
+	if !ok {
+		t.Fatalf("block %d not found", num)
+	}
+	return b
+}
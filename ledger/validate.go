@@ -0,0 +1,77 @@
+package ledger
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// validateTx checks a transaction like the validation of a peer before MVCC: the transaction ID is unique, the
+// creator is a valid member of the channel that signed the transaction, and every action satisfies the endorsement
+// policy of its chaincode. The transaction ID is added to inBlock.
+func (l *Ledger) validateTx(p pendingTx, inBlock map[string]struct{}) peer.TxValidationCode {
+	code, reason := l.check(p, inBlock)
+	if code != peer.TxValidationCode_VALID {
+		l.log.Printf("transaction %s invalid: %s (%s)", p.txID, code, reason)
+	}
+	return code
+}
+
+func (l *Ledger) check(p pendingTx, inBlock map[string]struct{}) (peer.TxValidationCode, string) {
+	if _, ok := l.txIDs[p.txID]; ok {
+		return peer.TxValidationCode_DUPLICATE_TXID, "already committed"
+	}
+	if _, ok := inBlock[p.txID]; ok {
+		return peer.TxValidationCode_DUPLICATE_TXID, "duplicate in block"
+	}
+	inBlock[p.txID] = struct{}{}
+
+	tx, err := fabrictx.EndorserTxToStruct(p.env)
+	if err != nil {
+		return peer.TxValidationCode_BAD_PAYLOAD, err.Error()
+	}
+
+	creator := tx.Payload.Header.SignatureHeader.Creator
+	if err := l.checkCreator(creator, p.env.Signature, p.env.Payload); err != nil {
+		return peer.TxValidationCode_BAD_CREATOR_SIGNATURE, err.Error()
+	}
+
+	if len(tx.Payload.Data.Actions) == 0 {
+		return peer.TxValidationCode_NIL_TXACTION, "no actions"
+	}
+	for _, act := range tx.Payload.Data.Actions {
+		ccID := act.ProposalResponsePayload.Extension.ChaincodeID
+		if ccID == nil {
+			return peer.TxValidationCode_INVALID_OTHER_REASON, "chaincode ID missing"
+		}
+		pol, ok := l.policies[ccID.Name]
+		if !ok {
+			pol = l.policies[""]
+		}
+		if err := pol.EvaluateAction(act); err != nil {
+			return peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE, fmt.Sprintf("%s: %s", ccID.Name, err)
+		}
+	}
+	return peer.TxValidationCode_VALID, ""
+}
+
+// checkCreator validates the creator against the MSPs of the channel and verifies its signature.
+func (l *Ledger) checkCreator(creator, signature, payload []byte) error {
+	id := &msp.SerializedIdentity{}
+	if err := proto.Unmarshal(creator, id); err != nil {
+		return fmt.Errorf("creator: %w", err)
+	}
+	v, ok := l.msps[id.Mspid]
+	if !ok {
+		return fmt.Errorf("creator of unknown msp %s", id.Mspid)
+	}
+	if _, err := v.Validate(creator, time.Time{}); err != nil {
+		return err
+	}
+	return fabrictx.VerifySignature(id.IdBytes, signature, payload)
+}
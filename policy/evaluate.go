@@ -0,0 +1,211 @@
+package policy
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/arner/hacky-fabric/fabrictx"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"google.golang.org/protobuf/proto"
+)
+
+// Identity is a signer whose signature has been verified.
+type Identity struct {
+	MSPID      string
+	Serialized []byte // msp.SerializedIdentity
+	Cert       *x509.Certificate
+}
+
+// PrincipalMatcher decides whether an identity satisfies a principal.
+type PrincipalMatcher interface {
+	SatisfiesPrincipal(id Identity, principal *msp.MSPPrincipal) error
+}
+
+// Policy evaluates a signature policy against a set of signers.
+// If Matcher is nil, principals are matched on MSP ID and the NodeOU of the certificate (NodeOUMatcher).
+type Policy struct {
+	Envelope *common.SignaturePolicyEnvelope
+	Matcher  PrincipalMatcher
+}
+
+// New returns a policy for the envelope after checking that all rules refer to existing identities.
+func New(env *common.SignaturePolicyEnvelope) (*Policy, error) {
+	if env == nil || env.Rule == nil {
+		return nil, errors.New("empty policy")
+	}
+	if err := checkRule(env.Rule, len(env.Identities)); err != nil {
+		return nil, err
+	}
+	return &Policy{Envelope: env}, nil
+}
+
+// Parse returns a policy from the string syntax of the peer CLI, for instance "OR('Org1MSP.peer', 'Org2MSP.peer')".
+func Parse(policy string) (*Policy, error) {
+	env, err := FromString(policy)
+	if err != nil {
+		return nil, err
+	}
+	return New(env)
+}
+
+func checkRule(rule *common.SignaturePolicy, identities int) error {
+	switch t := rule.Type.(type) {
+	case *common.SignaturePolicy_SignedBy:
+		if t.SignedBy < 0 || int(t.SignedBy) >= identities {
+			return fmt.Errorf("rule refers to identity %d, but there are only %d", t.SignedBy, identities)
+		}
+	case *common.SignaturePolicy_NOutOf_:
+		for _, r := range t.NOutOf.Rules {
+			if err := checkRule(r, identities); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown rule type %T", rule.Type)
+	}
+	return nil
+}
+
+// EvaluateAction verifies the endorsement signatures of a parsed transaction action and checks whether
+// the valid endorsers satisfy the policy. Invalid endorsements are ignored, like the peer does, but they are
+// mentioned in the error if the policy is not satisfied.
+func (p *Policy) EvaluateAction(act fabrictx.Action) error {
+	var ids []Identity
+	var errs []error
+	for _, e := range act.Endorsements {
+		if err := e.Verify(act.ProposalResponsePayloadB); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		cert, err := fabrictx.ParseCertificate(e.Endorser.IdBytes)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("endorser of %s: %w", e.Endorser.Mspid, err))
+			continue
+		}
+		ids = append(ids, Identity{MSPID: e.Endorser.Mspid, Serialized: e.EndorserB, Cert: cert})
+	}
+	if err := p.Evaluate(ids); err != nil {
+		return errors.Join(append([]error{err}, errs...)...)
+	}
+	return nil
+}
+
+// Evaluate checks whether the (already verified) identities satisfy the policy. Every identity can only be
+// used once, and identical identities are counted once.
+func (p *Policy) Evaluate(ids []Identity) error {
+	var unique []Identity
+	for _, id := range ids {
+		if !slices.ContainsFunc(unique, func(u Identity) bool { return bytes.Equal(u.Serialized, id.Serialized) }) {
+			unique = append(unique, id)
+		}
+	}
+
+	matcher := p.Matcher
+	if matcher == nil {
+		matcher = NodeOUMatcher{}
+	}
+	used := make([]bool, len(unique))
+	if !p.evaluate(p.Envelope.Rule, matcher, unique, used) {
+		return fmt.Errorf("signature set did not satisfy policy (%d valid signers)", len(unique))
+	}
+	return nil
+}
+
+// evaluate follows the greedy algorithm of Fabric's cauthdsl: a rule that is satisfied claims the identities
+// it used, so they can't be used by the next rules.
+func (p *Policy) evaluate(rule *common.SignaturePolicy, matcher PrincipalMatcher, ids []Identity, used []bool) bool {
+	switch t := rule.Type.(type) {
+	case *common.SignaturePolicy_SignedBy:
+		principal := p.Envelope.Identities[t.SignedBy]
+		for i, id := range ids {
+			if used[i] {
+				continue
+			}
+			if matcher.SatisfiesPrincipal(id, principal) == nil {
+				used[i] = true
+				return true
+			}
+		}
+		return false
+	case *common.SignaturePolicy_NOutOf_:
+		verified := int32(0)
+		tmp := make([]bool, len(used))
+		for _, r := range t.NOutOf.Rules {
+			copy(tmp, used)
+			if p.evaluate(r, matcher, ids, tmp) {
+				verified++
+				copy(used, tmp)
+			}
+		}
+		return verified >= t.NOutOf.N
+	}
+	return false
+}
+
+// NodeOUMatcher matches roles based on the MSP ID and the organizational units of the certificate,
+// following the default NodeOU configuration ("client", "peer", "admin" and "orderer"). It does not check
+// whether the certificate is issued by a CA of the MSP.
+type NodeOUMatcher struct{}
+
+func (NodeOUMatcher) SatisfiesPrincipal(id Identity, principal *msp.MSPPrincipal) error {
+	switch principal.PrincipalClassification {
+	case msp.MSPPrincipal_ROLE:
+		role := &msp.MSPRole{}
+		if err := proto.Unmarshal(principal.Principal, role); err != nil {
+			return fmt.Errorf("msp role: %w", err)
+		}
+		if role.MspIdentifier != id.MSPID {
+			return fmt.Errorf("identity of %s is not a member of %s", id.MSPID, role.MspIdentifier)
+		}
+		if role.Role == msp.MSPRole_MEMBER {
+			return nil
+		}
+		ou := map[msp.MSPRole_MSPRoleType]string{
+			msp.MSPRole_ADMIN:   "admin",
+			msp.MSPRole_CLIENT:  "client",
+			msp.MSPRole_PEER:    "peer",
+			msp.MSPRole_ORDERER: "orderer",
+		}[role.Role]
+		if ou == "" || !slices.Contains(id.Cert.Subject.OrganizationalUnit, ou) {
+			return fmt.Errorf("identity of %s does not have role %s", id.MSPID, role.Role)
+		}
+		return nil
+	case msp.MSPPrincipal_ORGANIZATION_UNIT:
+		unit := &msp.OrganizationUnit{}
+		if err := proto.Unmarshal(principal.Principal, unit); err != nil {
+			return fmt.Errorf("organization unit: %w", err)
+		}
+		if unit.MspIdentifier != id.MSPID || !slices.Contains(id.Cert.Subject.OrganizationalUnit, unit.OrganizationalUnitIdentifier) {
+			return fmt.Errorf("identity is not part of %s.%s", unit.MspIdentifier, unit.OrganizationalUnitIdentifier)
+		}
+		return nil
+	case msp.MSPPrincipal_IDENTITY:
+		if !bytes.Equal(principal.Principal, id.Serialized) {
+			return errors.New("identity does not match")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported principal classification %s", principal.PrincipalClassification)
+}
+
+// MSPMatcher matches principals like the peer does, by validating the identities against the MSP definitions of
+// the channel: certificate chain, expiry at Time (now if zero), revocation and NodeOUs. Identities of MSPs
+// that are not in MSPs don't satisfy any principal.
+type MSPMatcher struct {
+	MSPs map[string]*fabrictx.MSPVerifier
+	Time time.Time
+}
+
+func (m MSPMatcher) SatisfiesPrincipal(id Identity, principal *msp.MSPPrincipal) error {
+	v, ok := m.MSPs[id.MSPID]
+	if !ok {
+		return fmt.Errorf("unknown msp %s", id.MSPID)
+	}
+	return v.SatisfiesPrincipal(id.Serialized, principal, m.Time)
+}
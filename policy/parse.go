@@ -0,0 +1,202 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"google.golang.org/protobuf/proto"
+)
+
+var roles = map[string]msp.MSPRole_MSPRoleType{
+	"member":  msp.MSPRole_MEMBER,
+	"admin":   msp.MSPRole_ADMIN,
+	"client":  msp.MSPRole_CLIENT,
+	"peer":    msp.MSPRole_PEER,
+	"orderer": msp.MSPRole_ORDERER,
+}
+
+// FromString parses a signature policy in the syntax of the peer CLI, for instance
+// "AND('Org1MSP.peer', OutOf(2, 'Org2MSP.member', 'Org3MSP.admin', 'Org4MSP.client'))".
+// Like in Fabric, every principal gets its own entry in the identities of the envelope.
+func FromString(policy string) (*common.SignaturePolicyEnvelope, error) {
+	p := &parser{tokens: tokenize(policy)}
+	rule, err := p.expr()
+	if err != nil {
+		return nil, fmt.Errorf("parse policy %q: %w", policy, err)
+	}
+	if tok := p.next(); tok != "" {
+		return nil, fmt.Errorf("parse policy %q: unexpected %q", policy, tok)
+	}
+	return &common.SignaturePolicyEnvelope{
+		Version:    0,
+		Rule:       rule,
+		Identities: p.principals,
+	}, nil
+}
+
+// FromBytes unmarshals a serialized SignaturePolicyEnvelope.
+func FromBytes(b []byte) (*common.SignaturePolicyEnvelope, error) {
+	env := &common.SignaturePolicyEnvelope{}
+	if err := proto.Unmarshal(b, env); err != nil {
+		return nil, fmt.Errorf("signature policy envelope: %w", err)
+	}
+	return env, nil
+}
+
+// NOutOf returns a rule that requires n of the given rules to be satisfied.
+func NOutOf(n int32, rules ...*common.SignaturePolicy) *common.SignaturePolicy {
+	return &common.SignaturePolicy{
+		Type: &common.SignaturePolicy_NOutOf_{
+			NOutOf: &common.SignaturePolicy_NOutOf{N: n, Rules: rules},
+		},
+	}
+}
+
+// SignedBy returns a rule that requires a signature of the identity at the given index.
+func SignedBy(index int32) *common.SignaturePolicy {
+	return &common.SignaturePolicy{
+		Type: &common.SignaturePolicy_SignedBy{SignedBy: index},
+	}
+}
+
+type parser struct {
+	tokens     []string
+	pos        int
+	principals []*msp.MSPPrincipal
+}
+
+func (p *parser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	return tok
+}
+
+func (p *parser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		return fmt.Errorf("expected %q, got %q", tok, got)
+	}
+	return nil
+}
+
+func (p *parser) expr() (*common.SignaturePolicy, error) {
+	tok := p.next()
+	if tok == "" {
+		return nil, fmt.Errorf("unexpected end of policy")
+	}
+	if tok[0] == '\'' || tok[0] == '"' {
+		if len(tok) < 2 || tok[len(tok)-1] != tok[0] {
+			return nil, fmt.Errorf("unterminated principal %s", tok)
+		}
+		return p.principal(tok[1 : len(tok)-1])
+	}
+
+	fn := strings.ToLower(tok)
+	if fn != "and" && fn != "or" && fn != "outof" {
+		return nil, fmt.Errorf("unknown function %q", tok)
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var n int64
+	if fn == "outof" {
+		var err error
+		num := p.next()
+		if n, err = strconv.ParseInt(num, 10, 32); err != nil || n <= 0 {
+			return nil, fmt.Errorf("OutOf expects a positive number, got %q", num)
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+
+	var rules []*common.SignaturePolicy
+	for {
+		rule, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+		if p.peek() != "," {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	switch fn {
+	case "and":
+		n = int64(len(rules))
+	case "or":
+		n = 1
+	}
+	return NOutOf(int32(n), rules...), nil
+}
+
+// principal parses 'MSPID.role'. The MSP ID itself may contain dots.
+func (p *parser) principal(s string) (*common.SignaturePolicy, error) {
+	i := strings.LastIndex(s, ".")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid principal %q, expected 'MSPID.role'", s)
+	}
+	role, ok := roles[s[i+1:]]
+	if !ok {
+		return nil, fmt.Errorf("invalid role in principal %q", s)
+	}
+	b, err := proto.Marshal(&msp.MSPRole{MspIdentifier: s[:i], Role: role})
+	if err != nil {
+		return nil, err
+	}
+	p.principals = append(p.principals, &msp.MSPPrincipal{
+		PrincipalClassification: msp.MSPPrincipal_ROLE,
+		Principal:               b,
+	})
+	return SignedBy(int32(len(p.principals) - 1)), nil
+}
+
+// tokenize splits a policy into function names, numbers, quoted principals and punctuation.
+func tokenize(s string) []string {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexRune(s[i+1:], c)
+			if end < 0 {
+				// unterminated, let the parser fail on it
+				tokens = append(tokens, s[i:])
+				return tokens
+			}
+			tokens = append(tokens, s[i:i+end+2])
+			i += end + 2
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune("(),'\" \t\n\r", rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,168 @@
+package policy_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arner/hacky-fabric/fabrictx"
+	"github.com/arner/hacky-fabric/policy"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFromString(t *testing.T) {
+	env, err := policy.FromString("AND('Org1MSP.peer', OutOf(2, 'Org2MSP.member', \"Org.3MSP.admin\", 'Org4MSP.client'))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(env.Identities) != 4 {
+		t.Fatalf("expected 4 identities, got %d", len(env.Identities))
+	}
+	role := &msp.MSPRole{}
+	if err := proto.Unmarshal(env.Identities[2].Principal, role); err != nil {
+		t.Fatal(err)
+	}
+	if role.MspIdentifier != "Org.3MSP" || role.Role != msp.MSPRole_ADMIN {
+		t.Errorf("unexpected principal: %v", role)
+	}
+
+	and := env.Rule.GetNOutOf()
+	if and == nil || and.N != 2 || len(and.Rules) != 2 {
+		t.Fatalf("unexpected AND rule: %v", env.Rule)
+	}
+	outOf := and.Rules[1].GetNOutOf()
+	if outOf == nil || outOf.N != 2 || len(outOf.Rules) != 3 {
+		t.Fatalf("unexpected OutOf rule: %v", and.Rules[1])
+	}
+	if _, ok := outOf.Rules[2].Type.(*common.SignaturePolicy_SignedBy); !ok || outOf.Rules[2].GetSignedBy() != 3 {
+		t.Errorf("expected last rule to be signed by identity 3, got %v", outOf.Rules[2])
+	}
+
+	for _, invalid := range []string{
+		"",
+		"AND('Org1MSP.peer'",
+		"XOR('Org1MSP.peer')",
+		"OR('Org1MSP.superuser')",
+		"OutOf(x, 'Org1MSP.peer')",
+		"OutOf(0, 'Org1MSP.peer')",
+		"OutOf(-1, 'Org1MSP.peer')",
+		"OR('Org1MSP.peer') 'Org2MSP.peer'",
+		"OR('Org1MSP.peer)",
+	} {
+		if _, err := policy.FromString(invalid); err == nil {
+			t.Errorf("expected error parsing %q", invalid)
+		}
+	}
+}
+
+func TestEvaluateAction(t *testing.T) {
+	act := endorsedAction(t)
+
+	tests := []struct {
+		policy string
+		valid  bool
+	}{
+		{"AND('Org1MSP.peer', 'Org2MSP.peer')", true},
+		{"OR('Org1MSP.member', 'Org3MSP.member')", true},
+		{"OutOf(2, 'Org1MSP.peer', 'Org2MSP.member', 'Org3MSP.member')", true},
+		{"AND('Org1MSP.peer', 'Org3MSP.peer')", false},
+		{"AND('Org1MSP.client', 'Org2MSP.peer')", false},
+		{"AND('Org1MSP.admin')", false},
+		// the Org1 endorsement can only be used once
+		{"OutOf(2, 'Org1MSP.peer', 'Org1MSP.member')", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.policy, func(t *testing.T) {
+			p, err := policy.Parse(tc.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = p.EvaluateAction(act)
+			if tc.valid && err != nil {
+				t.Errorf("expected policy to be satisfied: %s", err)
+			}
+			if !tc.valid && err == nil {
+				t.Error("expected policy not to be satisfied")
+			}
+		})
+	}
+}
+
+func TestMSPMatcher(t *testing.T) {
+	act := endorsedAction(t)
+	msps := map[string]*fabrictx.MSPVerifier{}
+	for _, id := range []struct{ dir, mspID string }{
+		{"../fabrictx/fixtures/endorser", "Org1MSP"},
+		{"../fabrictx/fixtures/endorser2", "Org2MSP"},
+	} {
+		v, err := fabrictx.MSPVerifierFromDir(id.dir, id.mspID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msps[id.mspID] = v
+	}
+
+	p, err := policy.Parse("AND('Org1MSP.peer', 'Org2MSP.peer')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Matcher = policy.MSPMatcher{MSPs: msps}
+	if err := p.EvaluateAction(act); err != nil {
+		t.Errorf("expected policy to be satisfied: %s", err)
+	}
+
+	// the certificates have expired by then
+	p.Matcher = policy.MSPMatcher{MSPs: msps, Time: time.Now().AddDate(100, 0, 0)}
+	if err := p.EvaluateAction(act); err == nil {
+		t.Error("expected policy not to be satisfied with expired certificates")
+	}
+
+	// without the MSP of Org2, its endorsement doesn't count
+	delete(msps, "Org2MSP")
+	p.Matcher = policy.MSPMatcher{MSPs: msps}
+	if err := p.EvaluateAction(act); err == nil {
+		t.Error("expected policy not to be satisfied without the MSP of Org2MSP")
+	}
+}
+
+func TestEvaluateInvalidSignature(t *testing.T) {
+	act := endorsedAction(t)
+	act.Endorsements[1].Signature = act.Endorsements[0].Signature
+
+	p, err := policy.Parse("AND('Org1MSP.peer', 'Org2MSP.peer')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.EvaluateAction(act); err == nil {
+		t.Error("expected policy to fail with an invalid endorsement")
+	}
+}
+
+func endorsedAction(t *testing.T) fabrictx.Action {
+	var signers []fabrictx.Signer
+	for _, id := range []struct{ dir, mspID string }{
+		{"../fabrictx/fixtures/user", "Org1MSP"},
+		{"../fabrictx/fixtures/endorser", "Org1MSP"},
+		{"../fabrictx/fixtures/endorser2", "Org2MSP"},
+	} {
+		s, err := fabrictx.SignerFromMSP(id.dir, id.mspID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers = append(signers, s)
+	}
+
+	rw := &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "k", Value: []byte("v")}}}
+	tx, _, err := fabrictx.NewEndorserTransaction("mychannel", "basic", signers[0], signers[1:], rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := fabrictx.EndorserTxToStruct(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Payload.Data.Actions[0]
+}
@@ -7,19 +7,38 @@ import (
 
 // VersionedDB provides persistence for read/write sets per channel.
 type VersionedDB struct {
-	channel string
-	table   string
-	backend *sql.DB
+	channel   string
+	table     string
+	txTable   string
+	dlTable   string
+	hashTable string
+	dialect   Dialect
+	backend   *sql.DB
 }
 
+// New returns a VersionedDB for the channel. The SQL dialect is derived from the driver of the database.
 func New(channel string, db *sql.DB) *VersionedDB {
+	return NewWithDialect(channel, db, DetectDialect(db))
+}
+
+// NewWithDialect returns a VersionedDB for the channel that uses the given SQL dialect.
+func NewWithDialect(channel string, db *sql.DB, dialect Dialect) *VersionedDB {
 	return &VersionedDB{
-		channel: channel,
-		table:   fmt.Sprintf("worldstate_%s", channel),
-		backend: db,
+		channel:   channel,
+		table:     fmt.Sprintf("worldstate_%s", channel),
+		txTable:   fmt.Sprintf("transactions_%s", channel),
+		dlTable:   fmt.Sprintf("deadletters_%s", channel),
+		hashTable: fmt.Sprintf("statehashes_%s", channel),
+		dialect:   dialect,
+		backend:   db,
 	}
 }
 
+// Channel returns the channel of the database.
+func (s *VersionedDB) Channel() string {
+	return s.channel
+}
+
 // WriteRecord represents a single write or delete in the world state.
 type WriteRecord struct {
 	Namespace string
@@ -31,28 +50,71 @@ type WriteRecord struct {
 	TxID      string
 }
 
+// TxRecord is the final status of a transaction in a block.
+type TxRecord struct {
+	TxID           string
+	BlockNum       uint64
+	TxNum          uint64
+	ValidationCode int32 // peer.TxValidationCode
+	Event          *Event
+}
+
+// Event is a chaincode event. Fabric allows one per transaction.
+type Event struct {
+	Namespace string
+	Name      string
+	Payload   []byte
+}
+
 // Init creates the world state table for a channel if it doesn't exist.
 func (s *VersionedDB) Init() error {
 	schema := fmt.Sprintf(`
-	CREATE TABLE IF NOT EXISTS %s (
+	CREATE TABLE IF NOT EXISTS %[1]s (
 		namespace TEXT NOT NULL,
-		key TEXT NOT NULL,
+		key TEXT %[4]s NOT NULL,
 		version_block BIGINT NOT NULL,
 		version_tx INTEGER NOT NULL,
-		value BLOB,
+		value %[5]s,
 		is_delete BOOLEAN NOT NULL DEFAULT false,
 		tx_id TEXT NOT NULL,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		PRIMARY KEY (namespace, key, version_block, version_tx)
 	);
-	CREATE INDEX IF NOT EXISTS idx_%s_ns_key ON %s (namespace, key);
-	CREATE INDEX IF NOT EXISTS idx_%s_block_tx ON %s (version_block, version_tx);
+	CREATE INDEX IF NOT EXISTS idx_%[2]s_ns_key ON %[1]s (namespace, key);
+	CREATE INDEX IF NOT EXISTS idx_%[2]s_block_tx ON %[1]s (version_block, version_tx);
+
+	CREATE TABLE IF NOT EXISTS %[3]s (
+		tx_id TEXT NOT NULL,
+		block_num BIGINT NOT NULL,
+		tx_num INTEGER NOT NULL,
+		validation_code INTEGER NOT NULL,
+		event_namespace TEXT,
+		event_name TEXT,
+		event_payload %[5]s,
+		PRIMARY KEY (block_num, tx_num)
+	);
+	CREATE INDEX IF NOT EXISTS idx_%[3]s_tx_id ON %[3]s (tx_id);
+
+	CREATE TABLE IF NOT EXISTS %[6]s (
+		block_num BIGINT NOT NULL,
+		tx_num INTEGER NOT NULL,
+		tx_id TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (block_num, tx_num)
+	);
+
+	CREATE TABLE IF NOT EXISTS %[7]s (
+		block_num BIGINT PRIMARY KEY,
+		hash %[5]s,
+		commit_hash %[5]s
+	);
 
 	CREATE TABLE IF NOT EXISTS channel_progress (
 		channel TEXT PRIMARY KEY,
 		last_block BIGINT NOT NULL
 	);
-	`, s.table, s.channel, s.table, s.channel, s.table)
+	`, s.table, s.channel, s.txTable, s.dialect.KeyCollation, s.dialect.BlobType, s.dlTable, s.hashTable)
 
 	_, err := s.backend.Exec(schema)
 	if err != nil {
@@ -65,13 +127,14 @@ func (s *VersionedDB) Init() error {
 // It is idempotent — if the same (namespace,key,block,tx) already exists, it's ignored.
 func (s *VersionedDB) InsertWrite(w WriteRecord) error {
 	query := fmt.Sprintf(`
-	INSERT OR IGNORE INTO %s (namespace, key, version_block, version_tx, value, is_delete, tx_id)
-	VALUES ($1, $2, $3, $4, $5, $6, $7);
+	INSERT INTO %s (namespace, key, version_block, version_tx, value, is_delete, tx_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (namespace, key, version_block, version_tx) DO NOTHING;
 	`, s.table)
 
 	_, err := s.backend.Exec(query,
 		w.Namespace,
-		w.Key,
+		encodeKey(w.Key),
 		w.BlockNum,
 		w.TxNum,
 		w.Value,
@@ -89,18 +152,78 @@ func (s *VersionedDB) BatchInsert(writes []WriteRecord) error {
 	if len(writes) == 0 {
 		return nil
 	}
+	return s.CommitBlock(writes[0].BlockNum, writes, nil)
+}
+
+// DeadLetter is a block or transaction that the committer could not process completely. TxNum is -1 if the
+// whole block was skipped.
+type DeadLetter struct {
+	BlockNum uint64
+	TxNum    int64
+	TxID     string
+	Reason   string
+}
+
+// BlockWrites are the writes and transaction statuses of a block, what could not be processed, and the state
+// hash after the block (see StateHash).
+type BlockWrites struct {
+	BlockNum    uint64
+	Writes      []WriteRecord
+	Txs         []TxRecord
+	DeadLetters []DeadLetter
+	StateHash   []byte
+	CommitHash  []byte // the COMMIT_HASH in the block metadata, if the peer set it
+}
+
+// CommitBlock stores the writes and the transaction statuses of a block and marks the block as processed,
+// all in a single database transaction.
+func (s *VersionedDB) CommitBlock(blockNum uint64, writes []WriteRecord, txs []TxRecord) error {
+	return s.CommitBlocks([]BlockWrites{{BlockNum: blockNum, Writes: writes, Txs: txs}})
+}
+
+// CommitBlocks stores consecutive blocks and marks the last one as processed, in a single database transaction.
+func (s *VersionedDB) CommitBlocks(blocks []BlockWrites) error {
+	if len(blocks) == 0 {
+		return nil
+	}
 	tx, err := s.backend.Begin()
 	if err != nil {
-		return fmt.Errorf("begin batch insert: %w", err)
+		return fmt.Errorf("begin commit block: %w", err)
 	}
 	defer tx.Rollback()
 
-	var stmt *sql.Stmt
-	stmt, err = tx.Prepare(fmt.Sprintf(`
+	for _, b := range blocks {
+		if err := s.insertWrites(tx, b.Writes); err != nil {
+			return err
+		}
+		if err := s.insertTxs(tx, b.Txs); err != nil {
+			return err
+		}
+		if err := s.insertDeadLetters(tx, b.DeadLetters); err != nil {
+			return err
+		}
+		if err := s.insertHashes(tx, b); err != nil {
+			return err
+		}
+	}
+	if err := s.MarkProcessed(tx, blocks[len(blocks)-1].BlockNum); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit block: %w", err)
+	}
+	return nil
+}
+
+func (s *VersionedDB) insertWrites(tx *sql.Tx, writes []WriteRecord) error {
+	if len(writes) == 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare(fmt.Sprintf(`
 	INSERT INTO %s (namespace, key, version_block, version_tx, value, is_delete, tx_id)
 	VALUES ($1, $2, $3, $4, $5, $6, $7)
 	ON CONFLICT (namespace, key, version_block, version_tx) DO NOTHING;
-
 	`, s.table))
 	if err != nil {
 		return fmt.Errorf("prepare batch insert: %w", err)
@@ -108,25 +231,98 @@ func (s *VersionedDB) BatchInsert(writes []WriteRecord) error {
 	defer stmt.Close()
 
 	for _, w := range writes {
-		if _, err := stmt.Exec(w.Namespace, w.Key, w.BlockNum, w.TxNum, w.Value, w.IsDelete, w.TxID); err != nil {
+		if _, err := stmt.Exec(w.Namespace, encodeKey(w.Key), w.BlockNum, w.TxNum, w.Value, w.IsDelete, w.TxID); err != nil {
 			return fmt.Errorf("batch insert exec: %w", err)
 		}
 	}
-	if err := s.MarkProcessed(tx, writes[0].BlockNum); err != nil {
-		return err
+	return nil
+}
+
+func (s *VersionedDB) insertTxs(tx *sql.Tx, txs []TxRecord) error {
+	if len(txs) == 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare(fmt.Sprintf(`
+	INSERT INTO %s (tx_id, block_num, tx_num, validation_code, event_namespace, event_name, event_payload)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (block_num, tx_num) DO NOTHING;
+	`, s.txTable))
+	if err != nil {
+		return fmt.Errorf("prepare insert transactions: %w", err)
 	}
+	defer stmt.Close()
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("commit batch insert: %w", err)
+	for _, t := range txs {
+		var ns, name sql.NullString
+		var payload []byte
+		if t.Event != nil {
+			ns = sql.NullString{String: t.Event.Namespace, Valid: true}
+			name = sql.NullString{String: t.Event.Name, Valid: true}
+			payload = t.Event.Payload
+		}
+		if _, err := stmt.Exec(t.TxID, t.BlockNum, t.TxNum, t.ValidationCode, ns, name, payload); err != nil {
+			return fmt.Errorf("insert transaction exec: %w", err)
+		}
 	}
 	return nil
 }
 
+func (s *VersionedDB) insertDeadLetters(tx *sql.Tx, letters []DeadLetter) error {
+	if len(letters) == 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare(fmt.Sprintf(`
+	INSERT INTO %s (block_num, tx_num, tx_id, reason)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (block_num, tx_num) DO NOTHING;
+	`, s.dlTable))
+	if err != nil {
+		return fmt.Errorf("prepare insert dead letters: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, d := range letters {
+		if _, err := stmt.Exec(d.BlockNum, d.TxNum, d.TxID, d.Reason); err != nil {
+			return fmt.Errorf("insert dead letter exec: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetDeadLetters returns the blocks and transactions that could not be processed, from a block on.
+func (s *VersionedDB) GetDeadLetters(fromBlock uint64) ([]DeadLetter, error) {
+	query := fmt.Sprintf(`
+	SELECT block_num, tx_num, tx_id, reason
+	FROM %s
+	WHERE block_num >= $1
+	ORDER BY block_num, tx_num;
+	`, s.dlTable)
+
+	rows, err := s.backend.Query(query, fromBlock)
+	if err != nil {
+		return nil, fmt.Errorf("query dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	var out []DeadLetter
+	for rows.Next() {
+		var d DeadLetter
+		if err := rows.Scan(&d.BlockNum, &d.TxNum, &d.TxID, &d.Reason); err != nil {
+			return nil, fmt.Errorf("scan dead letter: %w", err)
+		}
+		out = append(out, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate dead letters: %w", err)
+	}
+	return out, nil
+}
+
 func (s *VersionedDB) MarkProcessed(tx *sql.Tx, blockNum uint64) error {
 	query := `
 	INSERT INTO channel_progress(channel, last_block)
-	VALUES (?, ?)
-	ON CONFLICT(channel) DO UPDATE SET last_block = excluded.last_block
+	VALUES ($1, $2)
+	ON CONFLICT (channel) DO UPDATE SET last_block = excluded.last_block
 	WHERE excluded.last_block > channel_progress.last_block;
 	`
 	var err error
@@ -152,9 +348,9 @@ func (s *VersionedDB) Get(namespace, key string, lastBlock uint64) (*WriteRecord
 	LIMIT 1;
 	`, s.table)
 
-	row := s.backend.QueryRow(query, namespace, key, lastBlock)
-	var w WriteRecord
-	if err := row.Scan(&w.Namespace, &w.Key, &w.BlockNum, &w.TxNum, &w.Value, &w.IsDelete, &w.TxID); err != nil {
+	row := s.backend.QueryRow(query, namespace, encodeKey(key), lastBlock)
+	w, err := scanWrite(row)
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -173,9 +369,9 @@ func (s *VersionedDB) GetCurrent(namespace, key string) (*WriteRecord, error) {
 	LIMIT 1;
 	`, s.table)
 
-	row := s.backend.QueryRow(query, namespace, key)
-	var w WriteRecord
-	if err := row.Scan(&w.Namespace, &w.Key, &w.BlockNum, &w.TxNum, &w.Value, &w.IsDelete, &w.TxID); err != nil {
+	row := s.backend.QueryRow(query, namespace, encodeKey(key))
+	w, err := scanWrite(row)
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -184,6 +380,52 @@ func (s *VersionedDB) GetCurrent(namespace, key string) (*WriteRecord, error) {
 	return &w, nil
 }
 
+// GetRange returns the latest version of every key in [startKey, endKey) as of lastBlock, ordered by key.
+// Deleted keys are left out. An empty endKey means there is no upper bound.
+func (s *VersionedDB) GetRange(namespace, startKey, endKey string, lastBlock uint64) ([]WriteRecord, error) {
+	query := fmt.Sprintf(`
+	SELECT w.namespace, w.key, w.version_block, w.version_tx, w.value, w.is_delete, w.tx_id
+	FROM %[1]s w
+	WHERE w.namespace = $1 AND w.key >= $2 AND ($3 = '' OR w.key < $3) AND w.version_block <= $4
+	AND NOT EXISTS (
+		SELECT 1 FROM %[1]s n
+		WHERE n.namespace = w.namespace AND n.key = w.key AND n.version_block <= $4
+		AND (n.version_block > w.version_block OR (n.version_block = w.version_block AND n.version_tx > w.version_tx))
+	)
+	AND w.is_delete = false
+	ORDER BY w.key;
+	`, s.table)
+
+	rows, err := s.backend.Query(query, namespace, encodeKey(startKey), encodeKey(endKey), lastBlock)
+	if err != nil {
+		return nil, fmt.Errorf("get range: %w", err)
+	}
+	defer rows.Close()
+
+	var result []WriteRecord
+	for rows.Next() {
+		w, err := scanWrite(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan range: %w", err)
+		}
+		result = append(result, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate range: %w", err)
+	}
+	return result, nil
+}
+
+// GetByPartialCompositeKey returns the latest version of every composite key of the object type that starts
+// with the given attributes, as of lastBlock.
+func (s *VersionedDB) GetByPartialCompositeKey(namespace, objectType string, attributes []string, lastBlock uint64) ([]WriteRecord, error) {
+	startKey, endKey, err := partialCompositeKeyRange(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetRange(namespace, startKey, endKey, lastBlock)
+}
+
 // GetHistory returns all versions of a key ordered by version.
 func (s *VersionedDB) GetHistory(namespace, key string) ([]WriteRecord, error) {
 	query := fmt.Sprintf(`
@@ -193,7 +435,7 @@ func (s *VersionedDB) GetHistory(namespace, key string) ([]WriteRecord, error) {
 	ORDER BY version_block, version_tx;
 	`, s.table)
 
-	rows, err := s.backend.Query(query, namespace, key)
+	rows, err := s.backend.Query(query, namespace, encodeKey(key))
 	if err != nil {
 		return nil, fmt.Errorf("get history: %w", err)
 	}
@@ -201,8 +443,8 @@ func (s *VersionedDB) GetHistory(namespace, key string) ([]WriteRecord, error) {
 
 	var result []WriteRecord
 	for rows.Next() {
-		var w WriteRecord
-		if err := rows.Scan(&w.Namespace, &w.Key, &w.BlockNum, &w.TxNum, &w.Value, &w.IsDelete, &w.TxID); err != nil {
+		w, err := scanWrite(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan history: %w", err)
 		}
 		result = append(result, w)
@@ -213,6 +455,88 @@ func (s *VersionedDB) GetHistory(namespace, key string) ([]WriteRecord, error) {
 	return result, nil
 }
 
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanWrite scans a row of the world state table and decodes the key.
+func scanWrite(row scanner) (WriteRecord, error) {
+	var w WriteRecord
+	if err := row.Scan(&w.Namespace, &w.Key, &w.BlockNum, &w.TxNum, &w.Value, &w.IsDelete, &w.TxID); err != nil {
+		return w, err
+	}
+	key, err := decodeKey(w.Key)
+	if err != nil {
+		return w, fmt.Errorf("decode key %q: %w", w.Key, err)
+	}
+	w.Key = key
+	return w, nil
+}
+
+// GetTx returns the status of a transaction, or nil if it has not been committed (yet).
+// If the same transaction ID occurs multiple times, the first occurrence is returned.
+func (s *VersionedDB) GetTx(txID string) (*TxRecord, error) {
+	query := fmt.Sprintf(`
+	SELECT tx_id, block_num, tx_num, validation_code, event_namespace, event_name, event_payload
+	FROM %s
+	WHERE tx_id = $1
+	ORDER BY block_num, tx_num
+	LIMIT 1;
+	`, s.txTable)
+
+	t, err := scanTx(s.backend.QueryRow(query, txID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get transaction: %w", err)
+	}
+	return &t, nil
+}
+
+// GetEvents returns the valid transactions in [fromBlock, toBlock] that have a chaincode event of the namespace,
+// ordered by block and transaction number.
+func (s *VersionedDB) GetEvents(namespace string, fromBlock, toBlock uint64) ([]TxRecord, error) {
+	query := fmt.Sprintf(`
+	SELECT tx_id, block_num, tx_num, validation_code, event_namespace, event_name, event_payload
+	FROM %s
+	WHERE event_namespace = $1 AND block_num >= $2 AND block_num <= $3 AND validation_code = 0
+	ORDER BY block_num, tx_num;
+	`, s.txTable)
+
+	rows, err := s.backend.Query(query, namespace, fromBlock, toBlock)
+	if err != nil {
+		return nil, fmt.Errorf("get events: %w", err)
+	}
+	defer rows.Close()
+
+	var result []TxRecord
+	for rows.Next() {
+		t, err := scanTx(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan events: %w", err)
+		}
+		result = append(result, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate events: %w", err)
+	}
+	return result, nil
+}
+
+func scanTx(row scanner) (TxRecord, error) {
+	var t TxRecord
+	var ns, name sql.NullString
+	var payload []byte
+	if err := row.Scan(&t.TxID, &t.BlockNum, &t.TxNum, &t.ValidationCode, &ns, &name, &payload); err != nil {
+		return t, err
+	}
+	if ns.Valid {
+		t.Event = &Event{Namespace: ns.String, Name: name.String, Payload: payload}
+	}
+	return t, nil
+}
+
 // LastProcessedBlock returns the highest block number stored for the given channel.
 // Returns 0 if there are no writes yet.
 func (s *VersionedDB) LastProcessedBlock() (uint64, error) {
@@ -249,6 +573,7 @@ func (s *VersionedDB) NewSimulationStore(namespace string, blockNum uint64, read
 		blockNum:      version,
 		reads:         make(map[string]KVRead),
 		writes:        make(map[string]KVWrite),
+		collections:   make(map[string]*collectionRwset),
 		readOwnWrites: readOwnWrites,
 	}, nil
 }
@@ -0,0 +1,365 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// testBackend opens a database for the behavioral tests, which run against every backend.
+// postgres_test.go adds Postgres when the tests are built with the postgres tag.
+type testBackend struct {
+	name    string
+	dialect Dialect
+	open    func(t *testing.T) *sql.DB
+}
+
+var testBackends = []testBackend{
+	{name: "sqlite", dialect: SQLite, open: openSQLite},
+}
+
+func openSQLite(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1) // every connection would get its own in-memory database
+	t.Cleanup(func() { db.Close() })
+	if DetectDialect(db) != SQLite {
+		t.Fatal("expected the sqlite dialect to be detected")
+	}
+	return db
+}
+
+// forEachBackend runs the test with an empty VersionedDB on every backend. Every run gets its own channel,
+// so that tests don't see each other's data on a shared database.
+func forEachBackend(t *testing.T, test func(t *testing.T, s *VersionedDB)) {
+	for _, b := range testBackends {
+		t.Run(b.name, func(t *testing.T) {
+			db := b.open(t)
+			channel := "test_" + strings.ToLower(rand.Text())
+			s := NewWithDialect(channel, db, b.dialect)
+			if err := s.Init(); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				db.Exec(fmt.Sprintf("DROP TABLE %s; DROP TABLE %s; DROP TABLE %s; DROP TABLE %s;", s.table, s.txTable, s.dlTable, s.hashTable))
+				db.Exec("DELETE FROM channel_progress WHERE channel = $1", channel)
+			})
+			test(t, s)
+		})
+	}
+}
+
+func TestCommitBlock(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *VersionedDB) {
+		writes := []WriteRecord{
+			{Namespace: "ns", Key: "a", BlockNum: 3, TxNum: 0, Value: []byte("A"), TxID: "tx1"},
+			{Namespace: "ns", Key: "b", BlockNum: 3, TxNum: 2, IsDelete: true, TxID: "tx3"},
+		}
+		txs := []TxRecord{
+			{TxID: "tx1", BlockNum: 3, TxNum: 0, ValidationCode: 0},
+			{TxID: "tx2", BlockNum: 3, TxNum: 1, ValidationCode: 11},
+			{TxID: "tx3", BlockNum: 3, TxNum: 2, ValidationCode: 0},
+		}
+		if err := s.CommitBlock(3, writes, txs); err != nil {
+			t.Fatal(err)
+		}
+		// idempotent
+		if err := s.CommitBlock(3, writes, txs); err != nil {
+			t.Fatal(err)
+		}
+		// empty block
+		if err := s.CommitBlock(4, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		last, err := s.LastProcessedBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last != 4 {
+			t.Errorf("expected last block 4, got %d", last)
+		}
+
+		tx, err := s.GetTx("tx2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx == nil || tx.BlockNum != 3 || tx.TxNum != 1 || tx.ValidationCode != 11 {
+			t.Errorf("unexpected transaction record: %+v", tx)
+		}
+		tx, err = s.GetTx("unknown")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx != nil {
+			t.Errorf("expected no record, got %+v", tx)
+		}
+
+		w, err := s.Get("ns", "a", 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w == nil || string(w.Value) != "A" {
+			t.Errorf("unexpected write: %+v", w)
+		}
+		w, err = s.Get("ns", "a", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w != nil {
+			t.Errorf("expected no write before block 3, got %+v", w)
+		}
+
+		// several blocks in one database transaction
+		err = s.CommitBlocks([]BlockWrites{
+			{BlockNum: 5, Writes: []WriteRecord{{Namespace: "ns", Key: "a", BlockNum: 5, Value: []byte("A5"), TxID: "tx5"}}},
+			{BlockNum: 6, Txs: []TxRecord{{TxID: "tx6", BlockNum: 6}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last, err := s.LastProcessedBlock(); err != nil || last != 6 {
+			t.Errorf("expected last block 6, got %d (%v)", last, err)
+		}
+		if w, err := s.GetCurrent("ns", "a"); err != nil || w == nil || string(w.Value) != "A5" {
+			t.Errorf("unexpected write: %+v (%v)", w, err)
+		}
+	})
+}
+
+func TestStateHash(t *testing.T) {
+	writes := []WriteRecord{
+		{Namespace: "ns", Key: "b", BlockNum: 3, TxNum: 1, Value: []byte("B")},
+		{Namespace: "ns", Key: "a", BlockNum: 3, TxNum: 0, Value: []byte("A")},
+		{Namespace: "ns$$hcoll", Key: "h", BlockNum: 3, TxNum: 0, Value: []byte("hash")},
+	}
+	private := WriteRecord{Namespace: "ns$$pcoll", Key: "p", BlockNum: 3, TxNum: 0, Value: []byte("secret")}
+
+	hash := StateHash(nil, writes)
+	if !bytes.Equal(hash, StateHash(nil, []WriteRecord{writes[2], private, writes[0], writes[1]})) {
+		t.Error("expected the state hash to ignore the order of the writes and the private data")
+	}
+	if bytes.Equal(hash, StateHash([]byte("prev"), writes)) {
+		t.Error("expected the state hash to depend on the previous state hash")
+	}
+	changed := slices.Clone(writes)
+	changed[0].IsDelete = true
+	if bytes.Equal(hash, StateHash(nil, changed)) {
+		t.Error("expected the state hash to depend on deletes")
+	}
+	if keys := StateWrites(append(writes, private)); len(keys) != 3 || keys[0].Key != "a" || keys[1].Key != "b" || keys[2].Key != "h" {
+		t.Errorf("unexpected state writes: %+v", keys)
+	}
+
+	forEachBackend(t, func(t *testing.T, s *VersionedDB) {
+		err := s.CommitBlocks([]BlockWrites{
+			{BlockNum: 3, Writes: append(writes, private), StateHash: hash, CommitHash: []byte("commit")},
+			{BlockNum: 4, StateHash: StateHash(hash, nil)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h, err := s.GetStateHash(3); err != nil || !bytes.Equal(h, hash) {
+			t.Errorf("unexpected state hash %x (%v)", h, err)
+		}
+		if h, err := s.GetStateHash(5); err != nil || h != nil {
+			t.Errorf("expected no state hash, got %x (%v)", h, err)
+		}
+		if h, err := s.GetCommitHash(3); err != nil || string(h) != "commit" {
+			t.Errorf("unexpected commit hash %x (%v)", h, err)
+		}
+		if h, err := s.GetCommitHash(4); err != nil || h != nil {
+			t.Errorf("expected no commit hash, got %x (%v)", h, err)
+		}
+		if w, err := s.GetBlockWrites(3); err != nil || !bytes.Equal(StateHash(nil, w), hash) {
+			t.Errorf("expected the writes of the block to match the state hash, got %+v (%v)", w, err)
+		}
+	})
+}
+
+func TestGetRange(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *VersionedDB) {
+		blocks := [][]WriteRecord{
+			{
+				{Namespace: "ns", Key: "a", BlockNum: 1, TxNum: 0, Value: []byte("A1")},
+				{Namespace: "ns", Key: "b", BlockNum: 1, TxNum: 0, Value: []byte("B1")},
+				{Namespace: "ns", Key: "c", BlockNum: 1, TxNum: 1, Value: []byte("C1")},
+				{Namespace: "other", Key: "b", BlockNum: 1, TxNum: 1, Value: []byte("X")},
+			},
+			{
+				{Namespace: "ns", Key: "a", BlockNum: 2, TxNum: 0, Value: []byte("A2")},
+				{Namespace: "ns", Key: "b", BlockNum: 2, TxNum: 1, IsDelete: true},
+				{Namespace: "ns", Key: "d", BlockNum: 2, TxNum: 1, Value: []byte("D2")},
+			},
+		}
+		for i, writes := range blocks {
+			if err := s.CommitBlock(uint64(i+1), writes, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tests := []struct {
+			start, end string
+			block      uint64
+			expected   []string
+		}{
+			{"a", "c", 1, []string{"a:A1", "b:B1"}},
+			{"a", "", 1, []string{"a:A1", "b:B1", "c:C1"}},
+			{"a", "", 2, []string{"a:A2", "c:C1", "d:D2"}},
+			{"b", "d", 2, []string{"c:C1"}},
+			{"e", "", 2, nil},
+		}
+		for _, tc := range tests {
+			recs, err := s.GetRange("ns", tc.start, tc.end, tc.block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range recs {
+				got = append(got, r.Key+":"+string(r.Value))
+			}
+			if !slices.Equal(got, tc.expected) {
+				t.Errorf("[%q, %q) at block %d: expected %v, got %v", tc.start, tc.end, tc.block, tc.expected, got)
+			}
+		}
+	})
+}
+
+func TestEncodeKey(t *testing.T) {
+	keys := []string{"", "\x00", "\x00a\x00", "\x00\xff", "\x01", "\x01\x00", "\x01\x01", "\x02", "a", "a\x00b", "a\x01", "b"}
+	for i, k := range keys {
+		decoded, err := decodeKey(encodeKey(k))
+		if err != nil || decoded != k {
+			t.Errorf("%q: decoded %q (err: %v)", k, decoded, err)
+		}
+		if strings.Contains(encodeKey(k), "\x00") {
+			t.Errorf("%q: encoded key contains 0x00", k)
+		}
+		// the order of the keys must be preserved
+		if i > 0 && encodeKey(keys[i-1]) >= encodeKey(k) {
+			t.Errorf("%q should sort before %q after encoding", keys[i-1], k)
+		}
+	}
+	if _, err := decodeKey("a\x01"); err == nil {
+		t.Error("expected error for a dangling escape byte")
+	}
+}
+
+func TestCompositeKeys(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *VersionedDB) {
+		var writes []WriteRecord
+		for _, attrs := range [][]string{{"blue", "asset1"}, {"blue", "asset2"}, {"red", "asset3"}, {"bluegreen", "asset4"}} {
+			key, err := CreateCompositeKey("color~name", attrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writes = append(writes, WriteRecord{Namespace: "ns", Key: key, BlockNum: 1, Value: []byte{0x00}})
+		}
+		writes = append(writes, WriteRecord{Namespace: "ns", Key: "blue", BlockNum: 1, Value: []byte("simple")})
+		if err := s.CommitBlock(1, writes, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		recs, err := s.GetByPartialCompositeKey("ns", "color~name", []string{"blue"}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, r := range recs {
+			objectType, attrs, err := SplitCompositeKey(r.Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if objectType != "color~name" || len(attrs) != 2 || attrs[0] != "blue" {
+				t.Errorf("unexpected key %q", r.Key)
+				continue
+			}
+			names = append(names, attrs[1])
+		}
+		if !slices.Equal(names, []string{"asset1", "asset2"}) {
+			t.Errorf("unexpected results %v", names)
+		}
+
+		recs, err = s.GetByPartialCompositeKey("ns", "color~name", nil, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recs) != 4 {
+			t.Errorf("expected 4 composite keys, got %d", len(recs))
+		}
+
+		// range queries over simple keys skip the composite keys
+		recs, err = s.GetRange("ns", emptyKeySubstitute, "", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recs) != 1 || recs[0].Key != "blue" {
+			t.Errorf("expected only the simple key, got %+v", recs)
+		}
+	})
+}
+
+func TestInsertWriteAndHistory(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *VersionedDB) {
+		// binary values must survive a round trip
+		value := []byte{0x00, 0xff, 0x01, 'a'}
+		writes := []WriteRecord{
+			{Namespace: "ns", Key: "k", BlockNum: 1, TxNum: 0, Value: value, TxID: "tx1"},
+			{Namespace: "ns", Key: "k", BlockNum: 2, TxNum: 5, IsDelete: true, TxID: "tx2"},
+		}
+		for _, w := range writes {
+			if err := s.InsertWrite(w); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// idempotent
+		if err := s.InsertWrite(writes[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		history, err := s.GetHistory("ns", "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 2 {
+			t.Fatalf("expected 2 versions, got %d", len(history))
+		}
+		if !bytes.Equal(history[0].Value, value) || history[0].IsDelete || history[0].TxID != "tx1" {
+			t.Errorf("unexpected first version %+v", history[0])
+		}
+		if !history[1].IsDelete || history[1].BlockNum != 2 || history[1].TxNum != 5 {
+			t.Errorf("unexpected second version %+v", history[1])
+		}
+
+		current, err := s.GetCurrent("ns", "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if current == nil || !current.IsDelete {
+			t.Errorf("expected the delete to be the current version, got %+v", current)
+		}
+
+		if err := s.MarkProcessed(nil, 7); err != nil {
+			t.Fatal(err)
+		}
+		// the last block never goes back
+		if err := s.MarkProcessed(nil, 3); err != nil {
+			t.Fatal(err)
+		}
+		last, err := s.LastProcessedBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last != 7 {
+			t.Errorf("expected last block 7, got %d", last)
+		}
+	})
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+)
+
+// Dialect describes the differences between the SQL engines that VersionedDB supports. The queries themselves
+// are shared: they use $N placeholders and ON CONFLICT clauses, which both SQLite and Postgres understand.
+type Dialect struct {
+	Name string
+	// BlobType is the column type for binary values.
+	BlobType string
+	// KeyCollation is added to the key column so that keys are compared byte by byte, like Fabric does.
+	// Range queries depend on it.
+	KeyCollation string
+}
+
+var (
+	// SQLite compares text with memcmp (the BINARY collation) by default.
+	SQLite = Dialect{Name: "sqlite", BlobType: "BLOB"}
+	// Postgres needs the "C" collation for byte order, otherwise the locale of the database decides.
+	// Keys must be valid UTF-8 (the 0x00 of composite keys is escaped, see encodeKey).
+	Postgres = Dialect{Name: "postgres", BlobType: "BYTEA", KeyCollation: `COLLATE "C"`}
+)
+
+// DetectDialect returns Postgres if the database uses the pgx or lib/pq driver, and SQLite otherwise.
+func DetectDialect(db *sql.DB) Dialect {
+	t := reflect.TypeOf(db.Driver())
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	pkg := t.PkgPath()
+	if strings.Contains(pkg, "github.com/jackc/pgx") || strings.Contains(pkg, "github.com/lib/pq") {
+		return Postgres
+	}
+	return SQLite
+}
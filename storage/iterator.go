@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"errors"
+)
+
+// emptyKeySubstitute replaces an empty start key, like in the chaincode shim, so that composite keys
+// (which start with 0x00) are not part of the results.
+const emptyKeySubstitute = "\x01"
+
+// GetStateByRange returns an iterator over the keys in [startKey, endKey) in the snapshot. An empty endKey means
+// there is no upper bound. The keys that are read through the iterator are recorded as a range query.
+// Like in Fabric, writes of the transaction itself are not visible.
+func (s *SimulationStore) GetStateByRange(startKey, endKey string) (*RangeIterator, error) {
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	if err := validateRangeKeys(startKey, endKey); err != nil {
+		return nil, err
+	}
+	return s.rangeQuery(startKey, endKey, 0)
+}
+
+// GetStateByPartialCompositeKey returns an iterator over the composite keys of the object type that start with
+// the given attributes. Like in Fabric, this is recorded as a range query over those keys.
+func (s *SimulationStore) GetStateByPartialCompositeKey(objectType string, attributes []string) (*RangeIterator, error) {
+	startKey, endKey, err := partialCompositeKeyRange(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return s.rangeQuery(startKey, endKey, 0)
+}
+
+// GetStateByRangeWithPagination is like GetStateByRange, but returns at most pageSize results, starting at the
+// bookmark if it is not empty. The bookmark for the next page is available on the iterator.
+// Like in Fabric, paginated queries can't be combined with writes in the same transaction.
+func (s *SimulationStore) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (*RangeIterator, error) {
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	if err := validateRangeKeys(startKey, endKey); err != nil {
+		return nil, err
+	}
+	return s.paginatedQuery(startKey, endKey, pageSize, bookmark)
+}
+
+// GetStateByPartialCompositeKeyWithPagination is the paginated version of GetStateByPartialCompositeKey.
+func (s *SimulationStore) GetStateByPartialCompositeKeyWithPagination(objectType string, attributes []string, pageSize int32, bookmark string) (*RangeIterator, error) {
+	startKey, endKey, err := partialCompositeKeyRange(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return s.paginatedQuery(startKey, endKey, pageSize, bookmark)
+}
+
+func validateRangeKeys(startKey, endKey string) error {
+	for _, k := range []string{startKey, endKey} {
+		if err := validateSimpleKey(k); err != nil {
+			return err
+		}
+	}
+	if endKey != "" && endKey < startKey {
+		return errors.New("end key must be greater than start key")
+	}
+	return nil
+}
+
+func (s *SimulationStore) paginatedQuery(startKey, endKey string, pageSize int32, bookmark string) (*RangeIterator, error) {
+	if pageSize <= 0 {
+		return nil, errors.New("page size must be greater than zero")
+	}
+	if len(s.writes) > 0 {
+		return nil, errors.New("paginated queries are not allowed in a transaction with writes")
+	}
+	if bookmark != "" {
+		if bookmark < startKey || (endKey != "" && bookmark >= endKey) {
+			return nil, errors.New("bookmark is outside of the range")
+		}
+		startKey = bookmark
+	}
+	s.paginated = true
+	return s.rangeQuery(startKey, endKey, int(pageSize))
+}
+
+// rangeQuery records a range query and returns an iterator over its results. If limit is positive, the results
+// are cut off after limit keys and the iterator will not be exhausted.
+func (s *SimulationStore) rangeQuery(startKey, endKey string, limit int) (*RangeIterator, error) {
+	results, err := s.store.GetRange(s.namespace, startKey, endKey, s.blockNum)
+	if err != nil {
+		return nil, err
+	}
+	it := &RangeIterator{
+		results: results,
+		endKey:  endKey,
+	}
+	if limit > 0 && len(results) > limit {
+		it.results = results[:limit]
+		it.bookmark = results[limit].Key
+	}
+
+	it.query = &RangeQuery{StartKey: startKey}
+	s.rangeQueries = append(s.rangeQueries, it.query)
+	return it, nil
+}
+
+// RangeIterator iterates over the results of a range query and records every key that is returned.
+type RangeIterator struct {
+	results  []WriteRecord
+	pos      int
+	endKey   string
+	bookmark string
+	query    *RangeQuery
+}
+
+// HasNext returns whether there are more results. If not, and the results were not cut off by a page size,
+// the range query is marked as exhausted.
+func (it *RangeIterator) HasNext() bool {
+	if it.pos < len(it.results) {
+		return true
+	}
+	if it.bookmark == "" {
+		it.query.ItrExhausted = true
+		it.query.EndKey = it.endKey
+	}
+	return false
+}
+
+// Next returns the next result, or nil if the iterator is exhausted.
+func (it *RangeIterator) Next() (*WriteRecord, error) {
+	if !it.HasNext() {
+		return nil, nil
+	}
+	record := it.results[it.pos]
+	it.pos++
+	it.query.Reads = append(it.query.Reads, KVRead{
+		Key:     record.Key,
+		Version: &Version{BlockNum: record.BlockNum, TxNum: record.TxNum},
+	})
+	// the caller might not call Next again, so the range ends here until we know better.
+	it.query.EndKey = record.Key
+	return &record, nil
+}
+
+// FetchedRecords is the number of results in the (page of the) query.
+func (it *RangeIterator) FetchedRecords() int32 {
+	return int32(len(it.results))
+}
+
+// Bookmark is the start key of the next page of a paginated query, or empty if there are no more results.
+func (it *RangeIterator) Bookmark() string {
+	return it.bookmark
+}
+
+func (it *RangeIterator) Close() error {
+	return nil
+}
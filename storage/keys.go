@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	compositeKeyNamespace = "\x00"
+	minUnicodeRuneValue   = 0
+	maxUnicodeRuneValue   = utf8.MaxRune
+)
+
+// CreateCompositeKey combines an object type and attributes into a key like the chaincode shim does:
+// 0x00 objectType 0x00 attr1 0x00 attr2 0x00 ...
+func CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	if err := validateCompositeKeyAttribute(objectType); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString(compositeKeyNamespace + objectType + string(rune(minUnicodeRuneValue)))
+	for _, attr := range attributes {
+		if err := validateCompositeKeyAttribute(attr); err != nil {
+			return "", err
+		}
+		b.WriteString(attr + string(rune(minUnicodeRuneValue)))
+	}
+	return b.String(), nil
+}
+
+// SplitCompositeKey returns the object type and attributes of a composite key.
+func SplitCompositeKey(compositeKey string) (string, []string, error) {
+	if !strings.HasPrefix(compositeKey, compositeKeyNamespace) || !strings.HasSuffix(compositeKey, string(rune(minUnicodeRuneValue))) || len(compositeKey) < 2 {
+		return "", nil, fmt.Errorf("%q is not a composite key", compositeKey)
+	}
+	components := strings.Split(compositeKey[1:len(compositeKey)-1], string(rune(minUnicodeRuneValue)))
+	return components[0], components[1:], nil
+}
+
+// partialCompositeKeyRange returns the range of keys that start with the composite key of the object type
+// and attributes, like the peer does for GetStateByPartialCompositeKey.
+func partialCompositeKeyRange(objectType string, attributes []string) (string, string, error) {
+	startKey, err := CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return "", "", err
+	}
+	return startKey, startKey + string(rune(maxUnicodeRuneValue)), nil
+}
+
+func validateCompositeKeyAttribute(s string) error {
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("not a valid utf8 string: [%x]", s)
+	}
+	for i, r := range s {
+		if r == minUnicodeRuneValue || r == maxUnicodeRuneValue {
+			return fmt.Errorf("input contains unicode %#U starting at position [%d]. %#U and %#U are not allowed in the input attribute of a composite key",
+				r, i, minUnicodeRuneValue, maxUnicodeRuneValue)
+		}
+	}
+	return nil
+}
+
+// validateSimpleKey checks that a key can be used in a range query, which is not the case for composite keys.
+func validateSimpleKey(key string) error {
+	if strings.HasPrefix(key, compositeKeyNamespace) {
+		return fmt.Errorf("key %q is a composite key and can't be used in a range query", key)
+	}
+	return nil
+}
+
+// Keys are escaped before they are stored, because Postgres doesn't allow 0x00 in text, which every composite
+// key contains. The escaping preserves the byte order of keys, so range queries on the escaped keys return the
+// same results: 0x00 becomes 0x01 0x01, and 0x01 becomes 0x01 0x02. All other bytes are unchanged.
+const escapeByte = 0x01
+
+func encodeKey(key string) string {
+	if !strings.ContainsAny(key, "\x00\x01") {
+		return key
+	}
+	var b strings.Builder
+	b.Grow(len(key) + 2)
+	for i := 0; i < len(key); i++ {
+		switch c := key[i]; c {
+		case 0x00, escapeByte:
+			b.WriteByte(escapeByte)
+			b.WriteByte(c + 1)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func decodeKey(key string) (string, error) {
+	if !strings.Contains(key, "\x01") {
+		return key, nil
+	}
+	var b strings.Builder
+	b.Grow(len(key))
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c != escapeByte {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i == len(key) || (key[i] != 0x01 && key[i] != 0x02) {
+			return "", errors.New("invalid escape sequence in stored key")
+		}
+		b.WriteByte(key[i] - 1)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Private data is stored in the world state table, in separate namespaces per collection like Fabric does:
+// the keys and values in "<namespace>$$p<collection>", and the hashes in "<namespace>$$h<collection>", where
+// the key is the hex encoded hash of the key and the value is the hash of the value. Both have the version
+// of the transaction that wrote them. The hashes are available for every collection, the private data only
+// for the collections that the peer shared with us.
+
+// PrivateNamespace returns the namespace in which the private data of a collection is stored.
+func PrivateNamespace(namespace, collection string) string {
+	return namespace + "$$p" + collection
+}
+
+// HashedNamespace returns the namespace in which the hashes of the private data of a collection are stored.
+func HashedNamespace(namespace, collection string) string {
+	return namespace + "$$h" + collection
+}
+
+// HashedKey returns the key under which a key hash is stored in the hashed namespace.
+func HashedKey(keyHash []byte) string {
+	return hex.EncodeToString(keyHash)
+}
+
+// KeyHash returns the key under which the hash of a private key is stored in the hashed namespace.
+func KeyHash(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return HashedKey(h[:])
+}
+
+// GetPrivateData returns the version of a private key at a certain time, or nil if it does not exist or the
+// private data is not available.
+func (s *VersionedDB) GetPrivateData(namespace, collection, key string, lastBlock uint64) (*WriteRecord, error) {
+	return s.Get(PrivateNamespace(namespace, collection), key, lastBlock)
+}
+
+// GetPrivateDataHash returns the version of the hash of a private key at a certain time. The value
+// of the record is the hash of the private value.
+func (s *VersionedDB) GetPrivateDataHash(namespace, collection, key string, lastBlock uint64) (*WriteRecord, error) {
+	return s.Get(HashedNamespace(namespace, collection), KeyHash(key), lastBlock)
+}
+
+// CollectionReadWriteSet are the reads and writes of a transaction in a private data collection.
+type CollectionReadWriteSet struct {
+	Collection string
+	Reads      []KVRead
+	Writes     []KVWrite
+}
+
+type collectionRwset struct {
+	reads  map[string]KVRead
+	writes map[string]KVWrite
+}
+
+func (s SimulationStore) collection(name string) *collectionRwset {
+	c, ok := s.collections[name]
+	if !ok {
+		c = &collectionRwset{reads: make(map[string]KVRead), writes: make(map[string]KVWrite)}
+		s.collections[name] = c
+	}
+	return c
+}
+
+// GetPrivateData returns the value of a key in a private data collection and records the read. It follows the
+// same rules as GetState. If the hash of the key exists but the private data is not available, an error is
+// returned, because the value can't be known.
+func (s SimulationStore) GetPrivateData(collection, key string) ([]byte, error) {
+	coll := s.collection(collection)
+	if s.readOwnWrites {
+		if record, ok := coll.writes[key]; ok {
+			if record.IsDelete {
+				return nil, nil
+			}
+			return record.Value, nil
+		}
+	}
+
+	record, err := s.store.Get(PrivateNamespace(s.namespace, collection), key, s.blockNum)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		// the private data might not have been shared with us
+		hashed, err := s.store.Get(HashedNamespace(s.namespace, collection), KeyHash(key), s.blockNum)
+		if err != nil {
+			return nil, err
+		}
+		if hashed != nil && !hashed.IsDelete {
+			return nil, fmt.Errorf("private data of %s in collection %s is not available", key, collection)
+		}
+	}
+	return s.recordCollectionRead(coll, key, record), nil
+}
+
+// GetPrivateDataHash returns the hash of the value of a key in a private data collection, which is available
+// even if the private data is not. Like in Fabric, this is recorded as a read of the key.
+func (s SimulationStore) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	record, err := s.store.Get(HashedNamespace(s.namespace, collection), KeyHash(key), s.blockNum)
+	if err != nil {
+		return nil, err
+	}
+	return s.recordCollectionRead(s.collection(collection), key, record), nil
+}
+
+func (s SimulationStore) recordCollectionRead(coll *collectionRwset, key string, record *WriteRecord) []byte {
+	read := KVRead{Key: key}
+	if record != nil {
+		if record.IsDelete {
+			return nil
+		}
+		read.Version = &Version{BlockNum: record.BlockNum, TxNum: record.TxNum}
+	}
+	coll.reads[key] = read
+	if record == nil {
+		return nil
+	}
+	return record.Value
+}
+
+// PutPrivateData records a write to a private data collection.
+func (s SimulationStore) PutPrivateData(collection, key string, value []byte) error {
+	if collection == "" {
+		return fmt.Errorf("collection must not be an empty string")
+	}
+	if len(key) == 0 {
+		return fmt.Errorf("key must not be an empty string")
+	}
+	if len(value) == 0 {
+		return fmt.Errorf("value must not be empty")
+	}
+	if s.paginated {
+		return errPaginatedWrite
+	}
+	s.collection(collection).writes[key] = KVWrite{Key: key, Value: value}
+	return nil
+}
+
+// DelPrivateData records the deletion of a key in a private data collection.
+func (s SimulationStore) DelPrivateData(collection, key string) error {
+	if collection == "" {
+		return fmt.Errorf("collection must not be an empty string")
+	}
+	if s.paginated {
+		return errPaginatedWrite
+	}
+	s.collection(collection).writes[key] = KVWrite{Key: key, IsDelete: true}
+	return nil
+}
+
+// collectionResults returns the reads and writes per collection, sorted by collection name.
+func (s *SimulationStore) collectionResults() []CollectionReadWriteSet {
+	out := make([]CollectionReadWriteSet, 0, len(s.collections))
+	for name, c := range s.collections {
+		if len(c.reads) == 0 && len(c.writes) == 0 {
+			continue
+		}
+		rws := CollectionReadWriteSet{Collection: name}
+		for _, r := range c.reads {
+			rws.Reads = append(rws.Reads, r)
+		}
+		for _, w := range c.writes {
+			rws.Writes = append(rws.Writes, w)
+		}
+		out = append(out, rws)
+	}
+	slices.SortFunc(out, func(a, b CollectionReadWriteSet) int { return strings.Compare(a.Collection, b.Collection) })
+	return out
+}
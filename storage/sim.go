@@ -14,6 +14,10 @@ type SimulationStore struct {
 	blockNum      uint64
 	reads         map[string]KVRead
 	writes        map[string]KVWrite
+	collections   map[string]*collectionRwset
+	rangeQueries  []*RangeQuery
+	paginated     bool
+	event         *Event
 }
 
 type KVRead struct {
@@ -32,8 +36,18 @@ type Version struct {
 	TxNum    uint64
 }
 
+// RangeQuery records the keys that were read by a range query, so that peers can detect phantom reads.
+// EndKey is the requested end key if the iterator is exhausted, or the last key read if not.
+type RangeQuery struct {
+	StartKey     string
+	EndKey       string
+	ItrExhausted bool
+	Reads        []KVRead
+}
+
 type ReadStore interface {
 	Get(string, string, uint64) (*WriteRecord, error)
+	GetRange(string, string, string, uint64) ([]WriteRecord, error)
 }
 
 // GetState behaves similar to in Fabric, with the exception that we _can_ read
@@ -94,6 +108,9 @@ func (s SimulationStore) PutState(key string, value []byte) error {
 	if len(value) == 0 {
 		return errors.New("key is empty")
 	}
+	if s.paginated {
+		return errPaginatedWrite
+	}
 	s.writes[key] = KVWrite{Key: key, Value: value}
 	return nil
 }
@@ -102,19 +119,43 @@ func (s SimulationStore) PutState(key string, value []byte) error {
 // the transaction proposal. The `key` and its value will be deleted from
 // the ledger when the transaction is validated and successfully committed.
 func (s SimulationStore) DelState(key string) error {
+	if s.paginated {
+		return errPaginatedWrite
+	}
 	s.writes[key] = KVWrite{Key: key, IsDelete: true}
 	return nil
 }
 
+// SetEvent sets the chaincode event of the transaction. Like in Fabric, a transaction has at most one event,
+// so calling it again replaces the event.
+func (s *SimulationStore) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("event name can not be empty string")
+	}
+	s.event = &Event{Namespace: s.namespace, Name: name, Payload: payload}
+	return nil
+}
+
+var errPaginatedWrite = errors.New("writes are not allowed in a transaction with paginated queries")
+
 type ReadWriteSet struct {
-	Reads  []KVRead
-	Writes []KVWrite
+	Reads        []KVRead
+	RangeQueries []RangeQuery
+	Writes       []KVWrite
+	Collections  []CollectionReadWriteSet
+	Event        *Event
 }
 
 func (s *SimulationStore) Result() ReadWriteSet {
 	rws := ReadWriteSet{
-		Reads:  make([]KVRead, 0, len(s.reads)),
-		Writes: make([]KVWrite, 0, len(s.writes)),
+		Reads:        make([]KVRead, 0, len(s.reads)),
+		RangeQueries: make([]RangeQuery, 0, len(s.rangeQueries)),
+		Writes:       make([]KVWrite, 0, len(s.writes)),
+		Collections:  s.collectionResults(),
+		Event:        s.event,
+	}
+	for _, q := range s.rangeQueries {
+		rws.RangeQueries = append(rws.RangeQueries, *q)
 	}
 	for _, r := range s.reads {
 		rws.Reads = append(rws.Reads, r)
@@ -125,6 +166,11 @@ func (s *SimulationStore) Result() ReadWriteSet {
 	return rws
 }
 
+// Namespace is the namespace (chaincode) the simulation reads from and writes to.
+func (s *SimulationStore) Namespace() string {
+	return s.namespace
+}
+
 // Version is the blockheight of this snapshot.
 func (s *SimulationStore) Version() uint64 {
 	return s.blockNum
@@ -8,8 +8,9 @@ import (
 )
 
 type mockStore struct {
-	lastBlock uint64
-	getFn     func(ns, key string, block uint64) (*WriteRecord, error)
+	lastBlock  uint64
+	getFn      func(ns, key string, block uint64) (*WriteRecord, error)
+	getRangeFn func(ns, start, end string, block uint64) ([]WriteRecord, error)
 }
 
 func (m *mockStore) LastProcessedBlock() (uint64, error) {
@@ -23,6 +24,13 @@ func (m *mockStore) Get(ns, key string, block uint64) (*WriteRecord, error) {
 	return nil, nil
 }
 
+func (m *mockStore) GetRange(ns, start, end string, block uint64) ([]WriteRecord, error) {
+	if m.getRangeFn != nil {
+		return m.getRangeFn(ns, start, end, block)
+	}
+	return nil, nil
+}
+
 func TestGetState(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -80,11 +88,12 @@ func TestGetState(t *testing.T) {
 
 func newStub() SimulationStore {
 	return SimulationStore{
-		namespace: "ns",
-		store:     nil,
-		blockNum:  1,
-		reads:     make(map[string]KVRead),
-		writes:    make(map[string]KVWrite),
+		namespace:   "ns",
+		store:       nil,
+		blockNum:    1,
+		reads:       make(map[string]KVRead),
+		writes:      make(map[string]KVWrite),
+		collections: make(map[string]*collectionRwset),
 	}
 }
 
@@ -211,3 +220,205 @@ func TestFinalize(t *testing.T) {
 		t.Errorf("expected 1 write, got %d", len(rws.Writes))
 	}
 }
+
+func TestGetStateByRange(t *testing.T) {
+	records := []WriteRecord{
+		{Key: "a", Value: []byte("A"), BlockNum: 1, TxNum: 0},
+		{Key: "b", Value: []byte("B"), BlockNum: 2, TxNum: 3},
+		{Key: "c", Value: []byte("C"), BlockNum: 3, TxNum: 1},
+	}
+	var gotStart, gotEnd string
+	store := &mockStore{
+		getRangeFn: func(ns, start, end string, block uint64) ([]WriteRecord, error) {
+			gotStart, gotEnd = start, end
+			return records, nil
+		},
+	}
+	newSim := func() *SimulationStore {
+		s := newStub()
+		s.store = store
+		return &s
+	}
+
+	t.Run("exhausted", func(t *testing.T) {
+		s := newSim()
+		it, err := s.GetStateByRange("", "d")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for it.HasNext() {
+			rec, err := it.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, rec.Key)
+		}
+		if !reflect.DeepEqual(keys, []string{"a", "b", "c"}) {
+			t.Errorf("unexpected keys %v", keys)
+		}
+		if gotStart != emptyKeySubstitute || gotEnd != "d" {
+			t.Errorf("unexpected range [%q, %q)", gotStart, gotEnd)
+		}
+		rq := s.Result().RangeQueries
+		if len(rq) != 1 || !rq[0].ItrExhausted || rq[0].EndKey != "d" || len(rq[0].Reads) != 3 {
+			t.Fatalf("unexpected range query %+v", rq)
+		}
+		if v := rq[0].Reads[1].Version; v.BlockNum != 2 || v.TxNum != 3 {
+			t.Errorf("unexpected version %+v", v)
+		}
+	})
+
+	t.Run("stopped early", func(t *testing.T) {
+		s := newSim()
+		it, err := s.GetStateByRange("a", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := it.Next(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := it.Next(); err != nil {
+			t.Fatal(err)
+		}
+		rq := s.Result().RangeQueries
+		if len(rq) != 1 || rq[0].ItrExhausted || rq[0].EndKey != "b" || len(rq[0].Reads) != 2 {
+			t.Errorf("unexpected range query %+v", rq)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		s := newSim()
+		if _, err := s.GetStateByRange("b", "a"); err == nil {
+			t.Error("expected error for end key before start key")
+		}
+		if _, err := s.GetStateByRange("\x00type\x00", ""); err == nil {
+			t.Error("expected error for composite key")
+		}
+	})
+}
+
+func TestGetStateByPartialCompositeKeyWithPagination(t *testing.T) {
+	var records []WriteRecord
+	for _, name := range []string{"asset1", "asset2", "asset3"} {
+		key, err := CreateCompositeKey("owner~name", []string{"me", name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, WriteRecord{Key: key, Value: []byte{0x00}, BlockNum: 1})
+	}
+	store := &mockStore{
+		getRangeFn: func(ns, start, end string, block uint64) ([]WriteRecord, error) {
+			var out []WriteRecord
+			for _, r := range records {
+				if r.Key >= start && r.Key < end {
+					out = append(out, r)
+				}
+			}
+			return out, nil
+		},
+	}
+	s := newStub()
+	s.store = store
+
+	it, err := s.GetStateByPartialCompositeKeyWithPagination("owner~name", []string{"me"}, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it.FetchedRecords() != 2 || it.Bookmark() != records[2].Key {
+		t.Fatalf("unexpected page: %d records, bookmark %q", it.FetchedRecords(), it.Bookmark())
+	}
+	for it.HasNext() {
+		if _, err := it.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rq := s.Result().RangeQueries; len(rq) != 1 || rq[0].ItrExhausted || rq[0].EndKey != records[1].Key {
+		t.Errorf("unexpected range query %+v", rq)
+	}
+
+	it, err = s.GetStateByPartialCompositeKeyWithPagination("owner~name", []string{"me"}, 2, it.Bookmark())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it.FetchedRecords() != 1 || it.Bookmark() != "" {
+		t.Errorf("unexpected last page: %d records, bookmark %q", it.FetchedRecords(), it.Bookmark())
+	}
+
+	if err := s.PutState("k", []byte("v")); err == nil {
+		t.Error("expected error writing after a paginated query")
+	}
+}
+
+func TestPrivateData(t *testing.T) {
+	records := map[string]*WriteRecord{
+		PrivateNamespace("ns", "coll") + ":k1":              {Key: "k1", Value: []byte("secret"), BlockNum: 2, TxNum: 1},
+		HashedNamespace("ns", "coll") + ":" + KeyHash("k1"): {Key: KeyHash("k1"), Value: []byte("hash1"), BlockNum: 2, TxNum: 1},
+		// only the hash of k2 is available
+		HashedNamespace("ns", "coll") + ":" + KeyHash("k2"): {Key: KeyHash("k2"), Value: []byte("hash2"), BlockNum: 3, TxNum: 0},
+	}
+	s := newStub()
+	s.store = &mockStore{
+		getFn: func(ns, key string, block uint64) (*WriteRecord, error) {
+			return records[ns+":"+key], nil
+		},
+	}
+
+	val, err := s.GetPrivateData("coll", "k1")
+	if err != nil || string(val) != "secret" {
+		t.Fatalf("unexpected value %q (err: %v)", val, err)
+	}
+	if _, err := s.GetPrivateData("coll", "k2"); err == nil {
+		t.Error("expected an error for private data that is not available")
+	}
+	hash, err := s.GetPrivateDataHash("coll", "k2")
+	if err != nil || string(hash) != "hash2" {
+		t.Fatalf("unexpected hash %q (err: %v)", hash, err)
+	}
+	if val, err := s.GetPrivateData("other", "k1"); err != nil || val != nil {
+		t.Errorf("expected no value in another collection, got %q (err: %v)", val, err)
+	}
+	if err := s.PutPrivateData("coll", "k3", []byte("v3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DelPrivateData("coll", "k1"); err != nil {
+		t.Fatal(err)
+	}
+
+	res := s.Result()
+	if len(res.Reads) != 0 || len(res.Writes) != 0 {
+		t.Errorf("expected no public reads or writes, got %+v", res)
+	}
+	if len(res.Collections) != 2 || res.Collections[0].Collection != "coll" || res.Collections[1].Collection != "other" {
+		t.Fatalf("unexpected collections %+v", res.Collections)
+	}
+	coll := res.Collections[0]
+	if len(coll.Reads) != 2 || len(coll.Writes) != 2 {
+		t.Errorf("expected 2 reads and 2 writes, got %+v", coll)
+	}
+	for _, r := range coll.Reads {
+		if r.Key == "k2" && (r.Version == nil || r.Version.BlockNum != 3) {
+			t.Errorf("expected the version of the hash to be read, got %+v", r.Version)
+		}
+	}
+	if len(res.Collections[1].Reads) != 1 || res.Collections[1].Reads[0].Version != nil {
+		t.Errorf("expected a read without version, got %+v", res.Collections[1])
+	}
+}
+
+func TestSetEvent(t *testing.T) {
+	s := newStub()
+	if err := s.SetEvent("", nil); err == nil {
+		t.Error("expected error for an empty event name")
+	}
+	if err := s.SetEvent("first", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetEvent("second", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	ev := s.Result().Event
+	if ev == nil || ev.Namespace != "ns" || ev.Name != "second" || string(ev.Payload) != "2" {
+		t.Errorf("expected the last event, got %+v", ev)
+	}
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// The state hash proves that two databases hold the same world state, without comparing them key by key. It is
+// a running hash over the blocks: the state hash of a block is the SHA-256 of the state hash of the previous block
+// and the writes of the block. The private data itself is left out, because a peer only has it for the
+// collections of its organization, but the hashes of the private data are included.
+
+// StateHash returns the state hash of a block, from the state hash of the previous block (nil for the first
+// block) and the writes of the block in any order.
+func StateHash(prev []byte, writes []WriteRecord) []byte {
+	h := sha256.New()
+	h.Write(prev)
+	var buf []byte
+	for _, w := range StateWrites(writes) {
+		buf = buf[:0]
+		buf = appendBytes(buf, []byte(w.Namespace))
+		buf = appendBytes(buf, []byte(w.Key))
+		buf = appendBytes(buf, w.Value)
+		buf = binary.BigEndian.AppendUint64(buf, w.BlockNum)
+		buf = binary.BigEndian.AppendUint64(buf, w.TxNum)
+		if w.IsDelete {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+		h.Write(buf)
+	}
+	return h.Sum(nil)
+}
+
+func appendBytes(buf, b []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(b)))
+	return append(buf, b...)
+}
+
+// StateWrites returns the writes that the state hash covers, sorted by namespace, key and version, which is the
+// order in which they are hashed.
+func StateWrites(writes []WriteRecord) []WriteRecord {
+	out := make([]WriteRecord, 0, len(writes))
+	for _, w := range writes {
+		if !strings.Contains(w.Namespace, "$$p") {
+			out = append(out, w)
+		}
+	}
+	slices.SortFunc(out, func(a, b WriteRecord) int {
+		return cmp.Or(
+			strings.Compare(a.Namespace, b.Namespace),
+			strings.Compare(a.Key, b.Key),
+			cmp.Compare(a.BlockNum, b.BlockNum),
+			cmp.Compare(a.TxNum, b.TxNum),
+		)
+	})
+	return out
+}
+
+// GetStateHash returns the state hash of a block, or nil if it was not recorded.
+func (s *VersionedDB) GetStateHash(blockNum uint64) ([]byte, error) {
+	var hash []byte
+	err := s.backend.QueryRow(fmt.Sprintf("SELECT hash FROM %s WHERE block_num = $1", s.hashTable), blockNum).Scan(&hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("query state hash: %w", err)
+	}
+	return hash, nil
+}
+
+// GetCommitHash returns the COMMIT_HASH of a block that the peer set in the block metadata, or nil if it was
+// not recorded.
+func (s *VersionedDB) GetCommitHash(blockNum uint64) ([]byte, error) {
+	var hash []byte
+	err := s.backend.QueryRow(fmt.Sprintf("SELECT commit_hash FROM %s WHERE block_num = $1", s.hashTable), blockNum).Scan(&hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("query commit hash: %w", err)
+	}
+	return hash, nil
+}
+
+// GetBlockWrites returns the writes of a block, including private data.
+func (s *VersionedDB) GetBlockWrites(blockNum uint64) ([]WriteRecord, error) {
+	query := fmt.Sprintf(`
+	SELECT namespace, key, version_block, version_tx, value, is_delete, tx_id
+	FROM %s
+	WHERE version_block = $1
+	ORDER BY version_tx;
+	`, s.table)
+
+	rows, err := s.backend.Query(query, blockNum)
+	if err != nil {
+		return nil, fmt.Errorf("get block writes: %w", err)
+	}
+	defer rows.Close()
+
+	var result []WriteRecord
+	for rows.Next() {
+		w, err := scanWrite(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan block writes: %w", err)
+		}
+		result = append(result, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate block writes: %w", err)
+	}
+	return result, nil
+}
+
+func (s *VersionedDB) insertHashes(tx *sql.Tx, b BlockWrites) error {
+	if b.StateHash == nil && b.CommitHash == nil {
+		return nil
+	}
+	query := fmt.Sprintf(`
+	INSERT INTO %s (block_num, hash, commit_hash)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (block_num) DO NOTHING;
+	`, s.hashTable)
+	if _, err := tx.Exec(query, b.BlockNum, b.StateHash, b.CommitHash); err != nil {
+		return fmt.Errorf("insert block hashes exec: %w", err)
+	}
+	return nil
+}